@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -36,6 +37,49 @@ type App struct {
 	fn2Returns []byte
 
 	logs []string
+
+	policy   DbPolicy
+	timeouts map[string]int
+	caps     AppCapabilities
+}
+
+// app.json in app folder
+type AppManifest struct {
+	Sql          DbPolicy
+	Timeouts     map[string]int //ms per call of asset(key is asset name)
+	Capabilities AppCapabilities
+}
+
+func LoadAppManifest(root *Root, appName string) (AppManifest, error) {
+	var manifest AppManifest
+
+	path := root.folderApps + "/" + appName + "/app.json"
+	if !OsFileExists(path) {
+		return manifest, nil //default
+	}
+
+	js, err := os.ReadFile(path)
+	if err != nil {
+		return manifest, fmt.Errorf("ReadFile(%s) failed: %w", path, err)
+	}
+
+	err = json.Unmarshal(js, &manifest)
+	if err != nil {
+		return manifest, fmt.Errorf("Unmarshal(%s) failed: %w", path, err)
+	}
+	return manifest, nil
+}
+
+func (app *App) loadManifest() error {
+	manifest, err := LoadAppManifest(app.root, app.name)
+	if err != nil {
+		return err
+	}
+
+	app.policy = manifest.Sql
+	app.timeouts = manifest.Timeouts
+	app.caps = manifest.Capabilities
+	return nil
 }
 
 func NewApp(root *Root, name string, db_name string, sts_id int) (*App, error) {
@@ -45,6 +89,11 @@ func NewApp(root *Root, name string, db_name string, sts_id int) (*App, error) {
 	app.db_name = db_name
 	app.sts_id = sts_id
 
+	err := app.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
 	//load assets
 	dir, err := os.ReadDir(app.getPath())
 	if err != nil {
@@ -128,6 +177,16 @@ func (app *App) Tick() {
 	}
 }
 
+// returns error of first crashed asset
+func (app *App) GetCrash() error {
+	for _, asset := range app.assets {
+		if asset.wasm != nil && asset.wasm.crash != nil {
+			return fmt.Errorf("%s: %w", asset.name, asset.wasm.crash)
+		}
+	}
+	return nil
+}
+
 func (app *App) IsReadyToFire() bool {
 	for _, asset := range app.assets {
 		if !asset.IsReadyToFire() {
@@ -149,7 +208,11 @@ func (app *App) Render(startIt bool) {
 	if startIt {
 		app.baseAsset.renderStart()
 	}
-	if app.IsReadyToFire() {
+	if err := app.baseAsset.GetMigrationErr(); err != nil {
+		app.baseAsset.paint_text(0, 0, 1, 1, "Error: "+err.Error(), "", 0, 0, 0, OsCd{250, 50, 50, 255}, -1, 1, 0, 1, 1, 1, 0, 0, 1)
+	} else if err := app.GetCrash(); err != nil {
+		app.baseAsset.paint_text(0, 0, 1, 1, "Crashed: "+err.Error(), "", 0, 0, 0, OsCd{250, 50, 50, 255}, -1, 1, 0, 1, 1, 1, 0, 0, 1)
+	} else if app.IsReadyToFire() {
 		_, err := app.baseAsset.Call("render", nil)
 		if err != nil {
 			fmt.Print(err)
@@ -0,0 +1,132 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// What app may do outside of its db. It's part of app.json: {"Capabilities": {...}}
+type AppCapabilities struct {
+	Dbs      []string //other databases, which can be opened, 'name*' is prefix. App's db is always allowed
+	Files    bool     //list, create, rename, remove, export, import db files and trash
+	Settings bool     //change theme, dpi, languages, etc., save and exit
+	Urls     bool     //open web pages
+	Apps     bool     //list and render other apps
+}
+
+// info keys, which need capability
+var AppCapabilities_FILES_KEYS = []string{"files", "trash", "exports", "snapshots_*", "trash_*", "corrupted_*", "db_size_*", "wal_size_*",
+	"new_file", "rename_file", "duplicate_file", "remove_file", "recover_file", "snapshot_file", "restore_file", "export_file", "import_file", "restore_trash", "purge_trash"}
+var AppCapabilities_SETTINGS_KEYS = []string{"languages", "theme", "date", "dpi", "fullscreen", "stats", "grid", "save", "exit", "duplicate_setting"}
+var AppCapabilities_APPS_KEYS = []string{"apps", "log_*", "permissions_*", "approved_*", "approve_app"}
+
+// returns list for base app: "dbs:a,b/files/settings/urls/apps". Empty = app doesn't need approval
+func (c *AppCapabilities) GetList() string {
+	var list []string
+	if len(c.Dbs) > 0 {
+		list = append(list, "dbs:"+strings.Join(c.Dbs, ","))
+	}
+	if c.Files {
+		list = append(list, "files")
+	}
+	if c.Settings {
+		list = append(list, "settings")
+	}
+	if c.Urls {
+		list = append(list, "urls")
+	}
+	if c.Apps {
+		list = append(list, "apps")
+	}
+	return strings.Join(list, "/")
+}
+
+func (app *App) checkDb(dbName string) error {
+	if len(dbName) == 0 || dbName == app.db_name || DbPolicy_find(app.caps.Dbs, dbName) {
+		return nil
+	}
+	return fmt.Errorf("app(%s) has no capability to open db(%s)", app.name, dbName)
+}
+
+// reading settings is allowed, only change needs capability
+func (app *App) checkInfoKey(key string, set bool) error {
+	key = strings.ToLower(key)
+
+	if DbPolicy_find(AppCapabilities_FILES_KEYS, key) {
+		if !app.caps.Files {
+			return fmt.Errorf("app(%s) has no capability 'Files' for key(%s)", app.name, key)
+		}
+	} else if set && DbPolicy_find(AppCapabilities_SETTINGS_KEYS, key) {
+		if !app.caps.Settings {
+			return fmt.Errorf("app(%s) has no capability 'Settings' for key(%s)", app.name, key)
+		}
+	} else if DbPolicy_find(AppCapabilities_APPS_KEYS, key) {
+		if !app.caps.Apps {
+			return fmt.Errorf("app(%s) has no capability 'Apps' for key(%s)", app.name, key)
+		}
+	}
+	return nil
+}
+
+func (app *App) checkUrls() error {
+	if !app.caps.Urls {
+		return fmt.Errorf("app(%s) has no capability 'Urls'", app.name)
+	}
+	return nil
+}
+
+func (app *App) checkApps() error {
+	if !app.caps.Apps {
+		return fmt.Errorf("app(%s) has no capability 'Apps'", app.name)
+	}
+	return nil
+}
+
+// approval is saved with capabilities, so user sees them again when they are changed
+func (root *Root) IsAppApproved(appName string, caps *AppCapabilities) bool {
+	list := caps.GetList()
+	if list == "" {
+		return true
+	}
+	for _, it := range root.ui.io.ini.Approved {
+		if it == appName+":"+list {
+			return true
+		}
+	}
+	return false
+}
+
+func (root *Root) ApproveApp(appName string) error {
+	manifest, err := LoadAppManifest(root, appName)
+	if err != nil {
+		return err
+	}
+
+	//remove old approval
+	ini := &root.ui.io.ini
+	n := 0
+	for _, it := range ini.Approved {
+		if !strings.HasPrefix(it, appName+":") {
+			ini.Approved[n] = it
+			n++
+		}
+	}
+	ini.Approved = append(ini.Approved[:n], appName+":"+manifest.Capabilities.GetList())
+	return nil
+}
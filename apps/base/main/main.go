@@ -121,6 +121,9 @@ type Storage struct {
 
 	createFile    string
 	duplicateName string
+
+	importBundle string
+	passphrase   string
 }
 type Translations struct {
 	SAVE            string
@@ -158,6 +161,20 @@ type Translations struct {
 	RENAME      string
 	DUPLICATE   string
 	CREATE_FILE string
+	EXPORT      string
+	IMPORT      string
+	PASSPHRASE  string
+	NO_EXPORTS  string
+	CORRUPTED   string
+	RECOVER     string
+
+	PERMISSIONS   string
+	PERM_DBS      string
+	PERM_FILES    string
+	PERM_SETTINGS string
+	PERM_URLS     string
+	PERM_APPS     string
+	ALLOW         string
 
 	ALREADY_EXISTS string
 	EMPTY_FIELD    string
@@ -448,6 +465,39 @@ func Apps(file *File, file_i int) {
 
 }
 
+// capabilities from app.json, which user must allow before first run
+func Permissions(appName string) {
+	SA_ColMax(0, 12)
+
+	SA_Text(appName+": "+trns.PERMISSIONS).Show(0, 0, 1, 1)
+
+	y := 1
+	for _, perm := range strings.Split(SA_Info("permissions_"+appName), "/") {
+		tp, dbs, _ := strings.Cut(perm, ":")
+
+		text := perm
+		switch tp {
+		case "dbs":
+			text = trns.PERM_DBS + ": " + strings.ReplaceAll(dbs, ",", ", ")
+		case "files":
+			text = trns.PERM_FILES
+		case "settings":
+			text = trns.PERM_SETTINGS
+		case "urls":
+			text = trns.PERM_URLS
+		case "apps":
+			text = trns.PERM_APPS
+		}
+		SA_Text("- "+text).Show(0, y, 1, 1)
+		y++
+	}
+	y++
+
+	if SA_Button(trns.ALLOW).Show(0, y, 1, 1).click {
+		SA_InfoSet("approve_app", appName)
+	}
+}
+
 func ProjectFiles() {
 	inf_files := SA_Info("files")
 	inf_apps := SA_Info("apps")
@@ -576,6 +626,29 @@ func Files() {
 				SA_DialogOpen("fileContext_"+file.Name, 1)
 			}
 
+			//corruption found by host
+			corrupted := SA_Info("corrupted_" + file.Name)
+			if len(corrupted) > 0 {
+				if SA_ButtonStyle("!", &styles.ButtonDanger).Title(trns.CORRUPTED).Show(4, 0, 1, 1).click {
+					SA_DialogOpen("CorruptedFile_"+file.Name, 1)
+				}
+			}
+
+			if SA_DialogStart("CorruptedFile_" + file.Name) {
+
+				SA_ColMax(0, 9)
+
+				SA_Text(trns.CORRUPTED).FrontCd(SA_ThemeError()).Show(0, 0, 1, 1)
+				problem, _, _ := strings.Cut(corrupted, "\n")
+				SA_Text(problem).Title(corrupted).Show(0, 1, 1, 1)
+				if SA_Button(trns.RECOVER).Show(0, 2, 1, 1).click {
+					SA_InfoSet("recover_file", file.Name)
+					SA_DialogClose()
+				}
+
+				SA_DialogEnd()
+			}
+
 			if SA_DialogStart("fileContext_" + file.Name) {
 				SA_ColMax(0, 5)
 
@@ -595,6 +668,12 @@ func Files() {
 					SA_DialogOpen("RemoveFileConfirm_"+file.Name, 1)
 				}
 
+				if SA_ButtonMenu(trns.EXPORT).Show(0, 3, 1, 1).click {
+					SA_DialogClose()
+					SA_DialogOpen("ExportFile_"+file.Name, 1)
+					store.passphrase = ""
+				}
+
 				SA_DialogEnd()
 			}
 
@@ -628,6 +707,20 @@ func Files() {
 				SA_DialogEnd()
 			}
 
+			if SA_DialogStart("ExportFile_" + file.Name) {
+
+				SA_ColMax(0, 9)
+
+				SA_Editbox(&store.passphrase).TempToValue(true).ShowDescription(0, 0, 1, 1, trns.PASSPHRASE, 3, 0)
+				if SA_Button(trns.EXPORT).Enable(len(store.passphrase) > 0).Show(0, 1, 1, 1).click {
+					SA_InfoSet("export_file", file.Name+"/"+store.passphrase)
+					store.passphrase = ""
+					SA_DialogClose()
+				}
+
+				SA_DialogEnd()
+			}
+
 			if SA_DialogStart("RemoveFileConfirm_" + file.Name) {
 				if SA_DialogConfirm() {
 					if store.SelectedFile == file_i {
@@ -795,9 +888,20 @@ func Files() {
 	//new database
 	SA_DivStart(0, y, 1, 1)
 	{
+		SA_Col(1, 3)
 		if SA_Button("+").Title(trns.CREATE_DB).Show(0, 0, 1, 1).click {
 			SA_DialogOpen("newFile", 1)
 		}
+
+		if SA_ButtonAlpha(trns.IMPORT).Show(1, 0, 1, 1).click {
+			SA_DialogOpen("importFile", 1)
+			store.importBundle = ""
+			store.passphrase = ""
+		}
+		if SA_DialogStart("importFile") {
+			ImportFile()
+			SA_DialogEnd()
+		}
 		if SA_DialogStart("newFile") {
 
 			SA_ColMax(0, 9)
@@ -816,6 +920,37 @@ func Files() {
 	SA_DivEnd()
 }
 
+func ImportFile() {
+	SA_ColMax(0, 9)
+
+	var bundles []string
+	inf := SA_Info("exports")
+	if len(inf) > 0 {
+		bundles = strings.Split(inf, "/")
+	}
+
+	y := 0
+	if len(bundles) == 0 {
+		SA_Text(trns.NO_EXPORTS).Show(0, y, 1, 1)
+		y++
+	}
+	for _, b := range bundles {
+		if SA_ButtonMenu(b).Highlight(b == store.importBundle, &styles.ButtonMenuSelected).Show(0, y, 1, 1).click {
+			store.importBundle = b
+		}
+		y++
+	}
+
+	SA_Editbox(&store.passphrase).TempToValue(true).ShowDescription(0, y, 1, 1, trns.PASSPHRASE, 3, 0)
+	y++
+
+	if SA_Button(trns.IMPORT).Enable(len(store.importBundle) > 0 && len(store.passphrase) > 0).Show(0, y, 1, 1).click {
+		SA_InfoSet("import_file", store.importBundle+"/"+store.passphrase)
+		store.passphrase = ""
+		SA_DialogClose()
+	}
+}
+
 func CheckFileName(name string, alreadyExist bool) error {
 
 	empty := len(name) == 0
@@ -876,7 +1011,11 @@ func render() uint32 {
 	app := FindSelectedApp()
 	if app != nil {
 		SA_DivStartName(1, 0, 1, 2, strconv.Itoa(app.Sts_id)+"_"+strconv.Itoa(file.Sts_id))
-		SA_RenderApp(app.Name, file.Name, app.Sts_id)
+		if SA_InfoFloat("approved_"+app.Name) > 0 {
+			SA_RenderApp(app.Name, file.Name, app.Sts_id)
+		} else {
+			Permissions(app.Name)
+		}
 		SA_DivEnd()
 	} else if file != nil {
 		SA_DivStartName(1, 0, 1, 2, "_tables_"+strconv.Itoa(file.Sts_id))
@@ -435,9 +435,9 @@ func EditEvent(rowid int64) {
 
 			//send file into db - maybe hex()? ...
 			if rowid >= 0 {
-				SA_SqlWrite("", fmt.Sprintf("UPDATE events SET start=%d, end=%d, title='%s', description='%s' WHERE rowid=%d;", start, end, store.event_title, store.event_description, rowid))
+				SA_SqlWriteParams("", "UPDATE events SET start=?, end=?, title=?, description=? WHERE rowid=?;", start, end, store.event_title, store.event_description, rowid)
 			} else {
-				SA_SqlWrite("", fmt.Sprintf("INSERT INTO events(start, end, title, description) VALUES(%d, %d, '%s', '%s');", start, end, store.event_title, store.event_description))
+				SA_SqlWriteParams("", "INSERT INTO events(start, end, title, description) VALUES(?, ?, ?, ?);", start, end, store.event_title, store.event_description)
 			}
 			SA_DialogClose()
 		}
@@ -701,7 +701,7 @@ func ModeMonth() {
 						SA_DivSetInfo("scrollVnarrow", 1)
 						//paintRect(borderWidth:0.03, margin: 0.1, color: themeGrey())
 						SA_ColMax(0, 100)
-						for i := int64(0); i < query.row_count; i++ {
+						for i := int64(0); i < query.RowCount(); i++ {
 							SA_Row(int(i), 0.7)
 						}
 
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS events(title TEXT, description TEXT, start INTEGER, end INTEGER);
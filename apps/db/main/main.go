@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Storage struct {
@@ -81,6 +82,10 @@ type Translations struct {
 	AVG   string
 	SUM   string
 	COUNT string
+
+	HISTORY    string
+	NO_HISTORY string
+	RESTORE    string
 }
 
 type FilterItem struct {
@@ -208,7 +213,8 @@ func (table *Table) UpdateColumn(old string, new string) {
 func GetDbStructure() []*Table {
 	var tables []*Table
 
-	qt := SA_SqlRead("", "SELECT name FROM sqlite_master WHERE type = 'table'")
+	//internal tables(sync, search, history, etc.) are hidden
+	qt := SA_SqlRead("", "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE '\\_sa\\_%' ESCAPE '\\' AND name NOT LIKE '\\_history\\_%' ESCAPE '\\' AND name NOT LIKE 'sqlite\\_%' ESCAPE '\\'")
 	var tname string
 	for qt.Next(&tname) {
 
@@ -971,6 +977,14 @@ func ColumnDetail(table *Table, column *Column) {
 	//properties
 	SA_DivStart(0, 4, 1, 3)
 	{
+		SA_ColMax(0, 100)
+
+		//history is per table
+		history := SA_SqlHistoryEnabled("", table.Name)
+		if SA_Checkbox(&history, trns.HISTORY).Show(0, 2, 1, 1) {
+			SA_SqlHistory("", table.Name, history)
+		}
+
 		if column.Render == "RATING" {
 			SA_ColMax(0, 100)
 			SA_Editbox(&column.Prop_rating_max_stars).ShowDescription(0, 0, 1, 1, trns.MAX_STARS, 4, 0)
@@ -1272,9 +1286,7 @@ func TableRows(table *Table) {
 		var stat *SA_Sql
 		if len(query) > 0 {
 			stat = SA_SqlRead("", query)
-			if stat != nil {
-				stat.row_i = uint64(st)
-			}
+			stat.Seek(st)
 		}
 		values := make([]string, ncols)
 		args := make([]interface{}, ncols)
@@ -1310,7 +1322,7 @@ func TableRows(table *Table) {
 
 						if SA_DialogStart("RowId_" + values[x]) {
 							SA_ColMax(0, 5)
-							SA_Row(1, 0.5)
+							SA_Row(2, 0.5)
 
 							if SA_ButtonMenu(trns.DUPLICATE).Show(0, 0, 1, 1).click {
 
@@ -1319,9 +1331,14 @@ func TableRows(table *Table) {
 								SA_DialogClose()
 							}
 
-							SA_RowSpacer(0, 1, 1, 1)
+							if SA_ButtonMenu(trns.HISTORY).Enable(SA_SqlHistoryEnabled("", table.Name)).Show(0, 1, 1, 1).click {
+								SA_DialogClose()
+								SA_DialogOpen("RowHistory_"+values[x], 1)
+							}
+
+							SA_RowSpacer(0, 2, 1, 1)
 
-							if SA_ButtonDangerMenu(trns.REMOVE).Show(0, 2, 1, 1).click {
+							if SA_ButtonDangerMenu(trns.REMOVE).Show(0, 3, 1, 1).click {
 								SA_SqlWrite("", "DELETE FROM "+table.Name+" WHERE rowid="+values[x]+";")
 								SA_DialogClose()
 							}
@@ -1329,6 +1346,11 @@ func TableRows(table *Table) {
 							SA_DialogEnd()
 						}
 
+						if SA_DialogStart("RowHistory_" + values[x]) {
+							RowHistory(table, values[x])
+							SA_DialogEnd()
+						}
+
 					} else if IsBlob(col.Type) {
 
 						r, err := strconv.Atoi(values[x])
@@ -1439,6 +1461,49 @@ func TableRows(table *Table) {
 	}
 	SA_DivEnd()
 }
+
+// old versions of row, click on value restores it
+func RowHistory(table *Table, rowid string) {
+	rid, _ := strconv.Atoi(rowid)
+	q := SA_SqlHistoryRow("", table.Name, int64(rid))
+	cols := q.Columns() //_h_id, _h_time, _h_asset, _h_op, values
+
+	SA_ColMax(0, 4)
+	SA_ColMax(1, 3)
+	for i := 4; i < len(cols); i++ {
+		SA_ColMax(i-2, 4)
+		SA_Text(cols[i].Name).Show(i-2, 0, 1, 1)
+	}
+
+	values := make([]string, len(cols))
+	args := make([]interface{}, len(cols))
+	for i := range values {
+		args[i] = &values[i]
+	}
+
+	zone := int64(SA_InfoFloat("time_zone") * 3600)
+	y := 1
+	for q.Next(args...) {
+		tm, _ := strconv.ParseFloat(values[1], 64)
+		SA_Text(time.Unix(int64(tm)+zone, 0).UTC().Format("2006-01-02 15:04:05")).Show(0, y, 1, 1)
+		SA_Text(values[3]+" "+values[2]).Show(1, y, 1, 1)
+
+		if values[3] != "INSERT" { //insert has no old values
+			for i := 4; i < len(cols); i++ {
+				if SA_ButtonLight(values[i]).Title(trns.RESTORE).Show(i-2, y, 1, 1).click {
+					hid, _ := strconv.Atoi(values[0])
+					SA_SqlWriteParams("", fmt.Sprintf("UPDATE %s SET %s=(SELECT %s FROM _history_%s WHERE _h_id=?) WHERE rowid=?;", table.Name, cols[i].Name, cols[i].Name, table.Name), hid, rid)
+				}
+			}
+		}
+		y++
+	}
+
+	if y == 1 {
+		SA_Text(trns.NO_HISTORY).Show(0, y, 2, 1)
+	}
+}
+
 func TableStats(table *Table) {
 
 	//columns sizes
@@ -1456,13 +1521,15 @@ func TableStats(table *Table) {
 	q, num_cols := GetQueryStats(table)
 	values := make([]string, num_cols)
 	if len(q) > 0 {
-		stat = SA_SqlRead("", q)
+		stat = SA_SqlReadAsync("", true, q) //aggregates can be slow
 
-		args := make([]interface{}, num_cols)
-		for i := range values {
-			args[i] = &values[i]
+		if stat.IsReady() {
+			args := make([]interface{}, num_cols)
+			for i := range values {
+				args[i] = &values[i]
+			}
+			stat.Next(args...)
 		}
-		stat.Next(args...)
 	}
 
 	stat_i := 0
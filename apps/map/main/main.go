@@ -231,7 +231,7 @@ func Map(cam *Cam) {
 			tileCoord_sx := (x - bbStart.X) * tileW
 			tileCoord_sy := (y - bbStart.Y) * tileH
 
-			q := SA_SqlRead("", "SELECT rowid FROM tiles WHERE name=='"+strconv.Itoa(int(zoom))+"-"+strconv.Itoa(int(x))+"-"+strconv.Itoa(int(y))+".png'")
+			q := SA_SqlReadParams("", "SELECT rowid FROM tiles WHERE name==?", strconv.Itoa(int(zoom))+"-"+strconv.Itoa(int(x))+"-"+strconv.Itoa(int(y))+".png")
 			var rowid int
 			if q.Next(&rowid) {
 				file := SA_ResourceBuildDbPath("", "tiles", "file", rowid)
@@ -285,7 +285,7 @@ func Map(cam *Cam) {
 				SA_ColMax(0, 5)
 
 				if SA_Editbox(&title).Show(0, 0, 1, 1).finished {
-					SA_SqlWrite("", fmt.Sprintf("UPDATE locators SET title='%s' WHERE rowid=%d;", title, rowid))
+					SA_SqlWriteParams("", "UPDATE locators SET title=? WHERE rowid=?;", title, rowid)
 				}
 				SA_Text(fmt.Sprintf("Lon: %.3f", ln)).Show(0, 1, 1, 1)
 				SA_Text(fmt.Sprintf("Lat: %.3f", lt)).Show(0, 2, 1, 1)
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS tiles(name TEXT, file BLOB);
+CREATE INDEX IF NOT EXISTS tiles_name ON tiles(name);
+
+CREATE TABLE IF NOT EXISTS locators(title TEXT, pos TEXT);
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 )
 
 var store Storage
@@ -90,6 +91,25 @@ type SA_Sql struct {
 func SA_SqlRead(db string, query string) *SA_Sql {
 
 	query_hash := _sa_sql_read(_SA_stringToPtr(db), _SA_stringToPtr(query))
+	return _SA_sqlNew(db, query, query_hash)
+}
+
+// parameters are bind to '?' in query
+func SA_SqlReadParams(db string, query string, params ...interface{}) *SA_Sql {
+
+	query_hash := _sa_sql_readParams(_SA_stringToPtr(db), _SA_stringToPtr(query), _SA_bytesToPtr(_SA_sqlParams(params)))
+	return _SA_sqlNew(db, query, query_hash)
+}
+
+// Query runs on worker, so long query doesn't block rendering. Rows can be read when IsReady() returns true. 'redraw' schedules redraw when query finishes.
+// Rows after the first window are read by worker too: Next() returns false and IsReady() is false until they are loaded, then redraw is scheduled.
+func SA_SqlReadAsync(db string, redraw bool, query string, params ...interface{}) *SA_Sql {
+
+	query_hash := _sa_sql_readAsync(_SA_stringToPtr(db), _SA_stringToPtr(query), _SA_bytesToPtr(_SA_sqlParams(params)), int64(_SA_boolToUint32(redraw)))
+	return _SA_sqlNew(db, query, query_hash)
+}
+
+func _SA_sqlNew(db string, query string, query_hash int64) *SA_Sql {
 	if query_hash == -1 {
 		return nil
 	}
@@ -98,11 +118,69 @@ func SA_SqlRead(db string, query string) *SA_Sql {
 	sql.db = db
 	sql.query = query
 	sql.query_hash = query_hash
-	sql.row_count = _sa_sql_readRowCount(_SA_stringToPtr(sql.db), _SA_stringToPtr(sql.query), sql.query_hash)
+	sql.row_count = -1 //counted lazily
 
 	return &sql
 }
 
+// async query is finished
+func (sql *SA_Sql) IsReady() bool {
+	if sql == nil {
+		return false
+	}
+	return _sa_sql_readAsyncStatus(_SA_stringToPtr(sql.db), sql.query_hash) > 0
+}
+
+func (sql *SA_Sql) RowCount() int64 {
+	if sql == nil {
+		return 0
+	}
+
+	if sql.row_count < 0 {
+		sql.row_count = _sa_sql_readRowCount(_SA_stringToPtr(sql.db), _SA_stringToPtr(sql.query), sql.query_hash)
+	}
+	return sql.row_count
+}
+
+type SA_SqlColumn struct {
+	Name     string
+	Type     string //declared type, empty for expressions
+	Nullable int    //1 = yes, 0 = no, -1 = unknown
+}
+
+func (sql *SA_Sql) Columns() []SA_SqlColumn {
+	if sql == nil {
+		return nil
+	}
+
+	sz := _sa_sql_readColumnsLen(_SA_stringToPtr(sql.db), _SA_stringToPtr(sql.query), sql.query_hash)
+	if sz <= 0 {
+		return nil
+	}
+
+	data := make([]byte, sz)
+	if _sa_sql_readColumns(_SA_stringToPtr(sql.db), _SA_stringToPtr(sql.query), sql.query_hash, _SA_bytesToPtr(data)) != 1 {
+		return nil
+	}
+
+	//3 items per column
+	cols := make([]SA_SqlColumn, _arrayCount(data)/3)
+	outs := make([]interface{}, 0, len(cols)*3)
+	for i := range cols {
+		outs = append(outs, &cols[i].Name, &cols[i].Type, &cols[i].Nullable)
+	}
+	_arrayToArgs(data, outs...)
+
+	return cols
+}
+
+// next Next() will return row 'row_i'
+func (sql *SA_Sql) Seek(row_i int) {
+	if sql != nil {
+		sql.row_i = uint64(row_i)
+	}
+}
+
 func (sql *SA_Sql) Next(outs ...interface{}) bool {
 
 	if sql == nil {
@@ -134,6 +212,136 @@ func SA_SqlWrite(db string, query string) int64 {
 	return _sa_sql_write(_SA_stringToPtr(db), _SA_stringToPtr(query))
 }
 
+// parameters are bind to '?' in query
+func SA_SqlWriteParams(db string, query string, params ...interface{}) int64 {
+	return _sa_sql_writeParams(_SA_stringToPtr(db), _SA_stringToPtr(query), _SA_bytesToPtr(_SA_sqlParams(params)))
+}
+
+func _SA_sqlParams(params []interface{}) []byte {
+	data := make([]byte, 0, 256) //pre-alloc
+	for _, it := range params {
+		data = _argsToArray(data, it)
+	}
+	return data
+}
+
+// Writes are committed together or not at all. Transaction must be finished in same or next frame, otherwise it's rolled back.
+// Other assets can't write into 'db' until transaction is finished. Reads don't see uncommitted changes.
+func SA_SqlBegin(db string) bool {
+	return _sa_sql_begin(_SA_stringToPtr(db)) > 0
+}
+func SA_SqlCommit(db string) bool {
+	return _sa_sql_commit(_SA_stringToPtr(db)) > 0
+}
+func SA_SqlRollback(db string) bool {
+	return _sa_sql_rollback(_SA_stringToPtr(db)) > 0
+}
+
+// Savepoints can be used only inside SA_SqlBegin() and SA_SqlCommit()
+func SA_SqlSavepoint(db string, name string) bool {
+	return _sa_sql_savepoint(_SA_stringToPtr(db), _SA_stringToPtr(name)) > 0
+}
+func SA_SqlRelease(db string, name string) bool {
+	return _sa_sql_release(_SA_stringToPtr(db), _SA_stringToPtr(name)) > 0
+}
+func SA_SqlRollbackTo(db string, name string) bool {
+	return _sa_sql_rollbackTo(_SA_stringToPtr(db), _SA_stringToPtr(name)) > 0
+}
+
+// Reverts last committed writes into db. Returns false if there is nothing to undo
+func SA_SqlUndo(db string) bool {
+	return _sa_sql_undo(_SA_stringToPtr(db)) > 0
+}
+func SA_SqlRedo(db string) bool {
+	return _sa_sql_redo(_SA_stringToPtr(db)) > 0
+}
+
+// host keeps full-text index of 'columns' in 'table'. No columns = index is removed
+func SA_SqlSearchable(db string, table string, columns ...string) bool {
+	return _sa_sql_searchable(_SA_stringToPtr(db), _SA_stringToPtr(table), _SA_stringToPtr(strings.Join(columns, "/"))) > 0
+}
+
+type SA_SqlSearchHit struct {
+	Rowid   int64
+	Rank    float64 //lower is better
+	Snippet string  //found words are inside [ and ]
+}
+
+// every word of 'query' must be found, last word can be prefix
+func SA_SqlSearch(db string, table string, query string, max int) []SA_SqlSearchHit {
+	sz := _sa_sql_searchLen(_SA_stringToPtr(db), _SA_stringToPtr(table), _SA_stringToPtr(query), uint64(max))
+	if sz <= 0 {
+		return nil
+	}
+
+	data := make([]byte, sz)
+	if _sa_sql_search(_SA_stringToPtr(db), _SA_stringToPtr(table), _SA_stringToPtr(query), uint64(max), _SA_bytesToPtr(data)) != 1 {
+		return nil
+	}
+
+	//3 items per hit
+	hits := make([]SA_SqlSearchHit, _arrayCount(data)/3)
+	outs := make([]interface{}, 0, len(hits)*3)
+	for i := range hits {
+		outs = append(outs, &hits[i].Rowid, &hits[i].Rank, &hits[i].Snippet)
+	}
+	_arrayToArgs(data, outs...)
+
+	return hits
+}
+
+// host copies old row versions into _history_<table>
+func SA_SqlHistory(db string, table string, enable bool) bool {
+	return _sa_sql_history(_SA_stringToPtr(db), _SA_stringToPtr(table), int64(_SA_boolToUint32(enable))) > 0
+}
+func SA_SqlHistoryEnabled(db string, table string) bool {
+	return _sa_sql_history(_SA_stringToPtr(db), _SA_stringToPtr(table), -1) > 0
+}
+
+// columns: _h_id, _h_time(seconds), _h_asset(NULL = external change), _h_op(INSERT/UPDATE/DELETE), old values. Newest version is first.
+func SA_SqlHistoryRow(db string, table string, rowid int64) *SA_Sql {
+	query_hash := _sa_sql_historyRow(_SA_stringToPtr(db), _SA_stringToPtr(table), rowid)
+	return _SA_sqlNew(db, "", query_hash)
+}
+
+// columns: rowid, values. Rows are same as they were at 'time'(seconds, see SA_InfoFloat("time_utc"))
+func SA_SqlHistoryAsOf(db string, table string, time float64) *SA_Sql {
+	query_hash := _sa_sql_historyAsOf(_SA_stringToPtr(db), _SA_stringToPtr(table), time)
+	return _SA_sqlNew(db, "", query_hash)
+}
+
+// Exported function 'fn' can be used in queries as 'name'(wasm only, not in debug mode). It returns value or calls SA_CallSetReturn(). Function is available from next frame.
+func SA_SqlFunction(db string, name string, fn string) bool {
+	return _sa_sql_function(_SA_stringToPtr(db), _SA_stringToPtr(name), _SA_stringToPtr(fn)) > 0
+}
+
+// Exports are called with id of group as first argument: 'init'(id) is optional, 'step'(id, args...) gets rows one by one and 'final'(id) returns value.
+func SA_SqlAggregate(db string, name string, init string, step string, final string) bool {
+	return _sa_sql_aggregate(_SA_stringToPtr(db), _SA_stringToPtr(name), _SA_stringToPtr(init), _SA_stringToPtr(step), _SA_stringToPtr(final)) > 0
+}
+
+// Returns version of query's result. Host re-runs query after commits into tables it reads and schedules redraw only when result was changed, so app doesn't need "nosleep". Returns -1 on error.
+func SA_SqlSubscribe(db string, query string, params ...interface{}) int64 {
+	return _sa_sql_subscribe(_SA_stringToPtr(db), _SA_stringToPtr(query), _SA_bytesToPtr(_SA_sqlParams(params)))
+}
+
+var _SA_dbChangedFn func(db string, tables []string)
+
+// 'fn' is called when database file was changed by other process. 'tables' is empty when they are unknown.
+func SA_SqlSetChangedCallback(fn func(db string, tables []string)) {
+	_SA_dbChangedFn = fn
+}
+
+func _SA_dbChanged(db string, tables string) {
+	if _SA_dbChangedFn != nil {
+		var tbls []string
+		if len(tables) > 0 {
+			tbls = strings.Split(tables, "/")
+		}
+		_SA_dbChangedFn(db, tbls)
+	}
+}
+
 /* -------------------- Layouts -------------------- */
 
 func SA_ColResize(pos int, val float64) float64 {
@@ -336,13 +544,31 @@ func _argsToArray(data []byte, arg interface{}) []byte {
 		data = _SA_appendUint64(data, uint64(len(v)))
 		data = append(data, v...)
 	case string:
-		data = append(data, _SA_TpBytes)
+		data = append(data, _SA_TpString)
 		data = _SA_appendUint64(data, uint64(len(v)))
 		data = append(data, v...)
 	}
 	return data
 }
 
+func _arrayCount(args []byte) int {
+	n := 0
+	p := 0
+	for p < len(args) {
+		tp := args[p]
+		p += 1
+
+		arg := _SA_getUint64(args[p:])
+		p += 8
+
+		if tp == _SA_TpBytes || tp == _SA_TpString {
+			p += int(arg)
+		}
+		n++
+	}
+	return n
+}
+
 func _arrayToArgs(args []byte, outs ...interface{}) {
 	p := 0
 	i := 0
@@ -428,7 +654,7 @@ func _arrayToArgs(args []byte, outs ...interface{}) {
 				*v = fmt.Sprintf("%f", vv)
 			}
 
-		case _SA_TpBytes:
+		case _SA_TpBytes, _SA_TpString:
 			//clone
 			arr_n := int(arg)
 			arr := make([]byte, arr_n)
@@ -1381,6 +1607,7 @@ const _SA_TpI64 = byte(0x7e)
 const _SA_TpF32 = byte(0x7d)
 const _SA_TpF64 = byte(0x7c)
 const _SA_TpBytes = byte(0x7b)
+const _SA_TpString = byte(0x7a)
 
 func _SA_putUint64(b []byte, v uint64) {
 	_ = b[7] // early bounds check to guarantee safety of writes below
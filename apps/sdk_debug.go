@@ -83,6 +83,12 @@ func main() {
 			_arrayToArgs(args, &js)
 			json.Unmarshal(js, &trns)
 
+		case "_sa_db_changed":
+			var db []byte
+			var tables []byte
+			_arrayToArgs(args, &db, &tables)
+			_SA_dbChanged(string(db), string(tables))
+
 		default:
 			log.Panic("Unknown function: ", string(fnName))
 		}
@@ -290,6 +296,26 @@ func _sa_sql_read(dbMem SAMem, queryMem SAMem) int64 {
 	return ret
 }
 
+func _sa_sql_writeParams(dbMem SAMem, queryMem SAMem, paramsMem SAMem) int64 {
+	WriteUint64(15)
+	WriteMem(dbMem)
+	WriteMem(queryMem)
+	WriteMem(paramsMem)
+	ret := int64(ReadUint64())
+	_checkRead(15)
+	return ret
+}
+
+func _sa_sql_readParams(dbMem SAMem, queryMem SAMem, paramsMem SAMem) int64 {
+	WriteUint64(16)
+	WriteMem(dbMem)
+	WriteMem(queryMem)
+	WriteMem(paramsMem)
+	ret := int64(ReadUint64())
+	_checkRead(16)
+	return ret
+}
+
 func _sa_sql_readRowCount(dbMem SAMem, queryMem SAMem, queryHash int64) int64 {
 	WriteUint64(12)
 	WriteMem(dbMem)
@@ -324,6 +350,201 @@ func _sa_sql_readRow(dbMem SAMem, queryMem SAMem, queryHash int64, row_i uint64,
 	return ret
 }
 
+func _sa_sql_readColumnsLen(dbMem SAMem, queryMem SAMem, queryHash int64) int64 {
+	WriteUint64(17)
+	WriteMem(dbMem)
+	WriteMem(queryMem)
+	WriteUint64(uint64(queryHash))
+	ret := int64(ReadUint64())
+	_checkRead(17)
+	return ret
+}
+
+func _sa_sql_readColumns(dbMem SAMem, queryMem SAMem, queryHash int64, resultMem SAMem) int64 {
+	WriteUint64(18)
+	WriteMem(dbMem)
+	WriteMem(queryMem)
+	WriteUint64(uint64(queryHash))
+
+	ReadMem(resultMem)
+	ret := int64(ReadUint64())
+	_checkRead(18)
+	return ret
+}
+
+//-------
+
+func _sa_sql_begin(dbMem SAMem) int64 {
+	WriteUint64(30)
+	WriteMem(dbMem)
+	ret := int64(ReadUint64())
+	_checkRead(30)
+	return ret
+}
+
+func _sa_sql_commit(dbMem SAMem) int64 {
+	WriteUint64(31)
+	WriteMem(dbMem)
+	ret := int64(ReadUint64())
+	_checkRead(31)
+	return ret
+}
+
+func _sa_sql_rollback(dbMem SAMem) int64 {
+	WriteUint64(32)
+	WriteMem(dbMem)
+	ret := int64(ReadUint64())
+	_checkRead(32)
+	return ret
+}
+
+func _sa_sql_savepoint(dbMem SAMem, nameMem SAMem) int64 {
+	WriteUint64(33)
+	WriteMem(dbMem)
+	WriteMem(nameMem)
+	ret := int64(ReadUint64())
+	_checkRead(33)
+	return ret
+}
+
+func _sa_sql_release(dbMem SAMem, nameMem SAMem) int64 {
+	WriteUint64(34)
+	WriteMem(dbMem)
+	WriteMem(nameMem)
+	ret := int64(ReadUint64())
+	_checkRead(34)
+	return ret
+}
+
+func _sa_sql_rollbackTo(dbMem SAMem, nameMem SAMem) int64 {
+	WriteUint64(35)
+	WriteMem(dbMem)
+	WriteMem(nameMem)
+	ret := int64(ReadUint64())
+	_checkRead(35)
+	return ret
+}
+
+func _sa_sql_undo(dbMem SAMem) int64 {
+	WriteUint64(36)
+	WriteMem(dbMem)
+	ret := int64(ReadUint64())
+	_checkRead(36)
+	return ret
+}
+
+func _sa_sql_redo(dbMem SAMem) int64 {
+	WriteUint64(37)
+	WriteMem(dbMem)
+	ret := int64(ReadUint64())
+	_checkRead(37)
+	return ret
+}
+
+func _sa_sql_searchable(dbMem SAMem, tableMem SAMem, columnsMem SAMem) int64 {
+	WriteUint64(90)
+	WriteMem(dbMem)
+	WriteMem(tableMem)
+	WriteMem(columnsMem)
+	ret := int64(ReadUint64())
+	_checkRead(90)
+	return ret
+}
+
+func _sa_sql_searchLen(dbMem SAMem, tableMem SAMem, queryMem SAMem, max uint64) int64 {
+	WriteUint64(91)
+	WriteMem(dbMem)
+	WriteMem(tableMem)
+	WriteMem(queryMem)
+	WriteUint64(max)
+	ret := int64(ReadUint64())
+	_checkRead(91)
+	return ret
+}
+
+func _sa_sql_search(dbMem SAMem, tableMem SAMem, queryMem SAMem, max uint64, resultMem SAMem) int64 {
+	WriteUint64(92)
+	WriteMem(dbMem)
+	WriteMem(tableMem)
+	WriteMem(queryMem)
+	WriteUint64(max)
+
+	ReadMem(resultMem)
+	ret := int64(ReadUint64())
+	_checkRead(92)
+	return ret
+}
+
+func _sa_sql_history(dbMem SAMem, tableMem SAMem, enable int64) int64 {
+	WriteUint64(93)
+	WriteMem(dbMem)
+	WriteMem(tableMem)
+	WriteUint64(uint64(enable))
+	ret := int64(ReadUint64())
+	_checkRead(93)
+	return ret
+}
+
+func _sa_sql_historyRow(dbMem SAMem, tableMem SAMem, rowid int64) int64 {
+	WriteUint64(94)
+	WriteMem(dbMem)
+	WriteMem(tableMem)
+	WriteUint64(uint64(rowid))
+	ret := int64(ReadUint64())
+	_checkRead(94)
+	return ret
+}
+
+func _sa_sql_historyAsOf(dbMem SAMem, tableMem SAMem, time float64) int64 {
+	WriteUint64(95)
+	WriteMem(dbMem)
+	WriteMem(tableMem)
+	WriteFloat64(time)
+	ret := int64(ReadUint64())
+	_checkRead(95)
+	return ret
+}
+
+func _sa_sql_function(dbMem SAMem, nameMem SAMem, fnMem SAMem) int64 {
+	WriteUint64(96)
+	WriteMem(dbMem)
+	WriteMem(nameMem)
+	WriteMem(fnMem)
+	ret := int64(ReadUint64())
+	_checkRead(96)
+	return ret
+}
+
+func _sa_sql_subscribe(dbMem SAMem, queryMem SAMem, paramsMem SAMem) int64 {
+	WriteUint64(97)
+	WriteMem(dbMem)
+	WriteMem(queryMem)
+	WriteMem(paramsMem)
+	ret := int64(ReadUint64())
+	_checkRead(97)
+	return ret
+}
+
+func _sa_sql_readAsync(dbMem SAMem, queryMem SAMem, paramsMem SAMem, redraw int64) int64 {
+	WriteUint64(98)
+	WriteMem(dbMem)
+	WriteMem(queryMem)
+	WriteMem(paramsMem)
+	WriteUint64(uint64(redraw))
+	ret := int64(ReadUint64())
+	_checkRead(98)
+	return ret
+}
+
+func _sa_sql_readAsyncStatus(dbMem SAMem, queryHash int64) int64 {
+	WriteUint64(99)
+	WriteMem(dbMem)
+	WriteUint64(uint64(queryHash))
+	ret := int64(ReadUint64())
+	_checkRead(99)
+	return ret
+}
+
 //-------
 
 func _sa_div_colResize(pos uint64, nameMem SAMem, val float64) float64 {
@@ -823,6 +1044,18 @@ func _sa_register_style(jsMem SAMem) int64 {
 	return ret
 }
 
+func _sa_sql_aggregate(dbMem SAMem, nameMem SAMem, initMem SAMem, stepMem SAMem, finalMem SAMem) int64 {
+	WriteUint64(101)
+	WriteMem(dbMem)
+	WriteMem(nameMem)
+	WriteMem(initMem)
+	WriteMem(stepMem)
+	WriteMem(finalMem)
+	ret := int64(ReadUint64())
+	_checkRead(101)
+	return ret
+}
+
 func _sa_div_drag(groupName SAMem, id uint64) int64 {
 	WriteUint64(110)
 	WriteMem(groupName)
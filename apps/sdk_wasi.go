@@ -19,6 +19,9 @@ func _sa_init(storeMem SAMem, stylesMem SAMem) {
 	}
 }
 
+// Optional: app can export _sa_storage_version() int64(0 when it's missing). When storage was saved with other version, app can
+// export _sa_migrate(oldVersion int64, jsonMem SAMem), convert old json and return new one with SA_CallSetReturn(). It's called before _sa_init().
+
 //export _sa_exit
 func _sa_exit() {
 	js, written := save()
@@ -41,6 +44,11 @@ func _sa_translations_set(jsonMem SAMem) {
 	json.Unmarshal(_SA_ptrToBytes(jsonMem), &trns)
 }
 
+//export _sa_db_changed
+func _sa_db_changed(dbMem SAMem, tablesMem SAMem) {
+	_SA_dbChanged(string(_SA_ptrToBytes(dbMem)), string(_SA_ptrToBytes(tablesMem)))
+}
+
 //export _sa_info_float
 func _sa_info_float(keyMem SAMem) float64
 
@@ -68,6 +76,12 @@ func _sa_sql_write(dbMem SAMem, queryMem SAMem) int64
 //export _sa_sql_read
 func _sa_sql_read(dbMem SAMem, queryMem SAMem) int64
 
+//export _sa_sql_writeParams
+func _sa_sql_writeParams(dbMem SAMem, queryMem SAMem, paramsMem SAMem) int64
+
+//export _sa_sql_readParams
+func _sa_sql_readParams(dbMem SAMem, queryMem SAMem, paramsMem SAMem) int64
+
 //export _sa_sql_readRowCount
 func _sa_sql_readRowCount(dbMem SAMem, queryMem SAMem, queryHash int64) int64
 
@@ -77,6 +91,69 @@ func _sa_sql_readRowLen(dbMem SAMem, queryMem SAMem, queryHash int64, row_i uint
 //export _sa_sql_readRow
 func _sa_sql_readRow(dbMem SAMem, queryMem SAMem, queryHash int64, row_i uint64, resultMem SAMem) int64
 
+//export _sa_sql_readColumnsLen
+func _sa_sql_readColumnsLen(dbMem SAMem, queryMem SAMem, queryHash int64) int64
+
+//export _sa_sql_readColumns
+func _sa_sql_readColumns(dbMem SAMem, queryMem SAMem, queryHash int64, resultMem SAMem) int64
+
+//export _sa_sql_begin
+func _sa_sql_begin(dbMem SAMem) int64
+
+//export _sa_sql_commit
+func _sa_sql_commit(dbMem SAMem) int64
+
+//export _sa_sql_rollback
+func _sa_sql_rollback(dbMem SAMem) int64
+
+//export _sa_sql_savepoint
+func _sa_sql_savepoint(dbMem SAMem, nameMem SAMem) int64
+
+//export _sa_sql_release
+func _sa_sql_release(dbMem SAMem, nameMem SAMem) int64
+
+//export _sa_sql_rollbackTo
+func _sa_sql_rollbackTo(dbMem SAMem, nameMem SAMem) int64
+
+//export _sa_sql_undo
+func _sa_sql_undo(dbMem SAMem) int64
+
+//export _sa_sql_redo
+func _sa_sql_redo(dbMem SAMem) int64
+
+//export _sa_sql_searchable
+func _sa_sql_searchable(dbMem SAMem, tableMem SAMem, columnsMem SAMem) int64
+
+//export _sa_sql_searchLen
+func _sa_sql_searchLen(dbMem SAMem, tableMem SAMem, queryMem SAMem, max uint64) int64
+
+//export _sa_sql_search
+func _sa_sql_search(dbMem SAMem, tableMem SAMem, queryMem SAMem, max uint64, resultMem SAMem) int64
+
+//export _sa_sql_history
+func _sa_sql_history(dbMem SAMem, tableMem SAMem, enable int64) int64
+
+//export _sa_sql_historyRow
+func _sa_sql_historyRow(dbMem SAMem, tableMem SAMem, rowid int64) int64
+
+//export _sa_sql_historyAsOf
+func _sa_sql_historyAsOf(dbMem SAMem, tableMem SAMem, time float64) int64
+
+//export _sa_sql_function
+func _sa_sql_function(dbMem SAMem, nameMem SAMem, fnMem SAMem) int64
+
+//export _sa_sql_aggregate
+func _sa_sql_aggregate(dbMem SAMem, nameMem SAMem, initMem SAMem, stepMem SAMem, finalMem SAMem) int64
+
+//export _sa_sql_subscribe
+func _sa_sql_subscribe(dbMem SAMem, queryMem SAMem, paramsMem SAMem) int64
+
+//export _sa_sql_readAsync
+func _sa_sql_readAsync(dbMem SAMem, queryMem SAMem, paramsMem SAMem, redraw int64) int64
+
+//export _sa_sql_readAsyncStatus
+func _sa_sql_readAsyncStatus(dbMem SAMem, queryHash int64) int64
+
 //export _sa_div_colResize
 func _sa_div_colResize(pos uint64, nameMem SAMem, val float64) float64
 
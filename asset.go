@@ -40,6 +40,12 @@ type Asset struct {
 	sts_rowid int
 
 	styles *DivStyles
+
+	migrations map[string]error //result per db
+
+	storage_version int //exported by module, saved with storage
+
+	dirty bool //subscribed query was changed
 }
 
 func (asset *Asset) AddLogErr(err error) bool {
@@ -56,7 +62,7 @@ func NewAsset(app *App, name string) (*Asset, error) {
 	asset.name = name
 
 	var err error
-	asset.sts_rowid, err = app.root.settings.FindOrAdd(asset.app.sts_id, asset.name)
+	asset.sts_rowid, err = app.root.settings.FindOrAdd(asset.app.sts_id, asset.name, asset.app.db_name)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +87,14 @@ func (asset *Asset) Destroy() {
 
 	asset.SaveData()
 
+	for _, db := range asset.app.root.dbs {
+		//unfinished transactions
+		if db.explicit != nil && db.explicit.asset == asset {
+			asset.AddLogErr(db.RollbackExplicit(asset))
+		}
+		db.RemoveAsset(asset)
+	}
+
 	if asset.wasm != nil {
 		asset.wasm.Destroy()
 	}
@@ -94,11 +108,23 @@ func (asset *Asset) IsReadyToFire() bool {
 		return asset.debug.conn != nil
 	}
 	if asset.wasm != nil {
-		return asset.wasm.mod != nil
+		return asset.wasm.mod != nil && asset.wasm.crash == nil
 	}
 	return false
 }
 
+func (asset *Asset) getStorageVersion() int {
+	return asset.storage_version
+}
+
+// ms per wasm call
+func (asset *Asset) getTimeout() int {
+	if t, found := asset.app.timeouts[asset.name]; found && t > 0 {
+		return t
+	}
+	return AssetWasm_TIMEOUT
+}
+
 func (asset *Asset) Call(fnName string, args []byte) (int64, error) {
 	var ret int64
 	var err error
@@ -156,6 +182,10 @@ func (asset *Asset) getTranslationsPath() string {
 	return asset.getResourcesPath() + "/translations.json"
 }
 
+func (asset *Asset) getMigrationsPath() string {
+	return asset.getResourcesPath() + "/migrations"
+}
+
 func (asset *Asset) getWasmPath() string {
 	return asset.getPath() + "/main.wasm"
 }
@@ -187,12 +217,65 @@ func (asset *Asset) UpdateResources() {
 	sort.Strings(asset.resourceFiles)
 }
 
+// converts storage from older version with optional _sa_migrate(oldVersion, json) export
+func (asset *Asset) migrateStorage(version int, jsStore []byte) ([]byte, error) {
+	if asset.debug != nil {
+		return jsStore, fmt.Errorf("storage version(%d) can't be migrated in debug mode", version)
+	}
+	if asset.wasm == nil || asset.wasm.mod == nil || asset.wasm.mod.ExportedFunction("_sa_migrate") == nil {
+		return jsStore, nil //fields, which don't match, are dropped
+	}
+
+	var data []byte
+	data = append(data, TpI64)
+	data = binary.LittleEndian.AppendUint64(data, uint64(version))
+	data = append(data, TpBytes)
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(jsStore)))
+	data = append(data, jsStore...)
+
+	_, err := asset.Call("_sa_migrate", data)
+	if err != nil {
+		return nil, err
+	}
+
+	//returned by SA_CallSetReturn()
+	if len(asset.app.fn2Returns) > 0 {
+		vals, err := _arrayToParams(asset.app.fn2Returns)
+		if err != nil {
+			return nil, err
+		}
+		if js, ok := vals[0].([]byte); ok {
+			return js, nil
+		}
+		if js, ok := vals[0].(string); ok {
+			return []byte(js), nil
+		}
+	}
+	return jsStore, nil
+}
+
 func (asset *Asset) loadData() {
-	jsStore, err := asset.app.root.settings.GetContent(asset.sts_rowid)
+	content, err := asset.app.root.settings.GetContent(asset.sts_rowid)
 	if asset.AddLogErr(err) {
 		return
 	}
 
+	version, jsStore := AssetStorage_unwrap(content)
+	asset.storage_version = version //debug mode keeps stored version
+	if asset.wasm != nil && asset.wasm.mod != nil {
+		v, err := asset.wasm.GetStorageVersion()
+		if !asset.AddLogErr(err) {
+			asset.storage_version = v
+		}
+	}
+
+	if len(content) > 0 && version != asset.getStorageVersion() {
+		jsStore, err = asset.migrateStorage(version, jsStore)
+		if asset.AddLogErr(err) && jsStore == nil {
+			return
+		}
+	}
+
 	defs := DivStyles_getDefaults(asset)
 	jsStyles, err := json.MarshalIndent(&defs, "", "")
 	if asset.AddLogErr(err) {
@@ -231,11 +314,13 @@ func (asset *Asset) Tick() {
 		changed, err := asset.wasm.Tick()
 		if err != nil {
 			asset.AddLogErr(err)
-			asset.wasm = nil
+			if asset.wasm.mod == nil {
+				asset.wasm = nil //nothing to roll back to
+			}
 
 		} else if changed {
-			loadTranslations = true
-			loadData = true
+			loadTranslations = true //data were loaded by LoadModule()
+			asset.migrations = nil  //new version may have new migrations
 		}
 	}
 
@@ -230,7 +230,7 @@ func (ad *AssetDebug) Call(fnName string, args []byte, asset *Asset) (int64, err
 		case 10:
 			db := ad.ReadBytes()
 			query := ad.ReadBytes()
-			ret, err := asset.sql_write(string(db), string(query))
+			ret, err := asset.sql_write(string(db), string(query), nil)
 			asset.AddLogErr(err)
 			ad.WriteUint64(uint64(ret))
 			ad._checkRead(fnTp)
@@ -238,7 +238,7 @@ func (ad *AssetDebug) Call(fnName string, args []byte, asset *Asset) (int64, err
 		case 11:
 			db := ad.ReadBytes()
 			query := ad.ReadBytes()
-			ret, err := asset.sql_read(string(db), string(query))
+			ret, err := asset.sql_read(string(db), string(query), nil)
 			asset.AddLogErr(err)
 			ad.WriteUint64(uint64(ret))
 			ad._checkRead(fnTp)
@@ -273,6 +273,43 @@ func (ad *AssetDebug) Call(fnName string, args []byte, asset *Asset) (int64, err
 			ad.WriteUint64(uint64(ret))
 			ad._checkRead(fnTp)
 
+		case 15:
+			db := ad.ReadBytes()
+			query := ad.ReadBytes()
+			params := ad.ReadBytes()
+			ret, err := asset.sql_write(string(db), string(query), params)
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 16:
+			db := ad.ReadBytes()
+			query := ad.ReadBytes()
+			params := ad.ReadBytes()
+			ret, err := asset.sql_read(string(db), string(query), params)
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 17:
+			db := ad.ReadBytes()
+			query := ad.ReadBytes()
+			queryHash := int64(ad.ReadUint64())
+			dst, err := asset.sql_readColumns(string(db), string(query), queryHash)
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(OsTrn(err == nil, len(dst), -1)))
+			ad._checkRead(fnTp)
+
+		case 18:
+			db := ad.ReadBytes()
+			query := ad.ReadBytes()
+			queryHash := int64(ad.ReadUint64())
+			dst, err := asset.sql_readColumns(string(db), string(query), queryHash)
+			asset.AddLogErr(err)
+			ad.WriteBytes(dst)
+			ad.WriteUint64(uint64(OsTrn(err == nil, 1, -1)))
+			ad._checkRead(fnTp)
+
 		case 20:
 			pos := ad.ReadUint64()
 			name := string(ad.ReadBytes())
@@ -348,6 +385,158 @@ func (ad *AssetDebug) Call(fnName string, args []byte, asset *Asset) (int64, err
 			ad.WriteFloat64(ret)
 			ad._checkRead(fnTp)
 
+		case 30:
+			db := ad.ReadBytes()
+			ret, err := asset.sql_begin(string(db))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 31:
+			db := ad.ReadBytes()
+			ret, err := asset.sql_commit(string(db))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 32:
+			db := ad.ReadBytes()
+			ret, err := asset.sql_rollback(string(db))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 33:
+			db := ad.ReadBytes()
+			name := ad.ReadBytes()
+			ret, err := asset.sql_savepoint(string(db), string(name))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 34:
+			db := ad.ReadBytes()
+			name := ad.ReadBytes()
+			ret, err := asset.sql_release(string(db), string(name))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 35:
+			db := ad.ReadBytes()
+			name := ad.ReadBytes()
+			ret, err := asset.sql_rollbackTo(string(db), string(name))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 36:
+			db := ad.ReadBytes()
+			ret, err := asset.sql_undo(string(db))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 37:
+			db := ad.ReadBytes()
+			ret, err := asset.sql_redo(string(db))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 90:
+			db := ad.ReadBytes()
+			table := ad.ReadBytes()
+			columns := ad.ReadBytes()
+			ret, err := asset.sql_searchable(string(db), string(table), string(columns))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 91:
+			db := ad.ReadBytes()
+			table := ad.ReadBytes()
+			query := ad.ReadBytes()
+			max := ad.ReadUint64()
+			dst, err := asset.sql_search(string(db), string(table), string(query), max)
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(OsTrn(err == nil, len(dst), -1)))
+			ad._checkRead(fnTp)
+
+		case 92:
+			db := ad.ReadBytes()
+			table := ad.ReadBytes()
+			query := ad.ReadBytes()
+			max := ad.ReadUint64()
+			dst, err := asset.sql_search(string(db), string(table), string(query), max)
+			asset.AddLogErr(err)
+			ad.WriteBytes(dst)
+			ad.WriteUint64(uint64(OsTrn(err == nil, 1, -1)))
+			ad._checkRead(fnTp)
+
+		case 93:
+			db := ad.ReadBytes()
+			table := ad.ReadBytes()
+			enable := int64(ad.ReadUint64())
+			ret, err := asset.sql_history(string(db), string(table), enable)
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 94:
+			db := ad.ReadBytes()
+			table := ad.ReadBytes()
+			rowid := int64(ad.ReadUint64())
+			ret, err := asset.sql_historyRow(string(db), string(table), rowid)
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 95:
+			db := ad.ReadBytes()
+			table := ad.ReadBytes()
+			time := ad.ReadFloat64()
+			ret, err := asset.sql_historyAsOf(string(db), string(table), time)
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 96:
+			db := ad.ReadBytes()
+			name := ad.ReadBytes()
+			fn := ad.ReadBytes()
+			ret, err := asset.sql_function(string(db), string(name), string(fn))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 97:
+			db := ad.ReadBytes()
+			query := ad.ReadBytes()
+			params := ad.ReadBytes()
+			ret, err := asset.sql_subscribe(string(db), string(query), params)
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 98:
+			db := ad.ReadBytes()
+			query := ad.ReadBytes()
+			params := ad.ReadBytes()
+			redraw := int64(ad.ReadUint64())
+			ret, err := asset.sql_readAsync(string(db), string(query), params, redraw)
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
+		case 99:
+			db := ad.ReadBytes()
+			queryHash := int64(ad.ReadUint64())
+			ret, err := asset.sql_readAsyncStatus(string(db), queryHash)
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
 		case 40:
 			name := string(ad.ReadBytes())
 			tp := ad.ReadUint64()
@@ -683,6 +872,17 @@ func (ad *AssetDebug) Call(fnName string, args []byte, asset *Asset) (int64, err
 			ad.WriteUint64(uint64(ret))
 			ad._checkRead(fnTp)
 
+		case 101:
+			db := ad.ReadBytes()
+			name := ad.ReadBytes()
+			init := ad.ReadBytes()
+			step := ad.ReadBytes()
+			final := ad.ReadBytes()
+			ret, err := asset.sql_aggregate(string(db), string(name), string(init), string(step), string(final))
+			asset.AddLogErr(err)
+			ad.WriteUint64(uint64(ret))
+			ad._checkRead(fnTp)
+
 		case 110:
 			groupName := string(ad.ReadBytes())
 			id := ad.ReadUint64()
@@ -710,7 +910,10 @@ func (ad *AssetDebug) Call(fnName string, args []byte, asset *Asset) (int64, err
 			db := string(ad.ReadBytes())
 			sts_id := ad.ReadUint64()
 
-			ret, err := asset.render_app(app, db, sts_id)
+			ret, err := int64(-1), asset.app.checkApps()
+			if err == nil {
+				ret, err = asset.render_app(app, db, sts_id)
+			}
 			asset.AddLogErr(err)
 			ad.WriteUint64(uint64(ret))
 			ad._checkRead(fnTp)
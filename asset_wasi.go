@@ -17,40 +17,64 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"os"
+	"time"
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
 	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
 )
 
+const AssetWasm_TIMEOUT = 3000   //ms per call, app.json can change it per asset
+const AssetWasm_MAX_PAGES = 4096 //64KB pages of linear memory per module(256MB)
+
 type AssetWasm struct {
 	asset *Asset
 
-	rt     wazero.Runtime
 	mod    api.Module
 	malloc api.Function
 	free   api.Function
 
 	load_tm int64
+
+	executing bool //some export is running, SQL function can't enter module
+
+	crash error //limit was hit or module trapped. It's loaded again when file changes
 }
 
 func NewAssetWasm(asset *Asset) (*AssetWasm, error) {
 	var aw AssetWasm
 	aw.asset = asset
 
-	err := aw.InstantiateEnv()
-
-	return &aw, err
+	return &aw, nil
 }
 
 func (aw *AssetWasm) SaveData() {
-	if aw.mod != nil {
-		aw.mod.ExportedFunction("_sa_exit").Call(aw.asset.app.root.ctx)
+	if aw.mod != nil && aw.crash == nil { //crashed module may have broken data
+		ctx, cancel := aw.getContext()
+		defer cancel()
+		aw.mod.ExportedFunction("_sa_exit").Call(ctx)
+	}
+}
+
+// time limit for one call, host calls don't count into it. Runtime closes module when it's hit. Host functions find asset in it.
+func (aw *AssetWasm) getContext() (context.Context, context.CancelFunc) {
+	ctx := AssetWasm_withAsset(aw.asset.app.root.ctx, aw.asset)
+	return AssetWasm_newBudget(ctx, time.Duration(aw.asset.getTimeout())*time.Millisecond)
+}
+
+// module isn't closed here, because host function(which called it) can still use its memory
+func (aw *AssetWasm) setCrash(fnName string, ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("function(%s) exceeded %dms", fnName, aw.asset.getTimeout())
 	}
+	aw.crash = err
+	return err
 }
 
 func (aw *AssetWasm) destroyMod() {
@@ -62,92 +86,237 @@ func (aw *AssetWasm) destroyMod() {
 
 func (aw *AssetWasm) Destroy() {
 	aw.destroyMod()
-	aw.rt.Close(aw.asset.app.root.ctx)
 }
 
-func (aw *AssetWasm) InstantiateEnv() error {
-
-	aw.rt = wazero.NewRuntimeWithConfig(aw.asset.app.root.ctx, aw.asset.app.root.runtimeConfig)
-	wasi_snapshot_preview1.MustInstantiate(aw.asset.app.root.ctx, aw.rt)
-
-	env := aw.rt.NewHostModuleBuilder("env")
-
-	//these function are constraint into particular 'asset'!!!
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_info_float).Export("_sa_info_float")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_info_setFloat).Export("_sa_info_setFloat")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_info_string).Export("_sa_info_string")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_info_string_len).Export("_sa_info_string_len")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_info_setString).Export("_sa_info_setString")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_resource).Export("_sa_resource")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_resource_len).Export("_sa_resource_len")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_storage_write).Export("_sa_storage_write")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_sql_write).Export("_sa_sql_write")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_sql_read).Export("_sa_sql_read")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_sql_readRowCount).Export("_sa_sql_readRowCount")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_sql_readRowLen).Export("_sa_sql_readRowLen")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_sql_readRow).Export("_sa_sql_readRow")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_colResize).Export("_sa_div_colResize")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_rowResize).Export("_sa_div_rowResize")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_colMax).Export("_sa_div_colMax")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_rowMax).Export("_sa_div_rowMax")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_col).Export("_sa_div_col")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_row).Export("_sa_div_row")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_start).Export("_sa_div_start")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_end).Export("_sa_div_end")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_dialogOpen).Export("_sa_div_dialogOpen")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_dialogClose).Export("_sa_div_dialogClose")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_dialogStart).Export("_sa_div_dialogStart")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_dialogEnd).Export("_sa_div_dialogEnd")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_get_info).Export("_sa_div_get_info")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_set_info).Export("_sa_div_set_info")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_drag).Export("_sa_div_drag")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_div_drop).Export("_sa_div_drop")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_register_style).Export("_sa_register_style")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_render_app).Export("_sa_render_app")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_paint_rect).Export("_sa_paint_rect")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_paint_circle).Export("_sa_paint_circle")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_paint_line).Export("_sa_paint_line")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_paint_file).Export("_sa_paint_file")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_paint_title).Export("_sa_paint_title")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_paint_text).Export("_sa_paint_text")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_paint_textWidth).Export("_sa_paint_textWidth")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_paint_cursor).Export("_sa_paint_cursor")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_fn_call).Export("_sa_fn_call")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_fn_setReturn).Export("_sa_fn_setReturn")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_fn_getReturn).Export("_sa_fn_getReturn")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_swp_drawButton).Export("_sa_swp_drawButton")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_swp_drawSlider).Export("_sa_swp_drawSlider")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_swp_drawProgress).Export("_sa_swp_drawProgress")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_swp_drawText).Export("_sa_swp_drawText")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_swp_drawEdit).Export("_sa_swp_drawEdit")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_swp_getEditValue).Export("_sa_swp_getEditValue")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_swp_drawCombo).Export("_sa_swp_drawCombo")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_swp_drawCheckbox).Export("_sa_swp_drawCheckbox")
-
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_print).Export("_sa_print")
-	env.NewFunctionBuilder().WithFunc(aw.asset._sa_print_float).Export("_sa_print_float")
-
-	_, err := env.Instantiate(aw.asset.app.root.ctx)
+// host function, asset is taken from call context. Types: 'i' = i64, 'u' = i32, 'f' = f64
+type AssetWasmHostFunc struct {
+	params  string
+	results string
+	fn      func(a *Asset, s []uint64)
+}
+
+var AssetWasm_HOST_FUNCS = map[string]AssetWasmHostFunc{
+	"_sa_info_float":      {"i", "f", func(a *Asset, s []uint64) { s[0] = api.EncodeF64(a._sa_info_float(s[0])) }},
+	"_sa_info_setFloat":   {"if", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_info_setFloat(s[0], api.DecodeF64(s[1]))) }},
+	"_sa_info_string":     {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_info_string(s[0], s[1])) }},
+	"_sa_info_string_len": {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_info_string_len(s[0])) }},
+	"_sa_info_setString":  {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_info_setString(s[0], s[1])) }},
+
+	"_sa_resource":      {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_resource(s[0], s[1])) }},
+	"_sa_resource_len":  {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_resource_len(s[0])) }},
+	"_sa_storage_write": {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_storage_write(s[0])) }},
+
+	"_sa_sql_write":        {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_write(s[0], s[1])) }},
+	"_sa_sql_read":         {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_read(s[0], s[1])) }},
+	"_sa_sql_writeParams":  {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_writeParams(s[0], s[1], s[2])) }},
+	"_sa_sql_readParams":   {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_readParams(s[0], s[1], s[2])) }},
+	"_sa_sql_readRowCount": {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_readRowCount(s[0], s[1], int64(s[2]))) }},
+	"_sa_sql_readRowLen":   {"iiii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_readRowLen(s[0], s[1], int64(s[2]), s[3])) }},
+	"_sa_sql_readRow":      {"iiiii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_readRow(s[0], s[1], int64(s[2]), s[3], s[4])) }},
+
+	"_sa_sql_readColumnsLen": {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_readColumnsLen(s[0], s[1], int64(s[2]))) }},
+	"_sa_sql_readColumns":    {"iiii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_readColumns(s[0], s[1], int64(s[2]), s[3])) }},
+
+	"_sa_sql_begin":           {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_begin(s[0])) }},
+	"_sa_sql_commit":          {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_commit(s[0])) }},
+	"_sa_sql_rollback":        {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_rollback(s[0])) }},
+	"_sa_sql_savepoint":       {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_savepoint(s[0], s[1])) }},
+	"_sa_sql_release":         {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_release(s[0], s[1])) }},
+	"_sa_sql_rollbackTo":      {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_rollbackTo(s[0], s[1])) }},
+	"_sa_sql_undo":            {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_undo(s[0])) }},
+	"_sa_sql_redo":            {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_redo(s[0])) }},
+	"_sa_sql_searchable":      {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_searchable(s[0], s[1], s[2])) }},
+	"_sa_sql_searchLen":       {"iiii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_searchLen(s[0], s[1], s[2], s[3])) }},
+	"_sa_sql_search":          {"iiiii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_search(s[0], s[1], s[2], s[3], s[4])) }},
+	"_sa_sql_history":         {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_history(s[0], s[1], int64(s[2]))) }},
+	"_sa_sql_historyRow":      {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_historyRow(s[0], s[1], int64(s[2]))) }},
+	"_sa_sql_historyAsOf":     {"iif", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_historyAsOf(s[0], s[1], api.DecodeF64(s[2]))) }},
+	"_sa_sql_function":        {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_function(s[0], s[1], s[2])) }},
+	"_sa_sql_aggregate":       {"iiiii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_aggregate(s[0], s[1], s[2], s[3], s[4])) }},
+	"_sa_sql_subscribe":       {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_subscribe(s[0], s[1], s[2])) }},
+	"_sa_sql_readAsync":       {"iiii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_readAsync(s[0], s[1], s[2], int64(s[3]))) }},
+	"_sa_sql_readAsyncStatus": {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_sql_readAsyncStatus(s[0], int64(s[1]))) }},
+
+	"_sa_div_colResize":   {"iif", "f", func(a *Asset, s []uint64) { s[0] = api.EncodeF64(a._sa_div_colResize(s[0], s[1], api.DecodeF64(s[2]))) }},
+	"_sa_div_rowResize":   {"iif", "f", func(a *Asset, s []uint64) { s[0] = api.EncodeF64(a._sa_div_rowResize(s[0], s[1], api.DecodeF64(s[2]))) }},
+	"_sa_div_colMax":      {"if", "f", func(a *Asset, s []uint64) { s[0] = api.EncodeF64(a._sa_div_colMax(s[0], api.DecodeF64(s[1]))) }},
+	"_sa_div_rowMax":      {"if", "f", func(a *Asset, s []uint64) { s[0] = api.EncodeF64(a._sa_div_rowMax(s[0], api.DecodeF64(s[1]))) }},
+	"_sa_div_col":         {"if", "f", func(a *Asset, s []uint64) { s[0] = api.EncodeF64(a._sa_div_col(s[0], api.DecodeF64(s[1]))) }},
+	"_sa_div_row":         {"if", "f", func(a *Asset, s []uint64) { s[0] = api.EncodeF64(a._sa_div_row(s[0], api.DecodeF64(s[1]))) }},
+	"_sa_div_start":       {"iiiii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_div_start(s[0], s[1], s[2], s[3], s[4])) }},
+	"_sa_div_end":         {"", "", func(a *Asset, s []uint64) { a._sa_div_end() }},
+	"_sa_div_dialogOpen":  {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_div_dialogOpen(s[0], s[1])) }},
+	"_sa_div_dialogClose": {"", "", func(a *Asset, s []uint64) { a._sa_div_dialogClose() }},
+	"_sa_div_dialogStart": {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_div_dialogStart(s[0])) }},
+	"_sa_div_dialogEnd":   {"", "", func(a *Asset, s []uint64) { a._sa_div_dialogEnd() }},
+
+	"_sa_div_get_info": {"iii", "f", func(a *Asset, s []uint64) { s[0] = api.EncodeF64(a._sa_div_get_info(s[0], int64(s[1]), int64(s[2]))) }},
+	"_sa_div_set_info": {"ifii", "f", func(a *Asset, s []uint64) {
+		s[0] = api.EncodeF64(a._sa_div_set_info(s[0], api.DecodeF64(s[1]), int64(s[2]), int64(s[3])))
+	}},
+
+	"_sa_div_drag": {"ii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_div_drag(s[0], s[1])) }},
+	"_sa_div_drop": {"iuuui", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_div_drop(s[0], api.DecodeU32(s[1]), api.DecodeU32(s[2]), api.DecodeU32(s[3]), s[4]))
+	}},
+
+	"_sa_register_style": {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_register_style(s[0])) }},
+
+	"_sa_render_app": {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_render_app(s[0], s[1], s[2])) }},
+
+	"_sa_paint_rect": {"fffffuuuuf", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_paint_rect(api.DecodeF64(s[0]), api.DecodeF64(s[1]), api.DecodeF64(s[2]), api.DecodeF64(s[3]), api.DecodeF64(s[4]), api.DecodeU32(s[5]), api.DecodeU32(s[6]), api.DecodeU32(s[7]), api.DecodeU32(s[8]), api.DecodeF64(s[9])))
+	}},
+	"_sa_paint_circle": {"ffffffffuuuuf", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_paint_circle(api.DecodeF64(s[0]), api.DecodeF64(s[1]), api.DecodeF64(s[2]), api.DecodeF64(s[3]), api.DecodeF64(s[4]), api.DecodeF64(s[5]), api.DecodeF64(s[6]), api.DecodeF64(s[7]), api.DecodeU32(s[8]), api.DecodeU32(s[9]), api.DecodeU32(s[10]), api.DecodeU32(s[11]), api.DecodeF64(s[12])))
+	}},
+	"_sa_paint_line": {"fffffffffuuuuf", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_paint_line(api.DecodeF64(s[0]), api.DecodeF64(s[1]), api.DecodeF64(s[2]), api.DecodeF64(s[3]), api.DecodeF64(s[4]), api.DecodeF64(s[5]), api.DecodeF64(s[6]), api.DecodeF64(s[7]), api.DecodeF64(s[8]), api.DecodeU32(s[9]), api.DecodeU32(s[10]), api.DecodeU32(s[11]), api.DecodeU32(s[12]), api.DecodeF64(s[13])))
+	}},
+	"_sa_paint_file": {"ffffiifffuuuuuuu", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_paint_file(api.DecodeF64(s[0]), api.DecodeF64(s[1]), api.DecodeF64(s[2]), api.DecodeF64(s[3]), s[4], s[5], api.DecodeF64(s[6]), api.DecodeF64(s[7]), api.DecodeF64(s[8]), api.DecodeU32(s[9]), api.DecodeU32(s[10]), api.DecodeU32(s[11]), api.DecodeU32(s[12]), api.DecodeU32(s[13]), api.DecodeU32(s[14]), api.DecodeU32(s[15])))
+	}},
+	"_sa_paint_title": {"ffffi", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_paint_title(api.DecodeF64(s[0]), api.DecodeF64(s[1]), api.DecodeF64(s[2]), api.DecodeF64(s[3]), s[4]))
+	}},
+	"_sa_paint_text": {"ffffifffuuuuffuuuuuuu", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_paint_text(api.DecodeF64(s[0]), api.DecodeF64(s[1]), api.DecodeF64(s[2]), api.DecodeF64(s[3]), s[4], api.DecodeF64(s[5]), api.DecodeF64(s[6]), api.DecodeF64(s[7]), api.DecodeU32(s[8]), api.DecodeU32(s[9]), api.DecodeU32(s[10]), api.DecodeU32(s[11]), api.DecodeF64(s[12]), api.DecodeF64(s[13]), api.DecodeU32(s[14]), api.DecodeU32(s[15]), api.DecodeU32(s[16]), api.DecodeU32(s[17]), api.DecodeU32(s[18]), api.DecodeU32(s[19]), api.DecodeU32(s[20])))
+	}},
+	"_sa_paint_textWidth": {"iufi", "f", func(a *Asset, s []uint64) {
+		s[0] = api.EncodeF64(a._sa_paint_textWidth(s[0], api.DecodeU32(s[1]), api.DecodeF64(s[2]), int64(s[3])))
+	}},
+	"_sa_paint_cursor": {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_paint_cursor(s[0])) }},
+
+	"_sa_fn_call":      {"iii", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_fn_call(s[0], s[1], s[2])) }},
+	"_sa_fn_setReturn": {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_fn_setReturn(s[0])) }},
+	"_sa_fn_getReturn": {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_fn_getReturn(s[0])) }},
+
+	"_sa_swp_drawButton": {"uiifiiui", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_swp_drawButton(api.DecodeU32(s[0]), s[1], s[2], api.DecodeF64(s[3]), s[4], s[5], api.DecodeU32(s[6]), s[7]))
+	}},
+	"_sa_swp_drawSlider": {"ffffiui", "f", func(a *Asset, s []uint64) {
+		s[0] = api.EncodeF64(a._sa_swp_drawSlider(api.DecodeF64(s[0]), api.DecodeF64(s[1]), api.DecodeF64(s[2]), api.DecodeF64(s[3]), s[4], api.DecodeU32(s[5]), s[6]))
+	}},
+	"_sa_swp_drawProgress": {"ffifu", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_swp_drawProgress(api.DecodeF64(s[0]), api.DecodeF64(s[1]), s[2], api.DecodeF64(s[3]), api.DecodeU32(s[4])))
+	}},
+	"_sa_swp_drawText": {"uuuuiiufffuufuu", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_swp_drawText(api.DecodeU32(s[0]), api.DecodeU32(s[1]), api.DecodeU32(s[2]), api.DecodeU32(s[3]), s[4], s[5], api.DecodeU32(s[6]), api.DecodeF64(s[7]), api.DecodeF64(s[8]), api.DecodeF64(s[9]), api.DecodeU32(s[10]), api.DecodeU32(s[11]), api.DecodeF64(s[12]), api.DecodeU32(s[13]), api.DecodeU32(s[14])))
+	}},
+	"_sa_swp_drawEdit": {"uuuuiiiufffuufui", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_swp_drawEdit(api.DecodeU32(s[0]), api.DecodeU32(s[1]), api.DecodeU32(s[2]), api.DecodeU32(s[3]), s[4], s[5], s[6], api.DecodeU32(s[7]), api.DecodeF64(s[8]), api.DecodeF64(s[9]), api.DecodeF64(s[10]), api.DecodeU32(s[11]), api.DecodeU32(s[12]), api.DecodeF64(s[13]), api.DecodeU32(s[14]), s[15]))
+	}},
+	"_sa_swp_getEditValue": {"i", "i", func(a *Asset, s []uint64) { s[0] = uint64(a._sa_swp_getEditValue(s[0])) }},
+	"_sa_swp_drawCombo": {"uuuuiiiufffufu", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_swp_drawCombo(api.DecodeU32(s[0]), api.DecodeU32(s[1]), api.DecodeU32(s[2]), api.DecodeU32(s[3]), s[4], s[5], s[6], api.DecodeU32(s[7]), api.DecodeF64(s[8]), api.DecodeF64(s[9]), api.DecodeF64(s[10]), api.DecodeU32(s[11]), api.DecodeF64(s[12]), api.DecodeU32(s[13])))
+	}},
+	"_sa_swp_drawCheckbox": {"uuuuiiifuuu", "i", func(a *Asset, s []uint64) {
+		s[0] = uint64(a._sa_swp_drawCheckbox(api.DecodeU32(s[0]), api.DecodeU32(s[1]), api.DecodeU32(s[2]), api.DecodeU32(s[3]), s[4], s[5], s[6], api.DecodeF64(s[7]), api.DecodeU32(s[8]), api.DecodeU32(s[9]), api.DecodeU32(s[10])))
+	}},
+
+	"_sa_print":       {"i", "", func(a *Asset, s []uint64) { a._sa_print(s[0]) }},
+	"_sa_print_float": {"f", "", func(a *Asset, s []uint64) { a._sa_print_float(api.DecodeF64(s[0])) }},
+}
+
+// host functions, which need capability from app.json
+var AssetWasm_CAPABILITY_FUNCS = map[string]func(app *App) error{
+	"_sa_render_app": (*App).checkApps,
+}
+
+type AssetWasmCtxKey struct{}
+
+func AssetWasm_withAsset(ctx context.Context, asset *Asset) context.Context {
+	return context.WithValue(ctx, AssetWasmCtxKey{}, asset)
+}
+
+func AssetWasm_getAsset(ctx context.Context) *Asset {
+	asset, _ := ctx.Value(AssetWasmCtxKey{}).(*Asset)
+	return asset
+}
+
+func AssetWasm_types(tps string) []api.ValueType {
+	var ret []api.ValueType
+	for _, tp := range tps {
+		switch tp {
+		case 'u':
+			ret = append(ret, api.ValueTypeI32)
+		case 'f':
+			ret = append(ret, api.ValueTypeF64)
+		default:
+			ret = append(ret, api.ValueTypeI64)
+		}
+	}
+	return ret
+}
+
+// wraps host function, so it finds asset in context
+func AssetWasm_hostFunc(name string, hf AssetWasmHostFunc) api.GoModuleFunc {
+	check := AssetWasm_CAPABILITY_FUNCS[name]
+
+	return api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+		asset := AssetWasm_getAsset(ctx)
+		if asset == nil {
+			//traps, caller gets error
+			fmt.Printf("function(%s) was called without asset\n", name)
+			mod.CloseWithExitCode(ctx, 1)
+			return
+		}
+
+		if check != nil {
+			if err := check(asset.app); err != nil {
+				asset.AddLogErr(err)
+				for i := range hf.results {
+					stack[i] = api.EncodeI64(-1)
+				}
+				return
+			}
+		}
+
+		//SQL, rendering, etc. don't use module's time
+		if b := AssetWasm_getBudget(ctx); b != nil {
+			b.pause()
+			defer b.resume()
+		}
+
+		hf.fn(asset, stack)
+	})
+}
+
+// One runtime and one host module serve all assets
+func (root *Root) InstantiateEnv() error {
+
+	root.rt = wazero.NewRuntimeWithConfig(root.ctx, root.runtimeConfig)
+	wasi_snapshot_preview1.MustInstantiate(root.ctx, root.rt)
+
+	env := root.rt.NewHostModuleBuilder("env")
+	for name, hf := range AssetWasm_HOST_FUNCS {
+		env.NewFunctionBuilder().WithGoModuleFunction(AssetWasm_hostFunc(name, hf), AssetWasm_types(hf.params), AssetWasm_types(hf.results)).Export(name)
+	}
+
+	_, err := env.Instantiate(root.ctx)
 	return err
 }
 
 func (aw *AssetWasm) Call(fnName string, args []byte) (int64, error) {
 
+	if aw.crash != nil {
+		return 0, fmt.Errorf("asset crashed: %w", aw.crash)
+	}
 	if aw.mod == nil {
 		return 0, fmt.Errorf("mod is nil")
 	}
 
+	executing := aw.executing
+	aw.executing = true
+	defer func() { aw.executing = executing }()
+
+	ctx, cancel := aw.getContext()
+	defer cancel()
+
 	fn := aw.mod.ExportedFunction(fnName)
+	if fn == nil {
+		return 0, fmt.Errorf("function(%s) not exported", fnName)
+	}
 
 	var frees []uint64
 	var params []uint64
@@ -208,9 +377,9 @@ func (aw *AssetWasm) Call(fnName string, args []byte) (int64, error) {
 				}
 
 				// alloc
-				results, err := aw.malloc.Call(aw.asset.app.root.ctx, uint64(src_n))
+				results, err := aw.malloc.Call(ctx, uint64(src_n))
 				if err != nil {
-					return -1, fmt.Errorf("wasm malloc() failed: %w", err)
+					return -1, fmt.Errorf("wasm malloc() failed: %w", aw.setCrash("malloc", ctx, err))
 				}
 				frees = append(frees, results[0]) //free() later
 
@@ -233,16 +402,16 @@ func (aw *AssetWasm) Call(fnName string, args []byte) (int64, error) {
 	aw.asset.app.fn2Returns = nil
 
 	//call
-	res, err := fn.Call(aw.asset.app.root.ctx, params...)
+	res, err := fn.Call(ctx, params...)
 	if err != nil {
-		return -1, fmt.Errorf("wasm module failed: %w", err)
+		return -1, fmt.Errorf("wasm module failed: %w", aw.setCrash(fnName, ctx, err))
 	}
 
 	//free
 	for _, it := range frees {
-		_, err := aw.free.Call(aw.asset.app.root.ctx, it)
+		_, err := aw.free.Call(ctx, it)
 		if err != nil {
-			return -1, fmt.Errorf("wasm free() failed: %w", err)
+			return -1, fmt.Errorf("wasm free() failed: %w", aw.setCrash("free", ctx, err))
 		}
 	}
 
@@ -256,35 +425,137 @@ func (aw *AssetWasm) Call(fnName string, args []byte) (int64, error) {
 	return int64(len(aw.asset.app.fn2Return) + len(aw.asset.app.fn2Returns)), nil
 }
 
-func (aw *AssetWasm) LoadModule() error {
+// Calls export from SQL function. SQL function can't enter module, which is inside export or host call(sql_read(), etc.).
+func (aw *AssetWasm) CallSql(fnName string, args []byte) (interface{}, error) {
+	if aw.executing {
+		return nil, fmt.Errorf("function(%s) can't enter module, which is running", fnName)
+	}
+
+	//outer call can be waiting for its return
+	app := aw.asset.app
+	ret, rets := app.fn2Return, app.fn2Returns
+	defer func() { app.fn2Return, app.fn2Returns = ret, rets }()
 
-	wasmFile, err := os.ReadFile(aw.asset.getWasmPath())
+	_, err := aw.Call(fnName, args)
 	if err != nil {
-		return fmt.Errorf("ReadFile failed: %w", err)
+		return nil, err
 	}
 
-	aw.SaveData()
-	aw.destroyMod()
+	//returned by SA_CallSetReturn()
+	if len(app.fn2Returns) > 0 {
+		vals, err := _arrayToParams(app.fn2Returns)
+		if err != nil {
+			return nil, err
+		}
+		return vals[0], nil
+	}
+
+	//returned by export
+	if len(app.fn2Return) == 1+8 {
+		v := binary.LittleEndian.Uint64(app.fn2Return[1:])
+		switch app.fn2Return[0] {
+		case api.ValueTypeI32:
+			return int64(int32(v)), nil
+		case api.ValueTypeI64:
+			return int64(v), nil
+		case api.ValueTypeF32:
+			return float64(math.Float32frombits(uint32(v))), nil
+		case api.ValueTypeF64:
+			return math.Float64frombits(v), nil
+		}
+	}
+	return nil, nil
+}
+
+// optional export, storage of module without it has version 0
+func (aw *AssetWasm) GetStorageVersion() (int, error) {
+	fn := aw.mod.ExportedFunction("_sa_storage_version")
+	if fn == nil {
+		return 0, nil
+	}
+
+	executing := aw.executing
+	aw.executing = true
+	defer func() { aw.executing = executing }()
 
-	aw.mod, err = aw.rt.Instantiate(aw.asset.app.root.ctx, wasmFile)
+	ctx, cancel := aw.getContext()
+	defer cancel()
+
+	res, err := fn.Call(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("_sa_storage_version() failed: %w", err)
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return int(int64(res[0])), nil
+}
+
+// Old module keeps running until the new one is initialized. If new one fails, old one is used again.
+func (aw *AssetWasm) LoadModule(tm int64) error {
+	root := aw.asset.app.root
+
+	compiled, err := root.compileWasm(aw.asset.getWasmPath(), tm)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := aw.getContext()
+	defer cancel()
+
+	//name must be unique in runtime
+	root.wasm_mods++
+	config := wazero.NewModuleConfig().WithName(fmt.Sprintf("%s/%s/%d", aw.asset.app.name, aw.asset.name, root.wasm_mods))
+
+	mod, err := root.rt.InstantiateModule(ctx, compiled, config)
 	if err != nil {
 		return fmt.Errorf("Instantiate() failed: %w", err)
 	}
 
-	if aw.mod != nil {
-		aw.malloc = aw.mod.ExportedFunction("malloc")
-		aw.free = aw.mod.ExportedFunction("free")
+	aw.SaveData()
+	version := aw.asset.storage_version
+
+	//swap
+	old, oldMalloc, oldFree := aw.mod, aw.malloc, aw.free
+	aw.mod = mod
+	aw.malloc = mod.ExportedFunction("malloc")
+	aw.free = mod.ExportedFunction("free")
+
+	aw.asset.loadData()
+
+	if aw.crash != nil && old != nil {
+		err := aw.crash
+		mod.Close(root.ctx)
+		aw.mod, aw.malloc, aw.free = old, oldMalloc, oldFree
+		aw.crash = nil
+		aw.asset.storage_version = version //old version writes old storage
+		return fmt.Errorf("new version failed, previous one is running: %w", err)
+	}
+	if old != nil {
+		old.Close(root.ctx)
 	}
+	root.levels.Keep() //new version doesn't have to render everything in first frame
 
-	return nil
+	return aw.crash
 }
 
 func (aw *AssetWasm) Tick() (bool, error) {
 
 	stat, err := os.Stat(aw.asset.getWasmPath())
 	if err == nil && !stat.IsDir() {
-		if aw.mod == nil || stat.ModTime().UnixMilli() != aw.load_tm {
-			err = aw.LoadModule()
+		changed := stat.ModTime().UnixMilli() != aw.load_tm
+
+		//stays crashed until new version is built
+		if aw.crash != nil {
+			aw.destroyMod()
+			if !changed {
+				return false, nil
+			}
+		}
+
+		if aw.mod == nil || changed {
+			aw.crash = nil
+			err = aw.LoadModule(stat.ModTime().UnixMilli())
 			aw.load_tm = stat.ModTime().UnixMilli()
 			return true, err
 		}
@@ -0,0 +1,119 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Time limit of one call, which runs only while guest code runs. Host calls(SQL, etc.) pause it.
+// Runtime closes module when Done() is closed.
+type AssetWasmBudget struct {
+	context.Context
+
+	lock   sync.Mutex
+	done   chan struct{}
+	err    error
+	timer  *time.Timer
+	left   time.Duration
+	start  time.Time
+	paused int
+
+	stopParent func() bool
+}
+
+type AssetWasmBudgetKey struct{}
+
+func AssetWasm_newBudget(parent context.Context, limit time.Duration) (*AssetWasmBudget, context.CancelFunc) {
+	b := &AssetWasmBudget{Context: parent, done: make(chan struct{}), left: limit}
+	b.start = time.Now()
+	b.timer = time.AfterFunc(limit, func() { b.finish(context.DeadlineExceeded) })
+	b.stopParent = context.AfterFunc(parent, func() { b.finish(parent.Err()) })
+
+	return b, func() {
+		b.stopParent()
+		b.finish(context.Canceled)
+	}
+}
+
+func AssetWasm_getBudget(ctx context.Context) *AssetWasmBudget {
+	b, _ := ctx.Value(AssetWasmBudgetKey{}).(*AssetWasmBudget)
+	return b
+}
+
+func (b *AssetWasmBudget) Value(key any) any {
+	if key == (AssetWasmBudgetKey{}) {
+		return b
+	}
+	return b.Context.Value(key)
+}
+
+// no fixed deadline, it moves with pauses
+func (b *AssetWasmBudget) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (b *AssetWasmBudget) Done() <-chan struct{} {
+	return b.done
+}
+
+func (b *AssetWasmBudget) Err() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.err
+}
+
+func (b *AssetWasmBudget) finish(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.err != nil {
+		return //already finished
+	}
+	b.timer.Stop()
+	b.err = err
+	close(b.done)
+}
+
+// host call started
+func (b *AssetWasmBudget) pause() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.paused++
+	if b.paused > 1 || b.err != nil {
+		return
+	}
+	if b.timer.Stop() {
+		b.left -= time.Since(b.start)
+	}
+}
+
+// host call returned
+func (b *AssetWasmBudget) resume() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.paused--
+	if b.paused > 0 || b.err != nil {
+		return
+	}
+	b.start = time.Now()
+	b.timer.Reset(max(b.left, 0))
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBudgetPausesInHostCall(t *testing.T) {
+	ctx, cancel := AssetWasm_newBudget(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	//host call is longer than the whole limit
+	b := AssetWasm_getBudget(ctx)
+	b.pause()
+	time.Sleep(100 * time.Millisecond)
+	b.resume()
+	if ctx.Err() != nil {
+		t.Fatal("host call used module's time")
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("limit wasn't hit")
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Fatal(ctx.Err())
+	}
+}
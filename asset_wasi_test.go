@@ -0,0 +1,75 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+// module, which only records how it was closed
+type testModule struct {
+	api.Module
+	closed   bool
+	exitCode uint32
+}
+
+func (m *testModule) CloseWithExitCode(ctx context.Context, exitCode uint32) error {
+	m.closed = true
+	m.exitCode = exitCode
+	return nil
+}
+
+func TestHostFuncFindsAsset(t *testing.T) {
+	var called *Asset
+	hf := AssetWasmHostFunc{"i", "i", func(a *Asset, s []uint64) {
+		called = a
+		s[0] = 7
+	}}
+	fn := AssetWasm_hostFunc("_sa_test", hf)
+
+	//call without asset closes module, so caller gets error instead of crash
+	mod := &testModule{}
+	fn(context.Background(), mod, []uint64{0})
+	if !mod.closed || mod.exitCode != 1 || called != nil {
+		t.Fatal("module wasn't closed")
+	}
+
+	asset := &Asset{name: "main"}
+	mod = &testModule{}
+	stack := []uint64{0}
+	fn(AssetWasm_withAsset(context.Background(), asset), mod, stack)
+	if mod.closed || called != asset || stack[0] != 7 {
+		t.Fatal("function wasn't called with asset from context")
+	}
+}
+
+func TestHostFuncCapability(t *testing.T) {
+	called := false
+	hf := AssetWasmHostFunc{"i", "i", func(a *Asset, s []uint64) { called = true }}
+	fn := AssetWasm_hostFunc("_sa_render_app", hf)
+
+	app := &App{name: "notes", root: &Root{}}
+	asset := &Asset{name: "main", app: app}
+	stack := []uint64{0}
+	fn(AssetWasm_withAsset(context.Background(), asset), &testModule{}, stack)
+	if called || int64(stack[0]) != -1 || len(app.logs) != 1 {
+		t.Fatal("app without capability called function")
+	}
+}
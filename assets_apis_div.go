@@ -101,6 +101,9 @@ func (asset *Asset) renderStart() {
 	over := enableInput && st.stack.crop.Inside(root.ui.io.touch.pos)
 	inside := over
 	if inside && startTouch && enableInput {
+		//nested app's div comes later
+		root.focus_app = asset.app
+
 		if !root.touch.IsScrollOrResizeActive() { //if lower resize or scroll is activated than don't rewrite it with higher canvas
 			root.touch.Set(st.stack, nil, nil, nil)
 		}
@@ -857,6 +860,21 @@ func (asset *Asset) render_app(appName string, dbName string, sts_id uint64) (in
 
 	root := asset.app.root
 
+	//user must approve capabilities before app runs
+	caps := AppCapabilities{}
+	if app := root.FindApp(appName, dbName, int(sts_id)); app != nil {
+		caps = app.caps
+	} else {
+		manifest, err := LoadAppManifest(root, appName)
+		if err != nil {
+			return -1, err
+		}
+		caps = manifest.Capabilities
+	}
+	if !root.IsAppApproved(appName, &caps) {
+		return -1, fmt.Errorf("app(%s) capabilities are not approved", appName)
+	}
+
 	app, err := root.AddApp(appName, dbName, int(sts_id))
 	if err != nil {
 		return -1, err
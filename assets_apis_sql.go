@@ -16,10 +16,18 @@ limitations under the License.
 
 package main
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 func (asset *Asset) _getDb(dbName string) (*Db, error) {
-	var err error
+	err := asset.app.checkDb(dbName)
+	if err != nil {
+		return nil, err
+	}
+
 	var db *Db
 	if len(dbName) == 0 {
 		db, err = asset.app.root.AddDb(asset.app.db_name)
@@ -27,17 +35,70 @@ func (asset *Asset) _getDb(dbName string) (*Db, error) {
 		db, err = asset.app.root.AddDb(dbName)
 	}
 
+	if db != nil {
+		db.AddAsset(asset) //will be notified about changes
+
+		err = asset.migrate(db)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return db, err
 }
 
-func (asset *Asset) sql_write(dbName string, query string) (int64, error) {
+// applies asset's migrations once per db
+func (asset *Asset) migrate(db *Db) error {
+	if asset.migrations == nil {
+		asset.migrations = make(map[string]error)
+	}
+
+	err, done := asset.migrations[db.name]
+	if done {
+		return err
+	}
+	if db.explicit != nil {
+		return nil //reads can go through, migration is tried again after transaction is finished
+	}
+	if db.tx != nil {
+		db.addPendingMigration(asset) //other assets' writes aren't committed mid-frame
+		return nil
+	}
+
+	err = db.Migrate(asset.app, asset.name, asset.getMigrationsPath())
+	asset.migrations[db.name] = err
+	return err
+}
+
+// returns first failed migration
+func (asset *Asset) GetMigrationErr() error {
+	for _, err := range asset.migrations {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (asset *Asset) sql_write(dbName string, query string, params []byte) (int64, error) {
 
 	db, err := asset._getDb(dbName)
 	if err != nil {
 		return -1, err
 	}
+	db.SetPolicy(asset.app, asset.name)
+	defer db.SetPolicy(nil, "")
+
+	err = db.CanWrite(asset)
+	if err != nil {
+		return -1, err
+	}
 
-	res, err := db.Write(query)
+	args, err := _arrayToParams(params)
+	if err != nil {
+		return -1, fmt.Errorf("params for query(%s) failed: %w", query, err)
+	}
+
+	res, err := db.Write(query, args...)
 	if err != nil {
 		return -1, fmt.Errorf("Exec(%s) for query(%s) failed: %w", db.GetPath(), query, err)
 	}
@@ -64,18 +125,40 @@ func (asset *Asset) _sa_sql_write(dbMem uint64, queryMem uint64) int64 {
 		return -1
 	}
 
-	ret, err := asset.sql_write(db, query)
+	ret, err := asset.sql_write(db, query, nil)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) _sa_sql_writeParams(dbMem uint64, queryMem uint64, paramsMem uint64) int64 {
+
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	query, err := asset.ptrToString(queryMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	params, err := asset.ptrToBytesDirect(paramsMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_write(db, query, params)
 	asset.AddLogErr(err)
 	return ret
 }
 
-func (asset *Asset) sql_read(dbName string, query string) (int64, error) {
+func (asset *Asset) sql_read(dbName string, query string, params []byte) (int64, error) {
 
 	db, err := asset._getDb(dbName)
 	if db == nil {
 		return -1, err
 	}
-	cache, err := db.AddCache(query)
+	db.SetPolicy(asset.app, asset.name)
+	defer db.SetPolicy(nil, "")
+	cache, err := db.AddCache(query, params)
 	if err != nil {
 		return -1, err
 	}
@@ -93,11 +176,30 @@ func (asset *Asset) _sa_sql_read(dbMem uint64, queryMem uint64) int64 {
 		return -1
 	}
 
-	ret, err := asset.sql_read(db, query)
+	ret, err := asset.sql_read(db, query, nil)
 	asset.AddLogErr(err)
 	return ret
 
 }
+func (asset *Asset) _sa_sql_readParams(dbMem uint64, queryMem uint64, paramsMem uint64) int64 {
+
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	query, err := asset.ptrToString(queryMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	params, err := asset.ptrToBytesDirect(paramsMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_read(db, query, params)
+	asset.AddLogErr(err)
+	return ret
+}
 
 func (asset *Asset) sql_readRowCount(dbName string, query string, queryHash int64) (int64, error) {
 
@@ -105,18 +207,15 @@ func (asset *Asset) sql_readRowCount(dbName string, query string, queryHash int6
 	if db == nil {
 		return -1, err
 	}
+	db.SetPolicy(asset.app, asset.name)
+	defer db.SetPolicy(nil, "")
 
-	cache := db.FindCache(queryHash)
-
-	if cache == nil {
-		var err error
-		cache, err = db.AddCache(query)
-		if err != nil {
-			return -1, err
-		}
+	cache, err := db.GetCache(query, queryHash)
+	if err != nil {
+		return -1, err
 	}
 
-	return int64(len(cache.result_rows)), nil
+	return db.GetRowCount(cache)
 }
 
 func (asset *Asset) sql_readRowLen(dbName string, query string, queryHash int64, row_i uint64) (int64, error) {
@@ -125,22 +224,20 @@ func (asset *Asset) sql_readRowLen(dbName string, query string, queryHash int64,
 	if db == nil {
 		return -1, err
 	}
+	db.SetPolicy(asset.app, asset.name)
+	defer db.SetPolicy(nil, "")
 
-	cache := db.FindCache(queryHash)
-
-	if cache == nil {
-		var err error
-		cache, err = db.AddCache(query)
-		if err != nil {
-			return -1, err
-		}
+	cache, err := db.GetCache(query, queryHash)
+	if err != nil {
+		return -1, err
 	}
 
-	if row_i < uint64(len(cache.result_rows)) {
-		return int64(len(cache.result_rows[row_i])), nil
+	row, err := db.GetRow(cache, int(row_i))
+	if err != nil {
+		return -1, err
 	}
 
-	return 0, nil //no more rows
+	return int64(len(row)), nil //0 = no more rows
 }
 
 func (asset *Asset) _sa_sql_readRowCount(dbMem uint64, queryMem uint64, queryHash int64) int64 {
@@ -181,21 +278,23 @@ func (asset *Asset) sql_readRow(dbName string, query string, queryHash int64, ro
 	if db == nil {
 		return nil, -1, err
 	}
+	db.SetPolicy(asset.app, asset.name)
+	defer db.SetPolicy(nil, "")
 
-	cache := db.FindCache(queryHash)
-	if cache == nil {
-		var err error
-		cache, err = db.AddCache(query)
-		if err != nil {
-			return nil, -1, err
-		}
+	cache, err := db.GetCache(query, queryHash)
+	if err != nil {
+		return nil, -1, err
 	}
 
-	if row_i < uint64(len(cache.result_rows)) {
-		return cache.result_rows[row_i], 1, nil
+	row, err := db.GetRow(cache, int(row_i))
+	if err != nil {
+		return nil, -1, err
+	}
+	if row == nil {
+		return nil, 0, nil //no more rows
 	}
 
-	return nil, 0, nil //no more rows
+	return row, 1, nil
 }
 
 func (asset *Asset) _sa_sql_readRow(dbMem uint64, queryMem uint64, queryHash int64, row_i uint64, resultMem uint64) int64 {
@@ -222,3 +321,657 @@ func (asset *Asset) _sa_sql_readRow(dbMem uint64, queryMem uint64, queryHash int
 
 	return ret
 }
+
+func (asset *Asset) sql_readColumns(dbName string, query string, queryHash int64) ([]byte, error) {
+
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return nil, err
+	}
+	db.SetPolicy(asset.app, asset.name)
+	defer db.SetPolicy(nil, "")
+
+	cache, err := db.GetCache(query, queryHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.GetColumns(cache)
+}
+
+func (asset *Asset) _sa_sql_readColumnsLen(dbMem uint64, queryMem uint64, queryHash int64) int64 {
+
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	query, err := asset.ptrToString(queryMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	dst, err := asset.sql_readColumns(db, query, queryHash)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	return int64(len(dst))
+}
+
+func (asset *Asset) _sa_sql_readColumns(dbMem uint64, queryMem uint64, queryHash int64, resultMem uint64) int64 {
+
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	query, err := asset.ptrToString(queryMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	dst, err := asset.sql_readColumns(db, query, queryHash)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	err = asset.bytesToPtr(dst, resultMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	return 1
+}
+
+func (asset *Asset) sql_begin(dbName string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+
+	err = db.BeginExplicit(asset)
+	if err != nil {
+		return -1, err
+	}
+	return 1, nil
+}
+func (asset *Asset) _sa_sql_begin(dbMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_begin(db)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) sql_commit(dbName string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+
+	err = db.CommitExplicit(asset)
+	if err != nil {
+		return -1, err
+	}
+	return 1, nil
+}
+func (asset *Asset) _sa_sql_commit(dbMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_commit(db)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) sql_rollback(dbName string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+
+	err = db.RollbackExplicit(asset)
+	if err != nil {
+		return -1, err
+	}
+	return 1, nil
+}
+func (asset *Asset) _sa_sql_rollback(dbMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_rollback(db)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) sql_savepoint(dbName string, name string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+
+	err = db.Savepoint(asset, name)
+	if err != nil {
+		return -1, err
+	}
+	return 1, nil
+}
+func (asset *Asset) _sa_sql_savepoint(dbMem uint64, nameMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	name, err := asset.ptrToString(nameMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_savepoint(db, name)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) sql_release(dbName string, name string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+
+	err = db.Release(asset, name)
+	if err != nil {
+		return -1, err
+	}
+	return 1, nil
+}
+func (asset *Asset) _sa_sql_release(dbMem uint64, nameMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	name, err := asset.ptrToString(nameMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_release(db, name)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) sql_rollbackTo(dbName string, name string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+
+	err = db.RollbackTo(asset, name)
+	if err != nil {
+		return -1, err
+	}
+	return 1, nil
+}
+func (asset *Asset) _sa_sql_rollbackTo(dbMem uint64, nameMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	name, err := asset.ptrToString(nameMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_rollbackTo(db, name)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) sql_undo(dbName string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+	err = db.CanWrite(asset)
+	if err != nil {
+		return -1, err
+	}
+
+	ok, err := db.Undo(asset.app)
+	if err != nil {
+		return -1, err
+	}
+	return int64(OsTrn(ok, 1, 0)), nil
+}
+func (asset *Asset) _sa_sql_undo(dbMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_undo(db)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) sql_redo(dbName string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+	err = db.CanWrite(asset)
+	if err != nil {
+		return -1, err
+	}
+
+	ok, err := db.Redo(asset.app)
+	if err != nil {
+		return -1, err
+	}
+	return int64(OsTrn(ok, 1, 0)), nil
+}
+func (asset *Asset) _sa_sql_redo(dbMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_redo(db)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) sql_searchable(dbName string, table string, columns string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+	err = db.CanWrite(asset)
+	if err != nil {
+		return -1, err
+	}
+	//creates or drops FTS table and triggers
+	tp := OsTrnString(len(columns) > 0, "CREATE", "DROP")
+	str := asset.app.policy.checkStatement(tp, table)
+	if str != "" {
+		return -1, errors.New(str)
+	}
+
+	var cols []string
+	if len(columns) > 0 {
+		cols = strings.Split(columns, "/")
+	}
+	err = db.SetSearchable(table, cols)
+	if err != nil {
+		return -1, fmt.Errorf("SetSearchable(%s) failed: %w", table, err)
+	}
+	return 1, nil
+}
+func (asset *Asset) _sa_sql_searchable(dbMem uint64, tableMem uint64, columnsMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	table, err := asset.ptrToString(tableMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	columns, err := asset.ptrToString(columnsMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_searchable(db, table, columns)
+	asset.AddLogErr(err)
+	return ret
+}
+
+// returns rowid, rank and snippet for every found row
+func (asset *Asset) sql_search(dbName string, table string, query string, max uint64) ([]byte, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return nil, err
+	}
+	str := asset.app.policy.checkTable(table)
+	if str != "" {
+		return nil, errors.New(str)
+	}
+	db.SetPolicy(asset.app, asset.name)
+	defer db.SetPolicy(nil, "")
+
+	cache, err := db.Search(table, query, int(max))
+	if err != nil {
+		return nil, err
+	}
+
+	var dst []byte
+	for i := 0; i < int(max); i++ {
+		row, err := db.GetRow(cache, i)
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		dst = append(dst, row...)
+	}
+	return dst, nil
+}
+func (asset *Asset) _sa_sql_searchLen(dbMem uint64, tableMem uint64, queryMem uint64, max uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	table, err := asset.ptrToString(tableMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	query, err := asset.ptrToString(queryMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	dst, err := asset.sql_search(db, table, query, max)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	return int64(len(dst))
+}
+func (asset *Asset) _sa_sql_search(dbMem uint64, tableMem uint64, queryMem uint64, max uint64, resultMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	table, err := asset.ptrToString(tableMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	query, err := asset.ptrToString(queryMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	dst, err := asset.sql_search(db, table, query, max)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	err = asset.bytesToPtr(dst, resultMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	return 1
+}
+
+// enable: 1 = start history, 0 = stop history, -1 = returns if history is enabled
+func (asset *Asset) sql_history(dbName string, table string, enable int64) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+	str := asset.app.policy.checkTable(table)
+	if str != "" {
+		return -1, errors.New(str)
+	}
+
+	if enable < 0 {
+		_, enabled, err := db.getHistoryColumns(table)
+		if err != nil {
+			return -1, err
+		}
+		return int64(OsTrn(enabled, 1, 0)), nil
+	}
+
+	err = db.CanWrite(asset)
+	if err != nil {
+		return -1, err
+	}
+	//creates history table and triggers
+	str = asset.app.policy.checkStatement(OsTrnString(enable > 0, "CREATE", "DROP"), table)
+	if str != "" {
+		return -1, errors.New(str)
+	}
+	err = db.SetHistory(table, enable > 0)
+	if err != nil {
+		return -1, fmt.Errorf("SetHistory(%s) failed: %w", table, err)
+	}
+	return 1, nil
+}
+func (asset *Asset) _sa_sql_history(dbMem uint64, tableMem uint64, enable int64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	table, err := asset.ptrToString(tableMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_history(db, table, enable)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) sql_historyRow(dbName string, table string, rowid int64) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+	str := asset.app.policy.checkTable(table)
+	if str != "" {
+		return -1, errors.New(str)
+	}
+	db.SetPolicy(asset.app, asset.name)
+	defer db.SetPolicy(nil, "")
+
+	cache, err := db.HistoryRow(table, rowid)
+	if err != nil {
+		return -1, err
+	}
+	return cache.query_hash, nil
+}
+func (asset *Asset) _sa_sql_historyRow(dbMem uint64, tableMem uint64, rowid int64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	table, err := asset.ptrToString(tableMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_historyRow(db, table, rowid)
+	asset.AddLogErr(err)
+	return ret
+}
+
+func (asset *Asset) sql_historyAsOf(dbName string, table string, time float64) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+	str := asset.app.policy.checkTable(table)
+	if str != "" {
+		return -1, errors.New(str)
+	}
+	db.SetPolicy(asset.app, asset.name)
+	defer db.SetPolicy(nil, "")
+
+	cache, err := db.HistoryAsOf(table, time)
+	if err != nil {
+		return -1, err
+	}
+	return cache.query_hash, nil
+}
+func (asset *Asset) _sa_sql_historyAsOf(dbMem uint64, tableMem uint64, time float64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	table, err := asset.ptrToString(tableMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_historyAsOf(db, table, time)
+	asset.AddLogErr(err)
+	return ret
+}
+
+// registers asset's export as SQL function on db connections
+func (asset *Asset) sql_function(dbName string, name string, fn string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+
+	err = db.SetFunction(asset.app, asset.name, name, fn)
+	if err != nil {
+		return -1, fmt.Errorf("SetFunction(%s) failed: %w", name, err)
+	}
+	return 1, nil
+}
+func (asset *Asset) _sa_sql_function(dbMem uint64, nameMem uint64, fnMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	name, err := asset.ptrToString(nameMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	fn, err := asset.ptrToString(fnMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_function(db, name, fn)
+	asset.AddLogErr(err)
+	return ret
+}
+
+// registers asset's exports as SQL aggregate function on db connections
+func (asset *Asset) sql_aggregate(dbName string, name string, init string, step string, final string) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+
+	err = db.SetAggregate(asset.app, asset.name, name, init, step, final)
+	if err != nil {
+		return -1, fmt.Errorf("SetAggregate(%s) failed: %w", name, err)
+	}
+	return 1, nil
+}
+func (asset *Asset) _sa_sql_aggregate(dbMem uint64, nameMem uint64, initMem uint64, stepMem uint64, finalMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	name, err := asset.ptrToString(nameMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	init, err := asset.ptrToString(initMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	step, err := asset.ptrToString(stepMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	final, err := asset.ptrToString(finalMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_aggregate(db, name, init, step, final)
+	asset.AddLogErr(err)
+	return ret
+}
+
+// returns version of query's result, which is increased when commit changes it
+func (asset *Asset) sql_subscribe(dbName string, query string, params []byte) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+
+	version, err := db.Subscribe(asset, query, params)
+	if err != nil {
+		return -1, fmt.Errorf("Subscribe(%s) failed: %w", query, err)
+	}
+	return version, nil
+}
+func (asset *Asset) _sa_sql_subscribe(dbMem uint64, queryMem uint64, paramsMem uint64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	query, err := asset.ptrToString(queryMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	params, err := asset.ptrToBytesDirect(paramsMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_subscribe(db, query, params)
+	asset.AddLogErr(err)
+	return ret
+}
+
+// returns handle(query hash) immediately, query runs on worker
+func (asset *Asset) sql_readAsync(dbName string, query string, params []byte, redraw int64) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+
+	query_hash, err := db.ReadAsync(asset, query, params, redraw > 0)
+	if err != nil {
+		return -1, fmt.Errorf("ReadAsync(%s) failed: %w", query, err)
+	}
+	return query_hash, nil
+}
+func (asset *Asset) _sa_sql_readAsync(dbMem uint64, queryMem uint64, paramsMem uint64, redraw int64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	query, err := asset.ptrToString(queryMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+	params, err := asset.ptrToBytesDirect(paramsMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_readAsync(db, query, params, redraw)
+	asset.AddLogErr(err)
+	return ret
+}
+
+// returns 1 = finished, 0 = running, -1 = failed
+func (asset *Asset) sql_readAsyncStatus(dbName string, queryHash int64) (int64, error) {
+	db, err := asset._getDb(dbName)
+	if db == nil {
+		return -1, err
+	}
+	return db.ReadAsyncStatus(queryHash)
+}
+func (asset *Asset) _sa_sql_readAsyncStatus(dbMem uint64, queryHash int64) int64 {
+	db, err := asset.ptrToString(dbMem)
+	if asset.AddLogErr(err) {
+		return -1
+	}
+
+	ret, err := asset.sql_readAsyncStatus(db, queryHash)
+	asset.AddLogErr(err)
+	return ret
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -40,6 +41,29 @@ func (asset *Asset) _sa_print_float(val float64) {
 }
 
 func (asset *Asset) info_float(key string) float64 {
+	if asset.AddLogErr(asset.app.checkInfoKey(key, false)) {
+		return -1
+	}
+
+	approvedApp, found := strings.CutPrefix(key, "approved_")
+	if found {
+		manifest, err := LoadAppManifest(asset.app.root, approvedApp)
+		if asset.AddLogErr(err) {
+			return -1
+		}
+		return float64(OsTrn(asset.app.root.IsAppApproved(approvedApp, &manifest.Capabilities), 1, 0))
+	}
+
+	sizeDb, found := strings.CutPrefix(key, "db_size_")
+	if found {
+		return float64(asset.app.root.GetDbSize(sizeDb, false))
+	}
+
+	walDb, found := strings.CutPrefix(key, "wal_size_")
+	if found {
+		return float64(asset.app.root.GetDbSize(walDb, true))
+	}
+
 	switch strings.ToLower(key) {
 	case "theme":
 		return float64(asset.app.root.ui.io.ini.Theme)
@@ -85,6 +109,10 @@ func (asset *Asset) info_float(key string) float64 {
 }
 
 func (asset *Asset) info_setFloat(key string, v float64) int64 {
+	if asset.AddLogErr(asset.app.checkInfoKey(key, true)) {
+		return -1
+	}
+
 	switch strings.ToLower(key) {
 	case "theme":
 		asset.app.root.ui.io.ini.Theme = int(v)
@@ -155,6 +183,18 @@ func (asset *Asset) _sa_info_setFloat(keyMem uint64, v float64) int64 {
 }
 
 func (asset *Asset) info_string(key string) (string, int64) {
+	if asset.AddLogErr(asset.app.checkInfoKey(key, false)) {
+		return "", -1
+	}
+
+	permApp, found := strings.CutPrefix(key, "permissions_")
+	if found {
+		manifest, err := LoadAppManifest(asset.app.root, permApp)
+		if asset.AddLogErr(err) {
+			return "", -1
+		}
+		return manifest.Capabilities.GetList(), 1
+	}
 
 	logSts_Id, found := strings.CutPrefix(key, "log_")
 	if found {
@@ -168,6 +208,31 @@ func (asset *Asset) info_string(key string) (string, int64) {
 		return "", 1
 	}
 
+	snapDb, found := strings.CutPrefix(key, "snapshots_")
+	if found {
+		return strings.Join(asset.app.root.GetSnapshots(snapDb), "/"), 1
+	}
+
+	trashId, found := strings.CutPrefix(key, "trash_")
+	if found {
+		meta, err := asset.app.root.GetTrashMeta(trashId)
+		if err != nil {
+			asset.AddLogErr(err)
+			return "", -1
+		}
+		js, err := json.Marshal(meta)
+		if err != nil {
+			asset.AddLogErr(err)
+			return "", -1
+		}
+		return string(js), 1
+	}
+
+	corruptedDb, found := strings.CutPrefix(key, "corrupted_")
+	if found {
+		return asset.app.root.GetDbCorruption(corruptedDb), 1
+	}
+
 	switch strings.ToLower(key) {
 	case "asset":
 		return asset.name, 1
@@ -178,6 +243,12 @@ func (asset *Asset) info_string(key string) (string, int64) {
 	case "files":
 		return asset.app.root.dbsList, 1
 
+	case "trash":
+		return strings.Join(asset.app.root.GetTrash(), "/"), 1
+
+	case "exports":
+		return strings.Join(asset.app.root.GetBundles(), "/"), 1
+
 	case "apps":
 		return asset.app.root.appsList, 1
 
@@ -227,6 +298,10 @@ func (asset *Asset) _sa_info_string_len(keyMem uint64) int64 {
 }
 
 func (asset *Asset) info_setString(key string, value string) int64 {
+	if asset.AddLogErr(asset.app.checkInfoKey(key, true)) {
+		return -1
+	}
+
 	switch strings.ToLower(key) {
 	case "languages":
 		if len(value) > 0 {
@@ -267,6 +342,85 @@ func (asset *Asset) info_setString(key string, value string) int64 {
 		}
 		return -1
 
+	case "recover_file":
+		report, err := asset.app.root.RecoverDb(value)
+		if err != nil {
+			asset.AddLogErr(err)
+			return -1
+		}
+		if report != "" {
+			asset.app.AddLog(fmt.Sprintf("db(%s) recovered: %s", value, report))
+		}
+		return 1
+
+	case "snapshot_file":
+		_, err := asset.app.root.SnapshotDb(value)
+		if err != nil {
+			asset.AddLogErr(err)
+			return -1
+		}
+		return 1
+
+	case "restore_file":
+		d := strings.IndexByte(value, '/')
+		if d > 0 && d < len(value)-1 {
+			err := asset.app.root.RestoreDb(value[:d], value[d+1:])
+			if err != nil {
+				asset.AddLogErr(err)
+				return -1
+			}
+			return 1
+		}
+		return -1
+
+	case "export_file": //name/passphrase
+		d := strings.IndexByte(value, '/')
+		if d > 0 && d < len(value)-1 {
+			_, err := asset.app.root.ExportDb(value[:d], value[d+1:])
+			if err != nil {
+				asset.AddLogErr(err)
+				return -1
+			}
+			return 1
+		}
+		return -1
+
+	case "import_file": //bundle/passphrase
+		d := strings.IndexByte(value, '/')
+		if d > 0 && d < len(value)-1 {
+			_, err := asset.app.root.ImportDb(value[:d], value[d+1:])
+			if err != nil {
+				asset.AddLogErr(err)
+				return -1
+			}
+			return 1
+		}
+		return -1
+
+	case "restore_trash":
+		err := asset.app.root.RestoreTrash(value)
+		if err != nil {
+			asset.AddLogErr(err)
+			return -1
+		}
+		return 1
+
+	case "purge_trash":
+		err := asset.app.root.PurgeTrash(value)
+		if err != nil {
+			asset.AddLogErr(err)
+			return -1
+		}
+		return 1
+
+	case "approve_app":
+		err := asset.app.root.ApproveApp(value)
+		if err != nil {
+			asset.AddLogErr(err)
+			return -1
+		}
+		return 1
+
 	case "duplicate_setting":
 		srcid, err := strconv.Atoi(value)
 		if err != nil {
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -104,11 +105,38 @@ func (asset *Asset) bytesToPtr(src []byte, dst uint64) error {
 	return nil
 }
 
+// storage is saved with version of asset, which wrote it
+type AssetStorage struct {
+	SA_Version int
+	SA_Data    json.RawMessage
+}
+
+func AssetStorage_wrap(version int, data []byte) []byte {
+	if !json.Valid(data) {
+		return data //custom format from save()
+	}
+
+	js, err := json.Marshal(&AssetStorage{SA_Version: version, SA_Data: data})
+	if err != nil {
+		return data
+	}
+	return js
+}
+
+// storage saved before versioning is version 0
+func AssetStorage_unwrap(js []byte) (int, []byte) {
+	var st AssetStorage
+	if json.Unmarshal(js, &st) != nil || st.SA_Data == nil {
+		return 0, js
+	}
+	return st.SA_Version, st.SA_Data
+}
+
 func (asset *Asset) storage_write(data []byte) (int64, error) {
 
 	//path := asset.app.GetStoragePath(asset.name)
 	//err := os.WriteFile(path, data, 0644)
-	err := asset.app.root.settings.SetContent(asset.sts_rowid, data)
+	err := asset.app.root.settings.SetContent(asset.sts_rowid, AssetStorage_wrap(asset.getStorageVersion(), data))
 	if err != nil {
 		return -1, err
 	}
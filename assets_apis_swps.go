@@ -69,7 +69,9 @@ func (asset *Asset) swp_drawButton(style uint32, value string, icon string, icon
 
 	if click && len(url) > 0 {
 		//SA_DialogStart() warning which open dialog ...
-		OsUlit_OpenBrowser(url)
+		if !asset.AddLogErr(asset.app.checkUrls()) {
+			OsUlit_OpenBrowser(url)
+		}
 	}
 
 	if len(title) > 0 {
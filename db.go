@@ -17,23 +17,24 @@ limitations under the License.
 package main
 
 import (
-	"crypto/sha256"
+	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/binary"
 	"fmt"
 	"math"
 	"os"
+	"strings"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
-type DbCache struct {
-	query_hash  int64
-	query       string
-	result_rows [][]byte
-}
+const Db_CACHE_MAX_BYTES = 64 * 1024 * 1024 //per database
+const Db_QUERIES_MAX = 4096                 //remembered params of queries per database
 
-// 100% copied from sdk.go
+// copied from sdk.go, nil(NULL value) is added
 func _argsToArray(data []byte, arg interface{}) []byte {
 
 	switch v := arg.(type) {
@@ -89,7 +90,7 @@ func _argsToArray(data []byte, arg interface{}) []byte {
 		data = binary.LittleEndian.AppendUint64(data, uint64(len(v)))
 		data = append(data, v...)
 	case string:
-		data = append(data, TpBytes)
+		data = append(data, TpBytes) //shipped apps don't know TpString yet
 		data = binary.LittleEndian.AppendUint64(data, uint64(len(v)))
 		data = append(data, v...)
 
@@ -101,51 +102,116 @@ func _argsToArray(data []byte, arg interface{}) []byte {
 	return data
 }
 
-func NewDbCache(query string, db *sql.DB) (*DbCache, error) {
-	var cache DbCache
+// encodes string, which is bound as TEXT. Only for params built by host, apps get strings as TpBytes.
+func _stringToParams(data []byte, str string) []byte {
+	data = append(data, TpString)
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(str)))
+	return append(data, str...)
+}
 
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("Query(%s) failed: %w", query, err)
-	}
+// decodes parameters(encoded by _argsToArray() in sdk.go) for Query()/Exec()
+func _arrayToParams(data []byte) ([]interface{}, error) {
+	var params []interface{}
 
-	cache.query = query
-	{
-		h := sha256.New()
-		h.Write([]byte(query))
-		cache.query_hash = int64(binary.LittleEndian.Uint64(h.Sum(nil)))
-	}
+	p := 0
+	for p < len(data) {
+		if p+1+8 > len(data) {
+			return nil, fmt.Errorf("params are corrupted(size: %d, pos: %d)", len(data), p)
+		}
 
-	// out fields
-	colTypes, err := rows.ColumnTypes()
-	if err != nil {
-		return nil, fmt.Errorf("ColumnTypes() failed: %w", err)
+		tp := data[p]
+		p += 1
+
+		arg := binary.LittleEndian.Uint64(data[p:])
+		p += 8
+
+		switch tp {
+		case TpI64:
+			params = append(params, int64(arg))
+		case TpF32:
+			params = append(params, float64(math.Float32frombits(uint32(arg))))
+		case TpF64:
+			params = append(params, math.Float64frombits(arg))
+
+		case TpBytes, TpString:
+			n := int(arg)
+			if p+n > len(data) {
+				return nil, fmt.Errorf("params are corrupted(size: %d, pos: %d, item: %d)", len(data), p, n)
+			}
+			if tp == TpString {
+				params = append(params, string(data[p:p+n]))
+			} else {
+				params = append(params, bytes.Clone(data[p:p+n]))
+			}
+			p += n
+
+		default:
+			return nil, fmt.Errorf("unknown param type: %d", tp)
+		}
 	}
 
-	values := make([]interface{}, len(colTypes))
-	scanCallArgs := make([]interface{}, len(colTypes))
-	for i := range colTypes {
-		scanCallArgs[i] = &values[i]
-	}
+	return params, nil
+}
+
+type DbQuery struct {
+	query  string
+	params []byte
+	used   int //ticks of last access
+}
 
-	for rows.Next() {
-		//reset
-		for i := range values {
-			values[i] = nil
+// Opens connections with hooks, which are bind to particular 'Db'
+type DbConnector struct {
+	db     *Db
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+
+	app *App //nil = policy is set by SetPolicy(), otherwise connection belongs to app's workers
+}
+
+func NewDbConnector(db *Db, dsn string, app *App) *DbConnector {
+	var c DbConnector
+	c.db = db
+	c.dsn = dsn
+	c.app = app
+	c.driver = &sqlite3.SQLiteDriver{ConnectHook: c.connectHook}
+	return &c
+}
+
+func (c *DbConnector) connectHook(conn *sqlite3.SQLiteConn) error {
+	if c.app != nil {
+		err := DbAuth_register(conn, c.authorizer)
+		if err != nil {
+			return err
 		}
-		err := rows.Scan(scanCallArgs...)
+	} else {
+		err := DbAuth_register(conn, c.db.authorizer)
 		if err != nil {
-			return nil, fmt.Errorf("Scan() failed: %w", err)
+			return err
 		}
-
-		var row []byte
-		for _, v := range values {
-			row = _argsToArray(row, v)
+		err = DbAuth_defensive(conn)
+		if err != nil {
+			return err
 		}
-		cache.result_rows = append(cache.result_rows, row)
+		c.db.registerUndoHook(conn)
+	}
+	c.db.registerFuncs(conn, c.app)
+	return nil
+}
+
+// worker's connection has fixed policy
+func (c *DbConnector) authorizer(op int, arg1, arg2, dbName, trigger string) int {
+	if c.app.policy.Check(op, arg1, arg2, trigger) != "" {
+		return sqlite3.SQLITE_DENY
 	}
+	return sqlite3.SQLITE_OK
+}
 
-	return &cache, nil
+func (c *DbConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *DbConnector) Driver() driver.Driver {
+	return c.driver
 }
 
 type Db struct {
@@ -157,7 +223,58 @@ type Db struct {
 
 	cache []*DbCache
 
-	lastChange int
+	queries map[int64]*DbQuery //params of queries, so they can be cached again(key is query_hash)
+
+	//tables touched by statement, which is being prepared(collected by authorizer)
+	auth_tables map[string]bool
+	auth_all    bool //schema changed
+	auth_on     bool
+
+	//tables written by current transaction
+	tx_tables map[string]bool
+	tx_all    bool
+
+	explicit *DbTx //opened by app
+
+	migrate_pending map[*Asset]bool //migrations, which wait for commit of other assets' writes
+
+	//app, whose queries are running(nil = host)
+	policy_app    *App
+	policy_asset  string
+	policy_denied []string
+
+	undo DbUndo
+
+	search map[string]string //searchable tables and their columns
+
+	funcs         map[string]*DbFunc //registered by apps
+	funcs_changed bool               //connections are re-opened between frames
+	funcs_id      int64              //last id of aggregate's group
+
+	subs []*DbSubscription
+
+	asyncs    []*DbAsync
+	async_dbs map[*App]*sql.DB //read-only connections for app's workers
+
+	history         map[string]string //tables with history and their columns
+	history_enabled map[string]bool
+	writer          string //asset name in history writer table
+	writer_set      bool
+	writer_on       int //history writer table exists: -1 = not checked yet
+
+	corrupted    string      //problems found by quick_check
+	check        chan string //running quick_check
+	commit_ticks int
+
+	//external changes
+	watch          *sql.Conn
+	watch_ticks    int
+	data_version   int64            //-1 = not read yet
+	changes        map[string]int64 //versions of tables from _sa_changes
+	schema_version int64
+	assets         map[*Asset]bool
+
+	lastChange int //ms
 }
 
 func NewDb(root *Root, name string) (*Db, error) {
@@ -165,23 +282,72 @@ func NewDb(root *Root, name string) (*Db, error) {
 	db.root = root
 	db.name = name
 
-	var err error
-	db.db, err = sql.Open("sqlite3", "file:"+db.GetPath()+"?&_journal_mode=WAL")
-	if err != nil {
-		return nil, fmt.Errorf("Open(%s) failed: %w", db.GetPath(), err)
-	}
+	db.db = sql.OpenDB(NewDbConnector(&db, db.getDsn(), nil))
+	db.data_version = -1
+	db.writer_on = -1
 
 	db.UpdateTime()
 
 	return &db, nil
 }
 
+func (db *Db) authorizer(op int, arg1, arg2, dbName, trigger string) int {
+	if !db.checkPolicy(op, arg1, arg2, trigger) {
+		return sqlite3.SQLITE_DENY
+	}
+	if !db.auth_on {
+		return sqlite3.SQLITE_OK
+	}
+
+	switch op {
+	case sqlite3.SQLITE_READ, sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE:
+		db.auth_tables[strings.ToLower(arg1)] = true
+
+	case sqlite3.SQLITE_CREATE_TABLE, sqlite3.SQLITE_DROP_TABLE, sqlite3.SQLITE_ALTER_TABLE,
+		sqlite3.SQLITE_CREATE_VIEW, sqlite3.SQLITE_DROP_VIEW,
+		sqlite3.SQLITE_CREATE_TRIGGER, sqlite3.SQLITE_DROP_TRIGGER,
+		sqlite3.SQLITE_CREATE_VTABLE, sqlite3.SQLITE_DROP_VTABLE,
+		sqlite3.SQLITE_ATTACH, sqlite3.SQLITE_DETACH:
+		db.auth_all = true
+	}
+	return sqlite3.SQLITE_OK
+}
+
+// starts collecting tables which next statement touches
+func (db *Db) authStart() {
+	db.auth_tables = make(map[string]bool)
+	db.auth_all = false
+	db.auth_on = true
+}
+
+// returns tables(nil = unknown or whole schema)
+func (db *Db) authEnd() map[string]bool {
+	db.auth_on = false
+
+	tables := db.auth_tables
+	if db.auth_all || len(tables) == 0 {
+		tables = nil
+	}
+
+	db.auth_tables = nil
+	db.auth_all = false
+	return tables
+}
+
 func (db *Db) Destroy() error {
+	db.resetCache()
+	db.closeWatch()
+	db.closeAsync()
 	return db.db.Close()
 }
 
 func (db *Db) Begin() (*sql.Tx, error) {
 	if db.tx == nil {
+		//BEGIN is host's statement
+		app := db.policy_app
+		db.policy_app = nil
+		defer func() { db.policy_app = app }()
+
 		var err error
 		db.tx, err = db.db.Begin()
 		if err != nil {
@@ -195,26 +361,150 @@ func (db *Db) GetPath() string {
 	return db.root.folderDatabases + "/" + db.name + ".sqlite"
 }
 
+func (db *Db) getDsn() string {
+	return "file:" + db.GetPath() + "?&_journal_mode=WAL&_busy_timeout=5000" //sync writes from other connection
+}
+
 func (db *Db) Commit() error {
+	if db.tx == nil {
+		return nil //nothing was written
+	}
+	if db.tx_all {
+		err := db.installChanges(db.tx) //new tables
+		if err != nil {
+			fmt.Printf("installChanges() failed: %v\n", err)
+		}
+		err = db.rebuildHistory() //new columns
+		if err != nil {
+			fmt.Printf("rebuildHistory() failed: %v\n", err)
+		}
+	}
+	db.resetHistoryWriter()
+	db.checkUndoTables()
 	err := db.tx.Commit()
 	db.tx = nil
+	db.commit_ticks = OsTicks()
 
-	//reset queries
-	db.cache = nil
+	db.updateDataVersion() //not external change
+
+	if err != nil {
+		db.undo.Clear()
+	} else {
+		db.undo.commit(db.tx_all)
+	}
+
+	//reset queries which read changed tables
+	if db.tx_all {
+		db.markAllSubscriptions()
+		db.resetCache()
+		db.search = nil //schema changed
+		db.history = nil
+		db.history_enabled = nil
+		db.writer_on = -1
+	} else {
+		db.markSubscriptions(db.tx_tables)
+		db.resetCacheTables(db.tx_tables)
+	}
+	db.tx_tables = nil
+	db.tx_all = false
 
 	return err
 }
 
+// discards whole transaction
+func (db *Db) Rollback() error {
+	if db.tx == nil {
+		return nil
+	}
+	err := db.tx.Rollback()
+	db.tx = nil
+	db.explicit = nil
+	db.writer_set = false
+	db.undo.rollback()
+
+	//caches could read rolled back rows
+	if db.tx_all {
+		db.resetCache()
+		db.search = nil
+	} else {
+		db.resetCacheTables(db.tx_tables)
+	}
+	db.tx_tables = nil
+	db.tx_all = false
+
+	if err != nil {
+		return fmt.Errorf("Rollback(%s) failed: %w", db.GetPath(), err)
+	}
+	return nil
+}
+
+func (db *Db) resetCacheTables(tables map[string]bool) {
+	if len(tables) == 0 {
+		return
+	}
+
+	n := 0
+	for _, it := range db.cache {
+		if it.HasTables(tables) {
+			it.Destroy()
+		} else {
+			db.cache[n] = it
+			n++
+		}
+	}
+	db.cache = db.cache[:n]
+}
+
+// removes least recently used queries until cache fits into budget. 'keep' is being read.
+func (db *Db) checkCacheBudget(keep *DbCache) {
+	bytes := 0
+	for _, it := range db.cache {
+		bytes += it.GetBytes()
+	}
+
+	for bytes > Db_CACHE_MAX_BYTES && len(db.cache) > 1 {
+		old := -1
+		for i, it := range db.cache {
+			if it != keep && (old < 0 || it.used < db.cache[old].used) {
+				old = i
+			}
+		}
+
+		bytes -= db.cache[old].GetBytes()
+		db.cache[old].Destroy()
+		db.cache = append(db.cache[:old], db.cache[old+1:]...)
+	}
+}
+
+func (db *Db) resetCache() {
+	for _, it := range db.cache {
+		it.Destroy()
+	}
+	db.cache = nil
+
+	//schema could be changed
+	db.history = nil
+	db.history_enabled = nil
+	db.writer_on = -1
+}
+
 func (db *Db) ReOpen() error {
+	db.resetCache()
+	db.closeWatch()
+	db.closeAsync()
+	db.data_version = -1
+	db.undo.Clear()
+	db.search = nil
+	db.history = nil
+	db.history_enabled = nil
+	db.writer_on = -1
+
 	err := db.db.Close()
 	if err != nil {
 		return fmt.Errorf("Close(%s) failed: %w", db.GetPath(), err)
 	}
 
-	db.db, err = sql.Open("sqlite3", db.GetPath())
-	if err != nil {
-		return fmt.Errorf("Open(%s) failed: %w", db.GetPath(), err)
-	}
+	db.db = sql.OpenDB(NewDbConnector(db, db.getDsn(), nil))
 	return nil
 }
 
@@ -224,10 +514,17 @@ func (db *Db) UpdateTime() bool {
 	if os.IsNotExist(err) {
 		return false
 	}
+	tm := info.ModTime().UnixMilli()
+
+	//commits are in WAL file until checkpoint
+	walInfo, err := os.Stat(db.GetPath() + "-wal")
+	if err == nil && walInfo.ModTime().UnixMilli() > tm {
+		tm = walInfo.ModTime().UnixMilli()
+	}
 
-	diff := info.ModTime().Unix() != int64(db.lastChange)
+	diff := tm != int64(db.lastChange)
 
-	db.lastChange = int(info.ModTime().Unix())
+	db.lastChange = int(tm)
 	return diff
 
 }
@@ -237,27 +534,105 @@ func (db *Db) FindCache(query_hash int64) *DbCache {
 	//find
 	for _, it := range db.cache {
 		if it.query_hash == query_hash {
+			it.used = OsTicks()
 			return it
 		}
 	}
 	return nil
 }
 
-func (db *Db) AddCache(query string) (*DbCache, error) {
+// remembers query with params, so cache can be added again after it was reset
+func (db *Db) addQuery(query_hash int64, query string, params []byte) {
+	if db.queries == nil {
+		db.queries = make(map[int64]*DbQuery)
+	}
+
+	q, found := db.queries[query_hash]
+	if !found {
+		if len(db.queries) >= Db_QUERIES_MAX {
+			db.removeOldestQuery()
+		}
+		q = &DbQuery{query: query, params: bytes.Clone(params)}
+		db.queries[query_hash] = q
+	}
+	q.used = OsTicks()
+}
+
+func (db *Db) removeOldestQuery() {
+	var old int64
+	var oldQ *DbQuery
+	for h, q := range db.queries {
+		if oldQ == nil || q.used < oldQ.used {
+			old = h
+			oldQ = q
+		}
+	}
+	delete(db.queries, old)
+}
+
+// finds cache by hash from app. When it was reset, it's added again with its params
+func (db *Db) GetCache(query string, query_hash int64) (*DbCache, error) {
+	cache := db.FindCache(query_hash)
+	if cache != nil && cache.query == query {
+		err := db.checkCacheApp(cache)
+		if err != nil {
+			return nil, err
+		}
+		return cache, nil
+	}
+
+	var params []byte
+	if q, found := db.queries[query_hash]; found && q.query == query {
+		params = q.params
+	}
+	return db.AddCache(query, params)
+}
+
+// query could be prepared under other app's policy
+func (db *Db) checkCacheApp(cache *DbCache) error {
+	if db.policy_app == nil || cache.app == db.policy_app {
+		return nil
+	}
+
+	stmt, err := db.db.Prepare(cache.query)
+	if err != nil {
+		return fmt.Errorf("Prepare(%s) failed: %w", cache.query, db.deniedErr(cache.query, err))
+	}
+	stmt.Close()
+	cache.app = db.policy_app
+	return nil
+}
+
+func (db *Db) AddCache(query string, params []byte) (*DbCache, error) {
+
+	st := time.Now()
+
+	query_hash := DbCache_hash(query, params)
+	db.addQuery(query_hash, query, params)
 
 	//find
-	for _, it := range db.cache {
-		if it.query == query {
-			return it, nil
+	cache := db.FindCache(query_hash)
+	if cache != nil {
+		err := db.checkCacheApp(cache)
+		if err != nil {
+			return nil, err
 		}
+		db.addQueryLog(query, cache.params, time.Since(st), cache.row_count, true, false)
+		return cache, nil
 	}
 
 	//add
-	cache, err := NewDbCache(query, db.db)
+	db.authStart()
+	cache, err := NewDbCache(query, params, db.db)
+	tables := db.authEnd()
 	if err != nil {
-		return nil, fmt.Errorf("NewDbCache(%s) failed: %w", db.GetPath(), err)
+		return nil, fmt.Errorf("NewDbCache(%s) failed: %w", db.GetPath(), db.deniedErr(query, err))
 	}
+	cache.tables = tables
+	cache.app = db.policy_app
+	cache.log_id = db.addQueryLog(query, cache.params, time.Since(st), cache.row_count, false, false)
 
+	db.checkCacheBudget(nil)
 	db.cache = append(db.cache, cache)
 	return cache, nil
 }
@@ -269,9 +644,37 @@ func (db *Db) Write(query string, params ...any) (sql.Result, error) {
 		return nil, err
 	}
 
+	err = db.setHistoryWriter(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	st := time.Now()
+	undoPos := db.undo.mark()
+	db.authStart()
 	res, err := tx.Exec(query, params...)
+	tables := db.authEnd()
 	if err != nil {
-		return nil, fmt.Errorf("query(%s) failed: %w", query, err)
+		db.undo.truncate(undoPos) //statement was rolled back
+		return nil, fmt.Errorf("query(%s) failed: %w", query, db.deniedErr(query, err))
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		rows = -1
+	}
+	db.addQueryLog(query, params, time.Since(st), rows, false, true)
+
+	//remember what to reset in Commit()
+	if tables == nil {
+		db.tx_all = true
+	} else {
+		if db.tx_tables == nil {
+			db.tx_tables = make(map[string]bool)
+		}
+		for t := range tables {
+			db.tx_tables[t] = true
+		}
 	}
 
 	return res, nil
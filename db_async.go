@@ -0,0 +1,227 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Rows and count read by worker
+type DbAsyncResult struct {
+	start     int
+	rows      [][]byte
+	columns   []DbCacheColumn
+	row_count int64
+	dt        time.Duration
+	err       error
+}
+
+// Query running on worker goroutine. Result is moved into DbCache, so sql_readRow() serves it.
+type DbAsync struct {
+	query_hash int64
+	start      int  //first row of window, 0 = first read with count
+	redraw     bool //when finished
+
+	done chan DbAsyncResult
+	err  error
+
+	data_version int64 //failed query isn't run again until db is changed
+	reported     bool  //error was returned by ReadAsyncStatus()
+}
+
+// workers have own read-only connection with app's policy and SQL functions
+func (db *Db) getAsyncDb(app *App) *sql.DB {
+	conn := db.async_dbs[app]
+	if conn == nil {
+		conn = sql.OpenDB(NewDbConnector(db, "file:"+db.GetPath()+"?mode=ro&_busy_timeout=5000", app))
+
+		if db.async_dbs == nil {
+			db.async_dbs = make(map[*App]*sql.DB)
+		}
+		db.async_dbs[app] = conn
+	}
+	return conn
+}
+
+func (db *Db) closeAsync() {
+	db.asyncs = nil //workers write into buffered channels, nobody reads them
+	for _, conn := range db.async_dbs {
+		go conn.Close() //waits for running workers, which can wait for frame
+	}
+	db.async_dbs = nil
+}
+
+func (db *Db) findAsync(query_hash int64) *DbAsync {
+	for _, it := range db.asyncs {
+		if it.query_hash == query_hash {
+			return it
+		}
+	}
+	return nil
+}
+
+// returns query hash, which is handle for ReadAsyncStatus() and sql_readRow()
+func (db *Db) ReadAsync(asset *Asset, query string, params []byte, redraw bool) (int64, error) {
+	query_hash := DbCache_hash(query, params)
+
+	if a := db.findAsync(query_hash); a != nil {
+		if a.err == nil || a.data_version == db.data_version {
+			a.redraw = a.redraw || redraw
+			return query_hash, nil
+		}
+		db.removeAsync(a) //db was changed, try it again
+	}
+	cache := db.FindCache(query_hash)
+	if cache != nil && (cache.row_count >= 0 || len(cache.windows) > 0) {
+		return query_hash, nil //already read
+	}
+
+	//prepare only
+	db.SetPolicy(asset.app, asset.name)
+	cache, err := db.AddCache(query, params)
+	db.SetPolicy(nil, "")
+	if err != nil {
+		return -1, err
+	}
+	cache.async = true
+
+	db.startAsync(cache, 0, redraw)
+
+	return query_hash, nil
+}
+
+// window of async query, which isn't in memory, is read by worker too. Its rows are empty until it's finished.
+func (db *Db) readWindowAsync(cache *DbCache, start int) {
+	if a := db.findAsync(cache.query_hash); a != nil {
+		if a.err == nil || a.data_version == db.data_version {
+			return //other window is being read, app asks again after redraw
+		}
+		db.removeAsync(a)
+	}
+	db.startAsync(cache, start, true)
+}
+
+func (db *Db) startAsync(cache *DbCache, start int, redraw bool) {
+	a := &DbAsync{query_hash: cache.query_hash, start: start, redraw: redraw, done: make(chan DbAsyncResult, 1)}
+	db.asyncs = append(db.asyncs, a)
+
+	go DbAsync_run(db.root, db.getAsyncDb(cache.app), cache.query, cache.params, cache.pageable, start, a.done)
+}
+
+func DbAsync_run(root *Root, conn *sql.DB, query string, params []interface{}, pageable bool, start int, done chan DbAsyncResult) {
+	res := DbAsyncResult{start: start, row_count: -1}
+	st := time.Now()
+
+	cache := DbCache{query: query, params: params, pageable: pageable, row_count: -1}
+	root.wasm_mu.Lock() //authorizer reads app's policy
+	res.err = cache.prepare(conn)
+	root.wasm_mu.Unlock()
+	if res.err != nil {
+		done <- res
+		return
+	}
+	defer cache.Destroy()
+
+	if start == 0 {
+		res.rows, res.err = cache.readRowsAndCount(DbCache_WINDOW_ROWS)
+	} else {
+		res.rows, res.err = cache.readRows(start, DbCache_WINDOW_ROWS)
+	}
+	res.row_count = cache.row_count
+	res.columns = cache.columns
+	res.dt = time.Since(st)
+
+	done <- res
+}
+
+// returns 1 = finished, 0 = running, -1 = failed
+func (db *Db) ReadAsyncStatus(query_hash int64) (int64, error) {
+	a := db.findAsync(query_hash)
+	if a == nil {
+		if db.FindCache(query_hash) != nil {
+			return 1, nil
+		}
+		return 0, nil //cache was reset, ReadAsync() starts it again
+	}
+
+	if a.err != nil {
+		if a.reported {
+			return -1, nil //error is logged only once
+		}
+		a.reported = true
+		return -1, a.err
+	}
+	return 0, nil
+}
+
+func (db *Db) removeAsync(a *DbAsync) {
+	for i, it := range db.asyncs {
+		if it == a {
+			db.asyncs = append(db.asyncs[:i], db.asyncs[i+1:]...)
+			return
+		}
+	}
+}
+
+// moves finished results into caches
+func (root *Root) UpdateAsyncs() {
+	for _, db := range root.dbs {
+		n := 0
+		for _, a := range db.asyncs {
+			if a.err != nil {
+				db.asyncs[n] = a //kept until db is changed
+				n++
+				continue
+			}
+
+			var res DbAsyncResult
+			select {
+			case res = <-a.done:
+			default:
+				db.asyncs[n] = a //still running
+				n++
+				continue
+			}
+
+			if res.err != nil {
+				a.err = res.err
+				a.data_version = db.data_version
+				db.asyncs[n] = a
+				n++
+			} else {
+				cache := db.FindCache(a.query_hash)
+				if cache != nil && cache.findWindow(res.start) == nil { //cache can be reset by commit or already read by sql_readRow()
+					if cache.columns == nil {
+						cache.columns = res.columns
+					}
+					if cache.row_count < 0 {
+						cache.row_count = res.row_count
+					}
+					cache.addWindow(res.start, res.rows)
+					db.updateQueryLog(cache, res.dt, cache.row_count)
+					db.checkCacheBudget(cache)
+				}
+			}
+
+			if a.redraw {
+				root.ui.SetRedraw()
+			}
+		}
+		db.asyncs = db.asyncs[:n]
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func testWaitAsync(t *testing.T, db *Db, query_hash int64) {
+	t.Helper()
+
+	for i := 0; i < 500; i++ {
+		db.root.UpdateAsyncs()
+		status, err := db.ReadAsyncStatus(query_hash)
+		if status < 0 {
+			t.Fatal(err)
+		}
+		if status > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("async query didn't finish")
+}
+
+func TestReadAsyncWindows(t *testing.T) {
+	root := &Root{folderDatabases: t.TempDir(), ctx: context.Background(), dbs: make(map[string]*Db)}
+	db, err := root.AddDb("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Destroy() })
+
+	testWrite(t, db, "CREATE TABLE t(a INTEGER)")
+	testWrite(t, db, "WITH RECURSIVE c(x) AS (SELECT 0 UNION ALL SELECT x+1 FROM c WHERE x<999) INSERT INTO t SELECT x FROM c")
+	testCommit(t, db)
+
+	asset := &Asset{name: "main", app: &App{name: "test"}}
+	query_hash, err := db.ReadAsync(asset, "SELECT a FROM t ORDER BY a", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWaitAsync(t, db, query_hash)
+
+	//count comes with first window
+	cache := db.FindCache(query_hash)
+	if cache == nil || cache.row_count != 1000 || len(cache.windows) != 1 {
+		t.Fatal("first window and count weren't read")
+	}
+
+	//window after first one is read by worker
+	row, err := db.GetRow(cache, 700)
+	if err != nil || row != nil {
+		t.Fatalf("row was read on frame: %v %v", row, err)
+	}
+	testWaitAsync(t, db, query_hash)
+	if v := testRowInt(t, db, cache, 700); v != 700 {
+		t.Fatalf("row 700 has value %d", v)
+	}
+	if len(cache.windows) != 2 {
+		t.Fatalf("%d windows", len(cache.windows))
+	}
+}
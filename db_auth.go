@@ -0,0 +1,79 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+/*
+#include <stdint.h>
+
+int dbAuthSet(void* db, uintptr_t handle);
+int dbAuthDefensive(void* db);
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/cgo"
+	"unsafe"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Authorizer gets inner-most trigger or view, which go-sqlite3's RegisterAuthorizer() doesn't pass. Empty = statement itself.
+type DbAuthorizer func(op int, arg1, arg2, dbName, trigger string) int
+
+//export dbAuthCallback
+func dbAuthCallback(handle C.uintptr_t, op C.int, arg1, arg2, dbName, trigger *C.char) C.int {
+	fn := cgo.Handle(handle).Value().(DbAuthorizer)
+	return C.int(fn(int(op), C.GoString(arg1), C.GoString(arg2), C.GoString(dbName), C.GoString(trigger)))
+}
+
+// go-sqlite3 doesn't export handle of connection
+func DbAuth_handle(conn *sqlite3.SQLiteConn) (unsafe.Pointer, error) {
+	field := reflect.ValueOf(conn).Elem().FieldByName("db")
+	if !field.IsValid() || field.Kind() != reflect.Pointer {
+		return nil, errors.New("SQLiteConn has no handle")
+	}
+	return *(*unsafe.Pointer)(unsafe.Pointer(field.UnsafeAddr())), nil
+}
+
+// Handle lives as long as process, connections are kept by pool
+func DbAuth_register(conn *sqlite3.SQLiteConn, fn DbAuthorizer) error {
+	db, err := DbAuth_handle(conn)
+	if err != nil {
+		return err
+	}
+	rc := C.dbAuthSet(db, C.uintptr_t(cgo.NewHandle(fn)))
+	if rc != 0 {
+		return fmt.Errorf("sqlite3_set_authorizer() failed: %d", int(rc))
+	}
+	return nil
+}
+
+// App's statements can't write shadow tables of virtual tables(FTS5), only virtual table itself can
+func DbAuth_defensive(conn *sqlite3.SQLiteConn) error {
+	db, err := DbAuth_handle(conn)
+	if err != nil {
+		return err
+	}
+	rc := C.dbAuthDefensive(db)
+	if rc != 0 {
+		return fmt.Errorf("SQLITE_DBCONFIG_DEFENSIVE failed: %d", int(rc))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// C part of db_auth.go. It's in own file, because file with //export can only declare C functions.
+
+/*
+#include <stdint.h>
+
+typedef struct sqlite3 sqlite3;
+int sqlite3_set_authorizer(sqlite3*, int (*)(void*, int, const char*, const char*, const char*, const char*), void*);
+int sqlite3_db_config(sqlite3*, int, ...);
+
+int dbAuthCallback(uintptr_t handle, int op, char* arg1, char* arg2, char* dbName, char* trigger);
+
+static int dbAuthTrampoline(void* handle, int op, const char* arg1, const char* arg2, const char* dbName, const char* trigger) {
+	return dbAuthCallback((uintptr_t)handle, op, (char*)arg1, (char*)arg2, (char*)dbName, (char*)trigger);
+}
+
+int dbAuthSet(void* db, uintptr_t handle) {
+	return sqlite3_set_authorizer((sqlite3*)db, dbAuthTrampoline, (void*)handle);
+}
+
+int dbAuthDefensive(void* db) {
+	return sqlite3_db_config((sqlite3*)db, 1010, 1, (int*)0); //SQLITE_DBCONFIG_DEFENSIVE
+}
+*/
+import "C"
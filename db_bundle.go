@@ -0,0 +1,298 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// File: magic | salt | argon2 time, memory, threads | nonce | AES-256-GCM(meta size | meta json | db)
+// Header is authenticated too, so nothing in file can be changed without wrong passphrase error.
+const DbBundle_MAGIC = "SKYALT_BUNDLE_1"
+const DbBundle_FOLDER = "exports" //inside folderDatabases
+const DbBundle_EXT = ".skyalt"
+
+const DbBundle_SALT_SIZE = 16
+const DbBundle_ARGON_TIME = 3
+const DbBundle_ARGON_MEMORY = 64 * 1024 //KiB
+const DbBundle_ARGON_THREADS = 4
+
+type DbBundleMeta struct {
+	Name     string
+	Time     int64 //ms
+	Settings []DbSettingsRow
+	Hash     []byte //sha256 of db
+}
+
+func (root *Root) getBundlesFolder() string {
+	return root.folderDatabases + "/" + DbBundle_FOLDER
+}
+
+// returns bundle names(without extension) sorted
+func (root *Root) GetBundles() []string {
+	dir, err := os.ReadDir(root.getBundlesFolder())
+	if err != nil {
+		return nil
+	}
+
+	var list []string
+	for _, file := range dir {
+		if !file.IsDir() && strings.EqualFold(filepath.Ext(file.Name()), DbBundle_EXT) {
+			list = append(list, OsFileGetNameWithoutExt(file.Name()))
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func DbBundle_cipher(passphrase string, salt []byte, tm uint32, memory uint32, threads uint8) (cipher.AEAD, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase is empty")
+	}
+
+	key := argon2.IDKey([]byte(passphrase), salt, tm, memory, threads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("NewCipher() failed: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("NewGCM() failed: %w", err)
+	}
+	return aead, nil
+}
+
+func DbBundle_seal(plain []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, DbBundle_SALT_SIZE)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, fmt.Errorf("Read() failed: %w", err)
+	}
+
+	aead, err := DbBundle_cipher(passphrase, salt, DbBundle_ARGON_TIME, DbBundle_ARGON_MEMORY, DbBundle_ARGON_THREADS)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("Read() failed: %w", err)
+	}
+
+	header := []byte(DbBundle_MAGIC)
+	header = append(header, salt...)
+	header = binary.LittleEndian.AppendUint32(header, DbBundle_ARGON_TIME)
+	header = binary.LittleEndian.AppendUint32(header, DbBundle_ARGON_MEMORY)
+	header = append(header, DbBundle_ARGON_THREADS)
+	header = append(header, nonce...)
+
+	return aead.Seal(header, nonce, plain, header), nil
+}
+
+func DbBundle_open(data []byte, passphrase string) ([]byte, error) {
+	p := len(DbBundle_MAGIC)
+	if len(data) < p+DbBundle_SALT_SIZE+9 || string(data[:p]) != DbBundle_MAGIC {
+		return nil, errors.New("file is not bundle")
+	}
+
+	salt := data[p : p+DbBundle_SALT_SIZE]
+	p += DbBundle_SALT_SIZE
+	tm := binary.LittleEndian.Uint32(data[p:])
+	memory := binary.LittleEndian.Uint32(data[p+4:])
+	threads := data[p+8]
+	p += 9
+
+	//header isn't authenticated before key is derived, so file can't ask for more than DbBundle_seal() writes
+	if tm == 0 || tm > DbBundle_ARGON_TIME || memory == 0 || memory > DbBundle_ARGON_MEMORY || threads == 0 || threads > DbBundle_ARGON_THREADS {
+		return nil, errors.New("bundle has invalid key parameters")
+	}
+
+	aead, err := DbBundle_cipher(passphrase, salt, tm, memory, threads)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < p+aead.NonceSize() {
+		return nil, errors.New("bundle is corrupted")
+	}
+	nonce := data[p : p+aead.NonceSize()]
+	p += aead.NonceSize()
+
+	plain, err := aead.Open(nil, nonce, data[p:], data[:p])
+	if err != nil {
+		return nil, errors.New("wrong passphrase or bundle is corrupted")
+	}
+	return plain, nil
+}
+
+// Packs db and settings rows of apps opened with it. Returns bundle name.
+func (root *Root) ExportDb(name string, passphrase string) (string, error) {
+	err := DbSnapshot_checkName(name)
+	if err != nil {
+		return "", err
+	}
+
+	folder := root.getBundlesFolder()
+	err = os.MkdirAll(folder, 0700)
+	if err != nil {
+		return "", fmt.Errorf("MkdirAll(%s) failed: %w", folder, err)
+	}
+
+	bundle := name + "_" + time.Now().Format(DbSnapshot_FORMAT)
+	path := folder + "/" + bundle + DbBundle_EXT
+
+	//consistent copy
+	tmpPath := path + ".db"
+	err = root.vacuumInto(name, tmpPath)
+	if err != nil {
+		return "", err
+	}
+	dbData, err := os.ReadFile(tmpPath)
+	os.Remove(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("ReadFile(%s) failed: %w", tmpPath, err)
+	}
+
+	hash := sha256.Sum256(dbData)
+	meta := DbBundleMeta{Name: name, Time: time.Now().UnixMilli(), Settings: root.getDbSettingsRows(name), Hash: hash[:]}
+	js, err := json.Marshal(&meta)
+	if err != nil {
+		return "", fmt.Errorf("Marshal() failed: %w", err)
+	}
+
+	plain := binary.LittleEndian.AppendUint64(nil, uint64(len(js)))
+	plain = append(plain, js...)
+	plain = append(plain, dbData...)
+
+	data, err := DbBundle_seal(plain, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.WriteFile(path+".tmp", data, 0600)
+	if err == nil {
+		err = OsFileRename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return "", fmt.Errorf("WriteFile(%s) failed: %w", path, err)
+	}
+	return bundle, nil
+}
+
+// Checks that db file is valid SQLite database
+func DbBundle_checkDb(path string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("Open(%s) failed: %w", path, err)
+	}
+	defer db.Close()
+
+	var res string
+	err = db.QueryRow("PRAGMA integrity_check").Scan(&res)
+	if err != nil {
+		return fmt.Errorf("integrity_check failed: %w", err)
+	}
+	if res != "ok" {
+		return fmt.Errorf("integrity_check failed: %s", res)
+	}
+	return nil
+}
+
+// Replaces db with bundle content. Existing db is moved into trash. Returns db name.
+func (root *Root) ImportDb(bundle string, passphrase string) (string, error) {
+	if strings.ContainsRune(bundle, '/') || strings.ContainsRune(bundle, '\\') {
+		return "", fmt.Errorf("bundle(%s) has invalid character", bundle)
+	}
+	path := root.getBundlesFolder() + "/" + bundle + DbBundle_EXT
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("ReadFile(%s) failed: %w", path, err)
+	}
+	plain, err := DbBundle_open(data, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	//meta
+	if len(plain) < 8 {
+		return "", errors.New("bundle is corrupted")
+	}
+	n := binary.LittleEndian.Uint64(plain)
+	if n > uint64(len(plain)-8) {
+		return "", errors.New("bundle is corrupted")
+	}
+	var meta DbBundleMeta
+	err = json.Unmarshal(plain[8:8+n], &meta)
+	if err != nil {
+		return "", fmt.Errorf("Unmarshal() failed: %w", err)
+	}
+	dbData := plain[8+n:]
+
+	if DbSnapshot_checkName(meta.Name) != nil || meta.Name == root.baseDb {
+		return "", fmt.Errorf("bundle has invalid db name(%s)", meta.Name)
+	}
+	hash := sha256.Sum256(dbData)
+	if !bytes.Equal(hash[:], meta.Hash) {
+		return "", errors.New("bundle db hash doesn't match")
+	}
+
+	db, found := root.dbs[meta.Name]
+	if found && db.explicit != nil {
+		return "", fmt.Errorf("db(%s) is locked by transaction of asset(%s)", db.name, db.explicit.asset.name)
+	}
+
+	//check db before anything is replaced
+	tmpPath := root.folderDatabases + "/" + meta.Name + ".sqlite.import"
+	err = os.WriteFile(tmpPath, dbData, 0644)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("WriteFile(%s) failed: %w", tmpPath, err)
+	}
+	err = DbBundle_checkDb(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	err = root.replaceDbFile(meta.Name, tmpPath, true)
+	if err != nil {
+		return "", err
+	}
+
+	err = root.settings.ImportRows(meta.Settings)
+	if err != nil {
+		return "", err
+	}
+	return meta.Name, nil
+}
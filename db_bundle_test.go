@@ -0,0 +1,70 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	plain := []byte("db content")
+	data, err := DbBundle_seal(plain, "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := DbBundle_open(data, "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, plain) {
+		t.Fatal("opened content is different")
+	}
+
+	_, err = DbBundle_open(data, "other")
+	if err == nil {
+		t.Fatal("bundle was opened with wrong passphrase")
+	}
+}
+
+func TestBundleTamper(t *testing.T) {
+	data, err := DbBundle_seal([]byte("db content"), "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//changed content or header
+	for _, i := range []int{len(DbBundle_MAGIC), len(data) - 1} {
+		bad := bytes.Clone(data)
+		bad[i] ^= 1
+		_, err = DbBundle_open(bad, "pass")
+		if err == nil {
+			t.Fatalf("byte %d was changed, but bundle was opened", i)
+		}
+	}
+
+	//key parameters above sealed ones are rejected before key is derived
+	memPos := len(DbBundle_MAGIC) + DbBundle_SALT_SIZE + 4
+	bad := bytes.Clone(data)
+	binary.LittleEndian.PutUint32(bad[memPos:], 4*1024*1024)
+	_, err = DbBundle_open(bad, "pass")
+	if err == nil || err.Error() != "bundle has invalid key parameters" {
+		t.Fatalf("huge memory wasn't rejected: %v", err)
+	}
+}
@@ -0,0 +1,430 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const DbCache_WINDOW_ROWS = 256 //rows per window
+const DbCache_MAX_WINDOWS = 8   //windows kept in memory per query
+
+type DbCacheWindow struct {
+	start int //index of first row
+	rows  [][]byte
+
+	used int //ticks of last access
+}
+
+type DbCacheColumn struct {
+	name     string
+	tp       string //declared type
+	nullable int    //1 = yes, 0 = no, -1 = unknown
+}
+
+type DbCache struct {
+	query_hash int64
+	query      string
+	params     []interface{}
+
+	stmt     *sql.Stmt
+	pageable bool //SELECT/WITH/VALUES can be wrapped by COUNT(*) and LIMIT/OFFSET
+
+	//every window is read by own query, which is closed right after. Commit resets cache, so windows don't mix versions of rows
+	window_stmt *sql.Stmt //query wrapped by LIMIT/OFFSET, nil = not pageable
+
+	windows   []*DbCacheWindow
+	row_count int64 //-1 = not counted yet
+
+	columns []DbCacheColumn //filled by first read
+
+	async bool //read by worker, missing windows are read by worker too
+
+	tables map[string]bool //read by query(nil = unknown)
+	app    *App            //query was prepared under its policy
+	used   int             //ticks of last access
+	log_id int64           //item in DbQueryLog
+}
+
+func DbCache_hash(query string, params []byte) int64 {
+	h := sha256.New()
+	h.Write([]byte(query))
+	h.Write(params)
+	return int64(binary.LittleEndian.Uint64(h.Sum(nil)))
+}
+
+func DbCache_isPageable(query string) bool {
+	q := strings.ToUpper(strings.TrimSpace(query))
+	return strings.HasPrefix(q, "SELECT") || strings.HasPrefix(q, "WITH") || strings.HasPrefix(q, "VALUES")
+}
+
+func DbCache_trimQuery(query string) string {
+	return strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")
+}
+
+// Query is only prepared here. Rows are read in windows when app asks for them.
+func NewDbCache(query string, params []byte, db *sql.DB) (*DbCache, error) {
+	var cache DbCache
+	cache.query = query
+	cache.query_hash = DbCache_hash(query, params)
+	cache.row_count = -1
+	cache.used = OsTicks()
+
+	var err error
+	cache.params, err = _arrayToParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.pageable = DbCache_isPageable(query)
+	err = cache.prepare(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cache, nil
+}
+
+func (cache *DbCache) prepare(db *sql.DB) error {
+	var err error
+	cache.stmt, err = db.Prepare(cache.query)
+	if err != nil {
+		return fmt.Errorf("Prepare(%s) failed: %w", cache.query, err)
+	}
+
+	if cache.pageable {
+		cache.window_stmt, err = db.Prepare("SELECT * FROM (" + DbCache_trimQuery(cache.query) + ") LIMIT ? OFFSET ?")
+		if err != nil {
+			cache.window_stmt = nil
+			cache.pageable = false //can't be wrapped, rows are skipped
+		}
+	}
+	return nil
+}
+
+func (cache *DbCache) Destroy() {
+	if cache.stmt != nil {
+		cache.stmt.Close()
+		cache.stmt = nil
+	}
+	if cache.window_stmt != nil {
+		cache.window_stmt.Close()
+		cache.window_stmt = nil
+	}
+	cache.windows = nil
+}
+
+func (cache *DbCache) HasTables(tables map[string]bool) bool {
+	if cache.tables == nil {
+		return true //unknown => reset it
+	}
+	for t := range tables {
+		if cache.tables[t] {
+			return true
+		}
+	}
+	return false
+}
+
+func (cache *DbCache) GetBytes() int {
+	bytes := len(cache.query)
+	for _, w := range cache.windows {
+		for _, r := range w.rows {
+			bytes += len(r)
+		}
+	}
+	return bytes
+}
+
+func (cache *DbCache) setColumns(rows *sql.Rows) error {
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return fmt.Errorf("ColumnTypes() failed: %w", err)
+	}
+
+	cache.columns = make([]DbCacheColumn, len(colTypes))
+	for i, c := range colTypes {
+		cache.columns[i].name = c.Name()
+		cache.columns[i].tp = c.DatabaseTypeName()
+		cache.columns[i].nullable = -1
+		if nullable, ok := c.Nullable(); ok {
+			cache.columns[i].nullable = OsTrn(nullable, 1, 0)
+		}
+	}
+	return nil
+}
+
+// runs query for one window and closes it, so no connection is held between frames. Row count is set when end is reached.
+func (cache *DbCache) readRows(start int, max int) ([][]byte, error) {
+	var rows *sql.Rows
+	var err error
+	skip := 0
+	if cache.window_stmt != nil {
+		rows, err = cache.window_stmt.Query(append(cache.params[:len(cache.params):len(cache.params)], max, start)...)
+	} else {
+		rows, err = cache.stmt.Query(cache.params...)
+		skip = start
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Query(%s) failed: %w", cache.query, err)
+	}
+	defer rows.Close()
+
+	if cache.columns == nil {
+		err = cache.setColumns(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([]interface{}, len(cache.columns))
+	var result [][]byte
+	for len(result) < max && rows.Next() {
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		row, err := DbCache_scanRow(rows, values)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Next() failed: %w", err)
+	}
+
+	//end was reached
+	if len(result) < max {
+		if cache.window_stmt == nil {
+			cache.row_count = int64(start - skip + len(result))
+		} else if len(result) > 0 || start == 0 {
+			cache.row_count = int64(start + len(result)) //empty window after end doesn't tell where it is
+		}
+	}
+
+	return result, nil
+}
+
+// reads first rows and steps through the rest, so query runs only once for rows and count
+func (cache *DbCache) readRowsAndCount(max int) ([][]byte, error) {
+	rows, err := cache.stmt.Query(cache.params...)
+	if err != nil {
+		return nil, fmt.Errorf("Query(%s) failed: %w", cache.query, err)
+	}
+	defer rows.Close()
+
+	err = cache.setColumns(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(cache.columns))
+	var result [][]byte
+	n := int64(0)
+	for rows.Next() {
+		n++
+		if len(result) == max {
+			continue //only count
+		}
+
+		row, err := DbCache_scanRow(rows, values)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Next() failed: %w", err)
+	}
+
+	cache.row_count = n
+	return result, nil
+}
+
+func DbCache_scanRow(rows *sql.Rows, values []interface{}) ([]byte, error) {
+	scanCallArgs := make([]interface{}, len(values))
+	for i := range values {
+		values[i] = nil //reset
+		scanCallArgs[i] = &values[i]
+	}
+	err := rows.Scan(scanCallArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("Scan() failed: %w", err)
+	}
+
+	var row []byte
+	for _, v := range values {
+		row = _argsToArray(row, v)
+	}
+	return row, nil
+}
+
+// writes all rows into hash without keeping them. Returns number of rows.
+func (cache *DbCache) HashRows(h io.Writer, max int) (int64, error) {
+	rows, err := cache.stmt.Query(cache.params...)
+	if err != nil {
+		return -1, fmt.Errorf("Query(%s) failed: %w", cache.query, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return -1, fmt.Errorf("Columns() failed: %w", err)
+	}
+	values := make([]interface{}, len(cols))
+	scanCallArgs := make([]interface{}, len(cols))
+	for i := range values {
+		scanCallArgs[i] = &values[i]
+	}
+
+	n := int64(0)
+	var row []byte
+	for rows.Next() {
+		n++
+		if n > int64(max) {
+			continue //only count
+		}
+
+		for j := range values {
+			values[j] = nil
+		}
+		err := rows.Scan(scanCallArgs...)
+		if err != nil {
+			return -1, fmt.Errorf("Scan() failed: %w", err)
+		}
+
+		row = row[:0]
+		for _, v := range values {
+			row = _argsToArray(row, v)
+		}
+		h.Write(row)
+	}
+	return n, rows.Err()
+}
+
+func (cache *DbCache) findWindow(row_i int) *DbCacheWindow {
+	for _, w := range cache.windows {
+		if row_i >= w.start && row_i < w.start+DbCache_WINDOW_ROWS {
+			return w
+		}
+	}
+	return nil
+}
+
+func (cache *DbCache) evictWindows() {
+	for len(cache.windows) > DbCache_MAX_WINDOWS {
+		//find least recently used
+		old := 0
+		for i, w := range cache.windows {
+			if w.used < cache.windows[old].used {
+				old = i
+			}
+		}
+		cache.windows = append(cache.windows[:old], cache.windows[old+1:]...)
+	}
+}
+
+func DbCache_windowStart(row_i int) int {
+	return row_i - (row_i % DbCache_WINDOW_ROWS)
+}
+
+func (cache *DbCache) addWindow(start int, rows [][]byte) *DbCacheWindow {
+	w := &DbCacheWindow{start: start, rows: rows, used: OsTicks()}
+	cache.windows = append(cache.windows, w)
+	cache.evictWindows()
+	return w
+}
+
+func (cache *DbCache) readWindow(start int) (*DbCacheWindow, error) {
+	rows, err := cache.readRows(start, DbCache_WINDOW_ROWS)
+	if err != nil {
+		return nil, err
+	}
+	return cache.addWindow(start, rows), nil
+}
+
+func (cache *DbCache) GetRow(row_i int) ([]byte, error) {
+	if row_i < 0 || (cache.row_count >= 0 && int64(row_i) >= cache.row_count) {
+		return nil, nil //out of range
+	}
+
+	w := cache.findWindow(row_i)
+	if w == nil {
+		var err error
+		w, err = cache.readWindow(DbCache_windowStart(row_i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.used = OsTicks()
+
+	if row_i-w.start < len(w.rows) {
+		return w.rows[row_i-w.start], nil
+	}
+	return nil, nil //no more rows
+}
+
+// returns columns encoded by _argsToArray(): name, type, nullable for every column
+func (cache *DbCache) GetColumns() ([]byte, error) {
+	if cache.columns == nil {
+		_, err := cache.readWindow(0) //fills columns
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var data []byte
+	for _, c := range cache.columns {
+		data = _argsToArray(data, c.name)
+		data = _argsToArray(data, c.tp)
+		data = _argsToArray(data, c.nullable)
+	}
+	return data, nil
+}
+
+func (cache *DbCache) GetRowCount(db *sql.DB) (int64, error) {
+	if cache.row_count >= 0 {
+		return cache.row_count, nil
+	}
+
+	if cache.pageable {
+		row := db.QueryRow("SELECT COUNT(*) FROM ("+DbCache_trimQuery(cache.query)+")", cache.params...)
+		err := row.Scan(&cache.row_count)
+		if err != nil {
+			cache.row_count = -1
+			return -1, fmt.Errorf("Count(%s) failed: %w", cache.query, err)
+		}
+	} else {
+		//step through all rows
+		n, err := cache.HashRows(io.Discard, 0)
+		if err != nil {
+			return -1, err
+		}
+		cache.row_count = n
+	}
+
+	return cache.row_count, nil
+}
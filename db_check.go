@@ -0,0 +1,325 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const DbCheck_CHECKPOINT_IDLE = 10000 //ms without commit
+const DbCheck_MAX_PROBLEMS = 10       //lines kept from quick_check
+
+// returns problems found by quick_check("" = ok)
+// runs on goroutine with own read-only connection
+func DbCheck_quickCheck(path string) string {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err.Error()
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query("PRAGMA quick_check")
+	if err != nil {
+		return err.Error() //not a database, malformed schema, etc.
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var str string
+		err := rows.Scan(&str)
+		if err != nil {
+			return err.Error()
+		}
+		if str != "ok" && len(problems) < DbCheck_MAX_PROBLEMS {
+			problems = append(problems, str)
+		}
+	}
+	if rows.Err() != nil {
+		problems = append(problems, rows.Err().Error())
+	}
+	return strings.Join(problems, "\n")
+}
+
+// quick_check reads whole file, so it runs on background and doesn't slow down opening
+func (db *Db) StartQuickCheck() {
+	db.check = make(chan string, 1)
+	path := db.GetPath()
+	go func() {
+		db.check <- DbCheck_quickCheck(path)
+	}()
+}
+
+// moves finished quick_check result into db.corrupted
+func (db *Db) updateQuickCheck() {
+	if db.check == nil {
+		return
+	}
+	select {
+	case db.corrupted = <-db.check:
+		db.check = nil
+		if db.corrupted != "" {
+			fmt.Printf("db(%s) is corrupted: %s\n", db.name, db.corrupted)
+		}
+	default:
+	}
+}
+
+// returns -1 when file doesn't exist
+func DbCheck_fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// moves WAL content into db file and truncates WAL
+func (db *Db) Checkpoint() error {
+	var busy, log, checkpointed int64
+	err := db.db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &log, &checkpointed)
+	if err != nil {
+		return fmt.Errorf("wal_checkpoint(%s) failed: %w", db.GetPath(), err)
+	}
+	if busy != 0 {
+		return fmt.Errorf("wal_checkpoint(%s) is blocked by reader", db.GetPath())
+	}
+	return nil
+}
+
+// Checkpoints one db, which wasn't written for a while
+func (root *Root) MaintenanceCheckpoints() {
+	for _, db := range root.dbs {
+		if db.tx != nil || db.explicit != nil || OsTicks()-db.commit_ticks < DbCheck_CHECKPOINT_IDLE || DbCheck_fileSize(db.GetPath()+"-wal") <= 0 {
+			continue
+		}
+
+		err := db.Checkpoint()
+		if err != nil {
+			fmt.Printf("Checkpoint() failed: %v\n", err)
+		}
+		db.commit_ticks = OsTicks() //don't try again until next write
+		return
+	}
+}
+
+func DbCheck_copyRows(src *sql.DB, dst *sql.Tx, table string) (int64, error) {
+	name := DbUndo_quoteName(table)
+
+	//rowid is kept, so references stay valid
+	rows, err := src.Query("SELECT rowid, * FROM " + name)
+	if err != nil {
+		rows, err = src.Query("SELECT * FROM " + name) //WITHOUT ROWID
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	for i := range cols {
+		cols[i] = DbUndo_quoteName(cols[i])
+	}
+	stmt, err := dst.Prepare("INSERT INTO " + name + "(" + strings.Join(cols, ", ") + ") VALUES(?" + strings.Repeat(", ?", len(cols)-1) + ")")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	values := make([]interface{}, len(cols))
+	scanCallArgs := make([]interface{}, len(cols))
+	for i := range cols {
+		scanCallArgs[i] = &values[i]
+	}
+
+	n := int64(0)
+	for rows.Next() {
+		err := rows.Scan(scanCallArgs...)
+		if err != nil {
+			return n, err
+		}
+		_, err = stmt.Exec(values...)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err() //corrupted page stops reading, rows before it are kept
+}
+
+// Salvages readable schema and rows into fresh file. Corrupted file is moved into trash. Returns report.
+func (root *Root) RecoverDb(name string) (string, error) {
+	path := root.folderDatabases + "/" + name + ".sqlite"
+	tmpPath := path + ".recover"
+
+	db, found := root.dbs[name]
+	if found {
+		if db.explicit != nil {
+			return "", fmt.Errorf("db(%s) is locked by transaction of asset(%s)", name, db.explicit.asset.name)
+		}
+		err := db.commitPending()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return "", fmt.Errorf("Open(%s) failed: %w", path, err)
+	}
+	defer src.Close()
+
+	os.Remove(tmpPath)
+	dst, err := sql.Open("sqlite3", "file:"+tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("Open(%s) failed: %w", tmpPath, err)
+	}
+	defer dst.Close()
+
+	//schema
+	type Object struct {
+		tp, name, sql string
+	}
+	var objects []Object
+	{
+		rows, err := src.Query("SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'")
+		if err != nil {
+			return "", fmt.Errorf("schema(%s) is unreadable: %w", path, err)
+		}
+		for rows.Next() {
+			var o Object
+			err := rows.Scan(&o.tp, &o.name, &o.sql)
+			if err != nil {
+				break
+			}
+			objects = append(objects, o)
+		}
+		rows.Close()
+	}
+
+	tx, err := dst.Begin()
+	if err != nil {
+		return "", fmt.Errorf("Begin(%s) failed: %w", tmpPath, err)
+	}
+
+	var report []string
+	created := make(map[string]bool)
+
+	//tables first(virtual tables create own shadow tables)
+	for _, vtab := range []bool{true, false} {
+		for _, o := range objects {
+			if o.tp != "table" || created[o.name] || strings.HasPrefix(strings.ToUpper(o.sql), "CREATE VIRTUAL") != vtab {
+				continue
+			}
+			_, err := tx.Exec(o.sql)
+			if err != nil {
+				if !strings.Contains(err.Error(), "already exists") {
+					report = append(report, fmt.Sprintf("table(%s) lost: %v", o.name, err))
+				}
+				continue
+			}
+			created[o.name] = true
+		}
+	}
+
+	//rows
+	for _, o := range objects {
+		if !created[o.name] || strings.HasPrefix(strings.ToUpper(o.sql), "CREATE VIRTUAL") {
+			continue
+		}
+		n, err := DbCheck_copyRows(src, tx, o.name)
+		if err != nil {
+			report = append(report, fmt.Sprintf("table(%s) salvaged %d rows: %v", o.name, n, err))
+		}
+	}
+
+	//indexes, views, triggers after rows, so triggers don't fire
+	for _, o := range objects {
+		if o.tp == "table" {
+			continue
+		}
+		_, err := tx.Exec(o.sql)
+		if err != nil {
+			report = append(report, fmt.Sprintf("%s(%s) lost: %v", o.tp, o.name, err))
+		}
+	}
+
+	//external content indexes are filled from salvaged rows
+	for _, o := range objects {
+		if created[o.name] && strings.HasPrefix(o.name, DbSearch_PREFIX) {
+			fts := DbUndo_quoteName(o.name)
+			_, err := tx.Exec("INSERT INTO " + fts + "(" + fts + ") VALUES('rebuild')")
+			if err != nil {
+				report = append(report, fmt.Sprintf("search(%s) lost: %v", o.name, err))
+			}
+		}
+	}
+
+	var version int64
+	if src.QueryRow("PRAGMA user_version").Scan(&version) == nil {
+		tx.Exec(fmt.Sprintf("PRAGMA user_version=%d", version))
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("Commit(%s) failed: %w", tmpPath, err)
+	}
+	dst.Close()
+	src.Close()
+
+	err = DbBundle_checkDb(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	err = root.replaceDbFile(name, tmpPath, true)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(report, "\n"), nil
+}
+
+// returns db file(wal=false) or WAL file(wal=true) size in bytes, -1 = doesn't exist
+func (root *Root) GetDbSize(name string, wal bool) int64 {
+	if strings.ContainsRune(name, '/') || strings.ContainsRune(name, '\\') {
+		return -1
+	}
+	path := root.folderDatabases + "/" + name + ".sqlite"
+	if wal {
+		path += "-wal"
+	}
+	return DbCheck_fileSize(path)
+}
+
+// returns quick_check problems of opened db
+func (root *Root) GetDbCorruption(name string) string {
+	db, found := root.dbs[name]
+	if !found {
+		return ""
+	}
+	db.updateQuickCheck()
+	return db.corrupted
+}
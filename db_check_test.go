@@ -0,0 +1,106 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+)
+
+// writes table over many pages and overwrites one of them with garbage
+func testCorruptedDb(t *testing.T, path string) {
+	t.Helper()
+
+	conn, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec("CREATE TABLE t(a TEXT)")
+	if err == nil {
+		_, err = conn.Exec("WITH RECURSIVE c(x) AS (SELECT 0 UNION ALL SELECT x+1 FROM c WHERE x<199) INSERT INTO t SELECT printf('%500d', x) FROM c")
+	}
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.WriteAt([]byte(strings.Repeat("\xff", 4096)), 4096*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuickCheck(t *testing.T) {
+	db := newTestDb(t)
+	testWrite(t, db, "CREATE TABLE t(a INTEGER)")
+	testCommit(t, db)
+	if problems := DbCheck_quickCheck(db.GetPath()); problems != "" {
+		t.Fatalf("healthy db has problems: %s", problems)
+	}
+
+	path := t.TempDir() + "/corrupted.sqlite"
+	testCorruptedDb(t, path)
+	if DbCheck_quickCheck(path) == "" {
+		t.Fatal("corruption wasn't found")
+	}
+}
+
+func TestRecoverDb(t *testing.T) {
+	root := &Root{folderDatabases: t.TempDir(), ctx: context.Background(), dbs: make(map[string]*Db)}
+	t.Cleanup(func() {
+		for _, db := range root.dbs {
+			db.Destroy()
+		}
+	})
+	var err error
+	root.settings, err = NewDbSettings(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCorruptedDb(t, root.folderDatabases+"/notes.sqlite")
+
+	report, err := root.RecoverDb("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report, "table(t) salvaged") {
+		t.Fatalf("wrong report: %s", report)
+	}
+	if len(root.GetTrash()) != 1 {
+		t.Fatal("corrupted file wasn't moved into trash")
+	}
+
+	db, err := root.AddDb("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := testCount(t, db, "SELECT COUNT(*) FROM t"); n == 0 || n >= 200 {
+		t.Fatalf("%d rows were salvaged", n)
+	}
+	if problems := DbCheck_quickCheck(db.GetPath()); problems != "" {
+		t.Fatalf("recovered db has problems: %s", problems)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQL function implemented by asset's exports
+type DbFunc struct {
+	name  string
+	app   string
+	asset string
+	fn    string //scalar: exported function
+
+	//aggregate: exports get id of group as first argument
+	init  string
+	step  string
+	final string
+}
+
+func (f *DbFunc) IsAggregate() bool {
+	return f.step != ""
+}
+
+// state of one group, rows are sent to step export one by one
+type DbFuncAggregate struct {
+	db      *Db
+	f       *DbFunc
+	worker  *App
+	id      int64
+	started bool
+}
+
+func DbFunc_checkName(name string) error {
+	if len(name) == 0 {
+		return fmt.Errorf("name is empty")
+	}
+	for i, ch := range name {
+		if !(ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (i > 0 && ch >= '0' && ch <= '9')) {
+			return fmt.Errorf("name(%s) has invalid character '%c'", name, ch)
+		}
+	}
+	return nil
+}
+
+func (db *Db) SetFunction(app *App, asset string, name string, fn string) error {
+	if len(fn) == 0 {
+		return fmt.Errorf("'fn' is empty")
+	}
+	return db.addFunc(&DbFunc{name: name, app: app.name, asset: asset, fn: fn})
+}
+
+func (db *Db) SetAggregate(app *App, asset string, name string, init string, step string, final string) error {
+	if len(step) == 0 || len(final) == 0 {
+		return fmt.Errorf("'step' or 'final' is empty")
+	}
+	return db.addFunc(&DbFunc{name: name, app: app.name, asset: asset, init: init, step: step, final: final})
+}
+
+// functions are registered when connection is opened. New ones are available from next frame.
+func (db *Db) addFunc(f *DbFunc) error {
+	err := DbFunc_checkName(f.name)
+	if err != nil {
+		return err
+	}
+
+	//apps usually call it every frame
+	if it, found := db.funcs[f.name]; found {
+		if *it == *f {
+			return nil
+		}
+		if it.app != f.app {
+			return fmt.Errorf("function(%s) is already registered by app(%s)", f.name, it.app)
+		}
+	}
+
+	if db.funcs == nil {
+		db.funcs = make(map[string]*DbFunc)
+	}
+	db.funcs[f.name] = f
+	db.funcs_changed = true
+	return nil
+}
+
+// called between frames, when no transaction is running
+func (db *Db) applyFuncs() error {
+	if !db.funcs_changed || db.tx != nil {
+		return nil
+	}
+	db.funcs_changed = false
+	return db.reconnect()
+}
+
+// re-opens connections(undo history and watch stay)
+func (db *Db) reconnect() error {
+	db.resetCache()
+
+	err := db.db.Close()
+	if err != nil {
+		return fmt.Errorf("Close(%s) failed: %w", db.GetPath(), err)
+	}
+
+	db.db = sql.OpenDB(NewDbConnector(db, db.getDsn(), nil))
+	return nil
+}
+
+// 'worker' is app, whose workers use connection
+func (db *Db) registerFuncs(conn *sqlite3.SQLiteConn, worker *App) {
+	for _, f := range db.funcs {
+		var err error
+		if f.IsAggregate() {
+			f := f
+			err = conn.RegisterAggregator(f.name, func() *DbFuncAggregate {
+				return &DbFuncAggregate{db: db, f: f, worker: worker, id: atomic.AddInt64(&db.funcs_id, 1)}
+			}, false)
+		} else {
+			err = conn.RegisterFunc(f.name, db.scalarFunc(f, worker), false)
+		}
+		if err != nil {
+			fmt.Printf("RegisterFunc(%s) failed: %v\n", f.name, err)
+		}
+	}
+}
+
+func (db *Db) scalarFunc(f *DbFunc, worker *App) func(args ...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		var data []byte
+		for _, it := range args {
+			data = _argsToArray(data, it)
+		}
+		return db.callFunc(f, f.fn, data, worker)
+	}
+}
+
+func (ag *DbFuncAggregate) start() error {
+	if ag.started {
+		return nil
+	}
+	ag.started = true
+
+	if ag.f.init == "" {
+		return nil
+	}
+	_, err := ag.db.callFunc(ag.f, ag.f.init, _argsToArray(nil, ag.id), ag.worker)
+	return err
+}
+
+func (ag *DbFuncAggregate) Step(args ...interface{}) error {
+	err := ag.start()
+	if err != nil {
+		return err
+	}
+
+	data := _argsToArray(nil, ag.id)
+	for _, it := range args {
+		data = _argsToArray(data, it)
+	}
+	_, err = ag.db.callFunc(ag.f, ag.f.step, data, ag.worker)
+	return err
+}
+
+func (ag *DbFuncAggregate) Done() (interface{}, error) {
+	err := ag.start() //group without rows
+	if err != nil {
+		return nil, err
+	}
+	return ag.db.callFunc(ag.f, ag.f.final, _argsToArray(nil, ag.id), ag.worker)
+}
+
+// calls export of asset, which belongs to app, whose query is running. 'worker' is set when query runs on worker goroutine.
+func (db *Db) callFunc(f *DbFunc, fn string, args []byte, worker *App) (interface{}, error) {
+	app := worker
+	if worker != nil {
+		db.root.wasm_mu.Lock() //waits until frame is finished
+		defer db.root.wasm_mu.Unlock()
+	} else {
+		app = db.policy_app
+	}
+	if app == nil || app.name != f.app {
+		return nil, fmt.Errorf("function(%s) can be used only by app(%s)", f.name, f.app)
+	}
+
+	asset := app.FindAsset(f.asset)
+	if asset == nil {
+		return nil, fmt.Errorf("Asset(%s) not found", f.asset)
+	}
+	if asset.wasm == nil {
+		return nil, fmt.Errorf("function(%s) needs wasm module(debug mode is not supported)", f.name)
+	}
+
+	ret, err := asset.wasm.CallSql(fn, args)
+	if err != nil {
+		return nil, fmt.Errorf("function(%s) failed: %w", f.name, err)
+	}
+	return ret, nil
+}
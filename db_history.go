@@ -0,0 +1,338 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const DbHistory_PREFIX = "_history_"          //old row versions(skipped by sync and undo)
+const DbHistory_TABLE = "_sa_history"         //tables with history: tbl, cols, enabled
+const DbHistory_WRITER = "_sa_history_writer" //asset, which is writing. NULL = external change
+const DbHistory_TRIGGER = "_sa_history_"
+
+// seconds since 1970, same as info_float("time_utc")
+const DbHistory_NOW = "((julianday('now') - 2440587.5) * 86400.0)"
+
+func DbHistory_table(table string) string {
+	return DbHistory_PREFIX + table
+}
+
+// returns tracked columns, empty = table has no history
+func (db *Db) getHistoryColumns(table string) (string, bool, error) {
+	cols, found := db.history[table]
+	if found {
+		return cols, db.history_enabled[table], nil
+	}
+	defer db.hostPolicy()()
+
+	tx, err := db.Begin() //sees uncommitted schema
+	if err != nil {
+		return "", false, err
+	}
+
+	enabled := false
+	n := 0
+	err = tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name=? OR name=?", DbHistory_TABLE, DbHistory_table(table)).Scan(&n)
+	if err != nil {
+		return "", false, fmt.Errorf("Query(sqlite_master) failed: %w", err)
+	}
+	if n == 2 {
+		err = tx.QueryRow("SELECT cols, enabled FROM "+DbHistory_TABLE+" WHERE tbl=?", table).Scan(&cols, &enabled)
+		if err != nil && err != sql.ErrNoRows {
+			return "", false, fmt.Errorf("Query(%s) failed: %w", DbHistory_TABLE, err)
+		}
+	}
+
+	if db.history == nil {
+		db.history = make(map[string]string)
+		db.history_enabled = make(map[string]bool)
+	}
+	db.history[table] = cols
+	db.history_enabled[table] = enabled
+	return cols, enabled, nil
+}
+
+func (db *Db) dropHistoryTriggers(table string) error {
+	for _, tp := range []string{"ai", "au", "ad"} {
+		_, err := db.Write("DROP TRIGGER IF EXISTS " + DbUndo_quoteName(DbHistory_TRIGGER+tp+"_"+table))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Starts(or stops) copying old row versions into _history_<table>. Old versions are kept when history is disabled.
+func (db *Db) SetHistory(table string, enable bool) error {
+	if DbPolicy_isInternal(table) {
+		return fmt.Errorf("internal table(%s) can't have history", table)
+	}
+
+	_, enabled, err := db.getHistoryColumns(table)
+	if err != nil {
+		return err
+	}
+	if enabled == enable {
+		return nil
+	}
+
+	err = db.dropHistoryTriggers(table)
+	if err != nil {
+		return err
+	}
+	if !enable {
+		_, err = db.Write("UPDATE "+DbHistory_TABLE+" SET enabled=0 WHERE tbl=?", table)
+		return err
+	}
+	return db.installHistory(table)
+}
+
+// returns quoted names and types of table's columns
+func (db *Db) getHistoryTableColumns(table string) ([]string, []string, error) {
+	rows, err := db.tx.Query("SELECT name, type FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, nil, fmt.Errorf("table_info(%s) failed: %w", table, err)
+	}
+	defer rows.Close()
+
+	var names, types []string
+	for rows.Next() {
+		var name, tp string
+		err := rows.Scan(&name, &tp)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Scan() failed: %w", err)
+		}
+		names = append(names, DbUndo_quoteName(name))
+		types = append(types, tp)
+	}
+	return names, types, rows.Err()
+}
+
+// creates history table and triggers with current columns of table. Triggers must be dropped before.
+func (db *Db) installHistory(table string) error {
+	names, types, err := db.getHistoryTableColumns(table)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("table(%s) not found", table)
+	}
+
+	hist := DbUndo_quoteName(DbHistory_table(table))
+	tbl := DbUndo_quoteName(table)
+	list := strings.Join(names, ", ")
+	oldCols := "old." + strings.Join(names, ", old.")
+
+	queries := []string{
+		"CREATE TABLE IF NOT EXISTS " + DbHistory_TABLE + "(tbl TEXT PRIMARY KEY, cols TEXT, enabled INT)",
+		"CREATE TABLE IF NOT EXISTS " + DbHistory_WRITER + "(name TEXT)",
+		"INSERT INTO " + DbHistory_WRITER + "(name) SELECT NULL WHERE NOT EXISTS(SELECT 1 FROM " + DbHistory_WRITER + ")",
+		"CREATE TABLE IF NOT EXISTS " + hist + "(_h_id INTEGER PRIMARY KEY, _h_rowid INT, _h_time REAL, _h_asset TEXT, _h_op TEXT)",
+		"CREATE INDEX IF NOT EXISTS " + DbUndo_quoteName(DbHistory_table(table)+"_rowid") + " ON " + hist + "(_h_rowid, _h_time)",
+	}
+	for _, q := range queries {
+		_, err := db.Write(q)
+		if err != nil {
+			return err
+		}
+	}
+
+	//columns added into table since history was enabled last time
+	histCols, err := db.getTableColumns(DbHistory_table(table))
+	if err != nil {
+		return err
+	}
+	for i, c := range names {
+		found := false
+		for _, hc := range histCols {
+			found = found || strings.EqualFold(hc, c)
+		}
+		if !found {
+			_, err := db.Write("ALTER TABLE " + hist + " ADD COLUMN " + c + " " + types[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	writer := "(SELECT name FROM " + DbHistory_WRITER + ")"
+	queries = []string{
+		fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT ON %s BEGIN INSERT INTO %s(_h_rowid, _h_time, _h_asset, _h_op) VALUES(new.rowid, %s, %s, 'INSERT'); END",
+			DbUndo_quoteName(DbHistory_TRIGGER+"ai_"+table), tbl, hist, DbHistory_NOW, writer),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER UPDATE ON %s BEGIN INSERT INTO %s(_h_rowid, _h_time, _h_asset, _h_op, %s) VALUES(old.rowid, %s, %s, 'UPDATE', %s); END",
+			DbUndo_quoteName(DbHistory_TRIGGER+"au_"+table), tbl, hist, list, DbHistory_NOW, writer, oldCols),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER DELETE ON %s BEGIN INSERT INTO %s(_h_rowid, _h_time, _h_asset, _h_op, %s) VALUES(old.rowid, %s, %s, 'DELETE', %s); END",
+			DbUndo_quoteName(DbHistory_TRIGGER+"ad_"+table), tbl, hist, list, DbHistory_NOW, writer, oldCols),
+	}
+	for _, q := range queries {
+		_, err := db.Write(q)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Write("INSERT OR REPLACE INTO "+DbHistory_TABLE+"(tbl, cols, enabled) VALUES(?, ?, 1)", table, list)
+	db.writer_on = -1 //writer table may be new
+	return err
+}
+
+// Schema was changed, triggers of tables, whose columns are different, are created again. Nothing is written when columns are same.
+func (db *Db) rebuildHistory() error {
+	//host's statements
+	app := db.policy_app
+	db.policy_app = nil
+	defer func() { db.policy_app = app }()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	err = tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name=?", DbHistory_TABLE).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("Query(sqlite_master) failed: %w", err)
+	}
+	if n == 0 {
+		return nil //no history
+	}
+
+	type Item struct {
+		table, cols string
+	}
+	var items []Item
+	{
+		rows, err := tx.Query("SELECT tbl, cols FROM " + DbHistory_TABLE + " WHERE enabled=1")
+		if err != nil {
+			return fmt.Errorf("Query(%s) failed: %w", DbHistory_TABLE, err)
+		}
+		for rows.Next() {
+			var it Item
+			err := rows.Scan(&it.table, &it.cols)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("Scan() failed: %w", err)
+			}
+			items = append(items, it)
+		}
+		rows.Close()
+	}
+
+	for _, it := range items {
+		names, _, err := db.getHistoryTableColumns(it.table)
+		if err != nil {
+			return err
+		}
+		if strings.Join(names, ", ") == it.cols {
+			continue
+		}
+
+		err = db.dropHistoryTriggers(it.table)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			//table was dropped
+			_, err = db.Write("UPDATE "+DbHistory_TABLE+" SET enabled=0 WHERE tbl=?", it.table)
+		} else {
+			err = db.installHistory(it.table)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	db.history = nil
+	db.history_enabled = nil
+	return nil
+}
+
+// History triggers write asset name, which is set before asset's write. It's reset to NULL before commit, so other processes write NULL.
+func (db *Db) setHistoryWriter(tx *sql.Tx) error {
+	if db.writer_set && db.writer == db.policy_asset {
+		return nil
+	}
+
+	if db.writer_on < 0 {
+		n := 0
+		err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name=?", DbHistory_WRITER).Scan(&n)
+		if err != nil {
+			return fmt.Errorf("Query(sqlite_master) failed: %w", err)
+		}
+		db.writer_on = n
+	}
+	if db.writer_on == 0 {
+		return nil
+	}
+
+	defer db.hostPolicy()()
+	_, err := tx.Exec("UPDATE "+DbHistory_WRITER+" SET name=?", db.policy_asset)
+	if err != nil {
+		return fmt.Errorf("query UPDATE(%s) failed: %w", DbHistory_WRITER, err)
+	}
+	db.writer = db.policy_asset
+	db.writer_set = true
+	return nil
+}
+
+func (db *Db) resetHistoryWriter() {
+	if db.writer_set && db.tx != nil {
+		defer db.hostPolicy()()
+		_, err := db.tx.Exec("UPDATE " + DbHistory_WRITER + " SET name=NULL")
+		if err != nil {
+			fmt.Printf("query UPDATE(%s) failed: %v\n", DbHistory_WRITER, err)
+		}
+	}
+	db.writer_set = false
+}
+
+// returns cached query with _h_id, _h_time, _h_asset, _h_op and old values of row. Newest version is first.
+func (db *Db) HistoryRow(table string, rowid int64) (*DbCache, error) {
+	cols, _, err := db.getHistoryColumns(table)
+	if err != nil {
+		return nil, err
+	}
+	if cols == "" {
+		return nil, fmt.Errorf("table(%s) has no history", table)
+	}
+
+	query := "SELECT _h_id, _h_time, _h_asset, _h_op, " + cols + " FROM " + DbUndo_quoteName(DbHistory_table(table)) + " WHERE _h_rowid=? ORDER BY _h_id DESC"
+	return db.AddCache(query, _argsToArray(nil, rowid))
+}
+
+// returns cached query with rowid and values of rows, which existed at 'time'(seconds since 1970)
+func (db *Db) HistoryAsOf(table string, time float64) (*DbCache, error) {
+	cols, _, err := db.getHistoryColumns(table)
+	if err != nil {
+		return nil, err
+	}
+	if cols == "" {
+		return nil, fmt.Errorf("table(%s) has no history", table)
+	}
+
+	hist := DbUndo_quoteName(DbHistory_table(table))
+	tbl := DbUndo_quoteName(table)
+
+	//first version after 'time' has values, which were valid at 'time'. INSERT means, that row didn't exist.
+	query := "SELECT rowid, " + cols + " FROM " + tbl + " AS t WHERE NOT EXISTS(SELECT 1 FROM " + hist + " AS h WHERE h._h_rowid=t.rowid AND h._h_time > ?1)" +
+		" UNION ALL SELECT _h_rowid, " + cols + " FROM " + hist + " AS h WHERE h._h_op!='INSERT' AND h._h_id=(SELECT MIN(h2._h_id) FROM " + hist + " AS h2 WHERE h2._h_rowid=h._h_rowid AND h2._h_time > ?1)" +
+		" ORDER BY 1"
+	return db.AddCache(query, _argsToArray(nil, time))
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// returns all rows of cached query
+func testRows(t *testing.T, db *Db, cache *DbCache) [][]interface{} {
+	t.Helper()
+
+	n, err := db.GetRowCount(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]interface{}
+	for i := 0; i < int(n); i++ {
+		row, err := db.GetRow(cache, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals, err := _arrayToParams(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, vals)
+	}
+	return rows
+}
+
+func testStr(v interface{}) string {
+	b, _ := v.([]byte)
+	return string(b)
+}
+
+func TestHistory(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE t(a TEXT)")
+	err := db.SetHistory("t", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCommit(t, db)
+
+	db.policy_asset = "main"
+	testWrite(t, db, "INSERT INTO t(rowid, a) VALUES(1, 'one')")
+	testCommit(t, db)
+
+	time.Sleep(20 * time.Millisecond)
+	asOf := float64(time.Now().UnixMicro()) / 1000000
+	time.Sleep(20 * time.Millisecond)
+
+	testWrite(t, db, "UPDATE t SET a='two' WHERE rowid=1")
+	testWrite(t, db, "INSERT INTO t(rowid, a) VALUES(2, 'new')")
+	testCommit(t, db)
+
+	//newest version first: UPDATE keeps old value
+	cache, err := db.HistoryRow("t", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := testRows(t, db, cache)
+	if len(rows) != 2 {
+		t.Fatalf("%d versions", len(rows))
+	}
+	if testStr(rows[0][3]) != "UPDATE" || testStr(rows[0][4]) != "one" || testStr(rows[0][2]) != "main" {
+		t.Fatalf("wrong version: %v", rows[0])
+	}
+	if testStr(rows[1][3]) != "INSERT" {
+		t.Fatalf("wrong version: %v", rows[1])
+	}
+
+	//row 2 didn't exist, row 1 had old value
+	testWrite(t, db, "DELETE FROM t WHERE rowid=1")
+	testCommit(t, db)
+	cache, err = db.HistoryAsOf("t", asOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = testRows(t, db, cache)
+	if len(rows) != 1 || rows[0][0] != int64(1) || testStr(rows[0][1]) != "one" {
+		t.Fatalf("wrong rows as of time: %v", rows)
+	}
+}
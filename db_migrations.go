@@ -0,0 +1,190 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DbMigration_TABLE = "_sa_migrations"
+
+type DbMigration struct {
+	version int
+	path    string
+}
+
+// Reads 'folder'/NNNN_name.sql files sorted by version
+func DbMigration_list(folder string) ([]DbMigration, error) {
+	if !OsFolderExists(folder) {
+		return nil, nil
+	}
+
+	dir, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, fmt.Errorf("ReadDir(%s) failed: %w", folder, err)
+	}
+
+	var migs []DbMigration
+	for _, file := range dir {
+		if file.IsDir() || !strings.EqualFold(filepath.Ext(file.Name()), ".sql") {
+			continue
+		}
+
+		num, _, _ := strings.Cut(file.Name(), "_")
+		version, err := strconv.Atoi(num)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration(%s) must start with version number, e.g. '0001_init.sql'", file.Name())
+		}
+		migs = append(migs, DbMigration{version: version, path: folder + "/" + file.Name()})
+	}
+
+	sort.Slice(migs, func(i, j int) bool {
+		return migs[i].version < migs[j].version
+	})
+
+	for i := 1; i < len(migs); i++ {
+		if migs[i].version == migs[i-1].version {
+			return nil, fmt.Errorf("migrations(%s, %s) have same version", migs[i-1].path, migs[i].path)
+		}
+	}
+
+	return migs, nil
+}
+
+func (db *Db) getMigrationVersion(app string, asset string) (int, error) {
+	_, err := db.Write("CREATE TABLE IF NOT EXISTS " + DbMigration_TABLE + "(app TEXT, asset TEXT, version INT, PRIMARY KEY(app, asset));")
+	if err != nil {
+		return -1, err
+	}
+
+	rows, err := db.tx.Query("SELECT version FROM "+DbMigration_TABLE+" WHERE app=? AND asset=?", app, asset)
+	if err != nil {
+		return -1, fmt.Errorf("query SELECT failed: %w", err)
+	}
+	defer rows.Close()
+
+	version := 0
+	if rows.Next() {
+		err := rows.Scan(&version)
+		if err != nil {
+			return -1, fmt.Errorf("Scan() failed: %w", err)
+		}
+	}
+	return version, nil
+}
+
+func (db *Db) addPendingMigration(asset *Asset) {
+	if db.migrate_pending == nil {
+		db.migrate_pending = make(map[*Asset]bool)
+	}
+	db.migrate_pending[asset] = true
+}
+
+// runs migrations, which were postponed. Called after frame's writes were committed.
+func (db *Db) runPendingMigrations() {
+	pending := db.migrate_pending
+	db.migrate_pending = nil
+	for asset := range pending {
+		err := asset.migrate(db)
+		if err != nil {
+			fmt.Printf("migrate(%s) failed: %v\n", asset.name, err)
+		}
+	}
+}
+
+// Applies migrations, which weren't applied yet. Everything is committed or nothing.
+// Migration queries are checked by app's policy. It runs in own transaction, so writes of
+// other assets must be committed before.
+func (db *Db) Migrate(app *App, asset string, folder string) error {
+
+	migs, err := DbMigration_list(folder)
+	if err != nil || len(migs) == 0 {
+		return err
+	}
+
+	if db.explicit != nil {
+		return fmt.Errorf("db(%s) is locked by transaction of asset(%s)", db.name, db.explicit.asset.name)
+	}
+	if db.tx != nil {
+		return fmt.Errorf("db(%s) has uncommitted writes", db.name)
+	}
+
+	err = db.exec("SAVEPOINT _sa_migrate")
+	if err != nil {
+		return err
+	}
+
+	db.undo.off = true //migrations can't be undone
+	defer func() { db.undo.off = false }()
+
+	err = db.migrate(app, asset, migs)
+	if err != nil {
+		db.exec("ROLLBACK TO _sa_migrate")
+		db.exec("RELEASE _sa_migrate")
+		return err
+	}
+
+	err = db.exec("RELEASE _sa_migrate")
+	if err != nil {
+		return err
+	}
+
+	//reads must see new schema
+	return db.Commit()
+}
+
+func (db *Db) migrate(app *App, asset string, migs []DbMigration) error {
+	version, err := db.getMigrationVersion(app.name, asset)
+	if err != nil {
+		return err
+	}
+
+	last := migs[len(migs)-1].version
+	if version > last {
+		return fmt.Errorf("db(%s) has schema version %d, but app(%s/%s) knows only %d", db.name, version, app.name, asset, last)
+	}
+
+	for _, m := range migs {
+		if m.version <= version {
+			continue //already applied
+		}
+
+		query, err := os.ReadFile(m.path)
+		if err != nil {
+			return fmt.Errorf("ReadFile(%s) failed: %w", m.path, err)
+		}
+
+		db.SetPolicy(app, asset)
+		_, err = db.Write(string(query))
+		db.SetPolicy(nil, "")
+		if err != nil {
+			return fmt.Errorf("migration(%s) failed: %w", m.path, err)
+		}
+
+		_, err = db.Write("INSERT OR REPLACE INTO "+DbMigration_TABLE+"(app, asset, version) VALUES(?, ?, ?);", app.name, asset, m.version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
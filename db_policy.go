@@ -0,0 +1,383 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// pragmas, which only read and are allowed when policy has no Pragmas
+var DbPolicy_READ_PRAGMAS = []string{"table_info", "table_xinfo", "table_list", "index_list", "index_info", "index_xinfo", "foreign_key_list",
+	"data_version", "user_version", "schema_version", "page_count", "page_size", "freelist_count", "integrity_check", "quick_check",
+	"compile_options", "function_list", "collation_list", "pragma_list", "database_list"}
+
+// What app's queries may do. It's part of app.json: {"Sql": {...}}
+type DbPolicy struct {
+	Tables     []string //allowed tables, 'name*' is prefix. Empty = all
+	Statements []string //SELECT, INSERT, UPDATE, DELETE, CREATE, DROP, ALTER. Empty = all
+	Attach     bool     //ATTACH/DETACH
+	Pragmas    []string //pragmas, which can be read or set. Empty = DbPolicy_READ_PRAGMAS can be read
+}
+
+// Internal tables are written by host's triggers, which have internal names too. App can only read them, see checkInternal().
+func DbPolicy_isInternal(table string) bool {
+	table = strings.ToLower(table)
+	return strings.HasPrefix(table, "sqlite_") || strings.HasPrefix(table, "_sa_") || strings.HasPrefix(table, DbHistory_PREFIX)
+}
+
+func DbPolicy_find(list []string, name string) bool {
+	for _, it := range list {
+		prefix, isPrefix := strings.CutSuffix(it, "*")
+		if strings.EqualFold(it, name) || (isPrefix && strings.HasPrefix(strings.ToLower(name), strings.ToLower(prefix))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *DbPolicy) checkTable(table string) string {
+	if len(p.Tables) == 0 || len(table) == 0 || DbPolicy_isInternal(table) || DbPolicy_find(p.Tables, table) {
+		return ""
+	}
+	return fmt.Sprintf("table(%s) is not allowed", table)
+}
+
+func (p *DbPolicy) checkStatement(tp string, table string) string {
+	if DbPolicy_isInternal(table) {
+		if tp == "CREATE" || tp == "DROP" || tp == "ALTER" {
+			return fmt.Sprintf("%s of internal table(%s) is not allowed", tp, table)
+		}
+		return ""
+	}
+
+	if len(p.Statements) > 0 && !DbPolicy_find(p.Statements, tp) {
+		return fmt.Sprintf("statement %s is not allowed", tp)
+	}
+	return p.checkTable(table)
+}
+
+func (p *DbPolicy) checkPragma(name string, value string) string {
+	name = strings.ToLower(name)
+	if name == "writable_schema" {
+		return "pragma writable_schema is not allowed"
+	}
+
+	if len(p.Pragmas) > 0 {
+		if DbPolicy_find(p.Pragmas, name) {
+			return ""
+		}
+	} else if len(value) == 0 && DbPolicy_find(DbPolicy_READ_PRAGMAS, name) {
+		return ""
+	}
+
+	if len(value) > 0 {
+		return fmt.Sprintf("pragma %s=%s is not allowed", name, value)
+	}
+	return fmt.Sprintf("pragma %s is not allowed", name)
+}
+
+// SQLite's tables are changed by DDL(DROP TABLE cleans sqlite_sequence and sqlite_stat1), schema itself is read-only
+func DbPolicy_isSystem(table string) bool {
+	return strings.HasPrefix(strings.ToLower(table), "sqlite_")
+}
+
+// app can read internal tables of tables, which it can read
+func (p *DbPolicy) checkInternalRead(table string) string {
+	if DbPolicy_isSystem(table) {
+		return ""
+	}
+
+	lower := strings.ToLower(table)
+	if base, found := strings.CutPrefix(lower, DbHistory_PREFIX); found {
+		return p.checkTable(base)
+	}
+	if base, found := strings.CutPrefix(lower, DbSearch_PREFIX); found {
+		//FTS5 shadow tables
+		for _, suffix := range DbSearch_SHADOWS {
+			if b, ok := strings.CutSuffix(base, suffix); ok && p.checkTable(b) == "" {
+				return ""
+			}
+		}
+		return p.checkTable(base)
+	}
+	return fmt.Sprintf("internal table(%s) can't be read by app", table)
+}
+
+// Internal tables and names outside of host's triggers. FTS5 writes its shadow tables by own statements, defensive mode(DbAuth_defensive()) keeps app's statements away from them.
+func (p *DbPolicy) checkInternal(op int, arg1, arg2 string) string {
+	switch op {
+	case sqlite3.SQLITE_READ:
+		if DbPolicy_isInternal(arg1) {
+			return p.checkInternalRead(arg1)
+		}
+
+	case sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE:
+		if DbPolicy_isInternal(arg1) && !DbPolicy_isSystem(arg1) && !DbSearch_isShadow(arg1) {
+			return fmt.Sprintf("internal table(%s) can be changed only by host", arg1)
+		}
+
+	case sqlite3.SQLITE_CREATE_INDEX, sqlite3.SQLITE_CREATE_TEMP_INDEX, sqlite3.SQLITE_CREATE_TRIGGER, sqlite3.SQLITE_CREATE_TEMP_TRIGGER,
+		sqlite3.SQLITE_DROP_INDEX, sqlite3.SQLITE_DROP_TEMP_INDEX, sqlite3.SQLITE_DROP_TRIGGER, sqlite3.SQLITE_DROP_TEMP_TRIGGER:
+		if DbPolicy_isInternal(arg1) {
+			return fmt.Sprintf("internal name(%s) belongs to host", arg1)
+		}
+	}
+	return ""
+}
+
+// returns why action is denied or empty string. Actions of host's triggers(internal 'trigger') are allowed.
+func (p *DbPolicy) Check(op int, arg1, arg2, trigger string) string {
+	if DbPolicy_isInternal(trigger) {
+		return ""
+	}
+	if str := p.checkInternal(op, arg1, arg2); str != "" {
+		return str
+	}
+
+	switch op {
+	case sqlite3.SQLITE_SELECT:
+		return p.checkStatement("SELECT", "")
+	case sqlite3.SQLITE_READ:
+		return p.checkTable(arg1)
+
+	case sqlite3.SQLITE_INSERT:
+		return p.checkStatement("INSERT", arg1)
+	case sqlite3.SQLITE_UPDATE:
+		return p.checkStatement("UPDATE", arg1)
+	case sqlite3.SQLITE_DELETE:
+		return p.checkStatement("DELETE", arg1)
+
+	case sqlite3.SQLITE_CREATE_TABLE, sqlite3.SQLITE_CREATE_TEMP_TABLE, sqlite3.SQLITE_CREATE_VIEW, sqlite3.SQLITE_CREATE_TEMP_VIEW, sqlite3.SQLITE_CREATE_VTABLE:
+		return p.checkStatement("CREATE", arg1)
+	case sqlite3.SQLITE_CREATE_INDEX, sqlite3.SQLITE_CREATE_TEMP_INDEX, sqlite3.SQLITE_CREATE_TRIGGER, sqlite3.SQLITE_CREATE_TEMP_TRIGGER:
+		return p.checkStatement("CREATE", arg2)
+
+	case sqlite3.SQLITE_DROP_TABLE, sqlite3.SQLITE_DROP_TEMP_TABLE, sqlite3.SQLITE_DROP_VIEW, sqlite3.SQLITE_DROP_TEMP_VIEW, sqlite3.SQLITE_DROP_VTABLE:
+		return p.checkStatement("DROP", arg1)
+	case sqlite3.SQLITE_DROP_INDEX, sqlite3.SQLITE_DROP_TEMP_INDEX, sqlite3.SQLITE_DROP_TRIGGER, sqlite3.SQLITE_DROP_TEMP_TRIGGER:
+		return p.checkStatement("DROP", arg2)
+
+	case sqlite3.SQLITE_ALTER_TABLE:
+		return p.checkStatement("ALTER", arg2)
+	case sqlite3.SQLITE_REINDEX, sqlite3.SQLITE_ANALYZE:
+		return p.checkStatement("ALTER", arg1)
+
+	case sqlite3.SQLITE_PRAGMA:
+		return p.checkPragma(arg1, arg2)
+
+	case sqlite3.SQLITE_ATTACH, sqlite3.SQLITE_DETACH:
+		if !p.Attach {
+			return "ATTACH/DETACH is not allowed"
+		}
+
+	case sqlite3.SQLITE_TRANSACTION, sqlite3.SQLITE_SAVEPOINT:
+		return "transaction must be started by sql_begin()" //Db.tx is controlled by host
+	}
+	return ""
+}
+
+// Table referenced by app's query
+type DbPolicyRef struct {
+	name  string
+	write bool //INSERT/UPDATE/DELETE/DROP/ALTER target
+}
+
+// keywords, after which table name is written
+var DbPolicy_WRITE_KEYWORDS = []string{"INTO", "UPDATE", "TABLE", "TRIGGER", "INDEX", "VIEW", "EXISTS"}
+
+func DbPolicy_isWriteTarget(prev []string) bool {
+	n := len(prev)
+	if n == 0 {
+		return false
+	}
+	if DbPolicy_find(DbPolicy_WRITE_KEYWORDS, prev[n-1]) {
+		return true
+	}
+	if strings.EqualFold(prev[n-1], "FROM") && n >= 2 && strings.EqualFold(prev[n-2], "DELETE") {
+		return true
+	}
+	//UPDATE OR REPLACE t
+	return n >= 3 && strings.EqualFold(prev[n-2], "OR") && strings.EqualFold(prev[n-3], "UPDATE")
+}
+
+// Returns internal tables(DbPolicy_isInternal) named in query and whether query is CREATE. Authorizer denies
+// access, scan only tells app which name caused it. Literals and comments are skipped.
+func DbPolicy_internalRefs(query string) ([]DbPolicyRef, bool) {
+	var refs []DbPolicyRef
+	var prev []string //words and symbols before current name
+	create := false
+
+	i := 0
+	for i < len(query) {
+		ch := query[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+
+		case ch == '-' && i+1 < len(query) && query[i+1] == '-':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+
+		case ch == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += 2 + end + 2
+			}
+
+		case ch == '\'':
+			i++
+			for i < len(query) {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			prev = append(prev, "'")
+
+		default:
+			//name
+			var name string
+			start := i
+			switch {
+			case ch == '"' || ch == '`' || ch == '[':
+				end := byte(ch)
+				if ch == '[' {
+					end = ']'
+				}
+				i++
+				for i < len(query) {
+					if query[i] == end {
+						if end != ']' && i+1 < len(query) && query[i+1] == end {
+							name += string(end)
+							i += 2
+							continue
+						}
+						break
+					}
+					name += string(query[i])
+					i++
+				}
+				i++
+			case ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch >= 0x80:
+				for i < len(query) {
+					c := query[i]
+					if !(c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80) {
+						break
+					}
+					i++
+				}
+				name = query[start:i]
+			default:
+				i++
+				prev = append(prev, string(ch))
+				continue
+			}
+
+			if len(prev) == 0 && strings.EqualFold(name, "CREATE") {
+				create = true
+			}
+
+			//schema.table
+			ctx := prev
+			if len(prev) >= 2 && prev[len(prev)-1] == "." {
+				ctx = prev[:len(prev)-2]
+			}
+			if DbPolicy_isInternal(name) {
+				write := DbPolicy_isWriteTarget(ctx)
+				j := i
+				for j < len(query) && (query[j] == ' ' || query[j] == '\t' || query[j] == '\n' || query[j] == '\r') {
+					j++
+				}
+				isFunc := !write && j < len(query) && query[j] == '(' //sqlite_version()
+				if !isFunc {
+					refs = append(refs, DbPolicyRef{name: name, write: write})
+				}
+			}
+			prev = append(prev, name)
+		}
+	}
+	return refs, create
+}
+
+// returns why authorizer denied app's query or nil
+func (db *Db) checkInternalRefs(query string) error {
+	if db.policy_app == nil {
+		return nil
+	}
+
+	refs, create := DbPolicy_internalRefs(query)
+	for _, r := range refs {
+		if (create || r.write) && !DbPolicy_isSystem(r.name) {
+			return fmt.Errorf("internal table(%s) can be changed only by host", r.name)
+		}
+		if str := db.policy_app.policy.checkInternalRead(r.name); str != "" {
+			return fmt.Errorf("table(%s): %s", r.name, str)
+		}
+	}
+	return nil
+}
+
+// adds reason into error of query, which was denied
+func (db *Db) deniedErr(query string, err error) error {
+	if refErr := db.checkInternalRefs(query); refErr != nil {
+		return fmt.Errorf("%w: %v", err, refErr)
+	}
+	return err
+}
+
+// Queries after this call are checked by app's policy(nil = host's queries). Denied actions are added into app's log.
+func (db *Db) SetPolicy(app *App, asset string) {
+	if app == nil && db.policy_app != nil {
+		for _, str := range db.policy_denied {
+			db.policy_app.AddLog(fmt.Sprintf("db(%s) denied: %s", db.name, str))
+		}
+	}
+	db.policy_app = app
+	db.policy_asset = asset
+	db.policy_denied = nil
+}
+
+// host's own statements inside app's call aren't checked by its policy. Returns function, which sets policy back.
+func (db *Db) hostPolicy() func() {
+	app := db.policy_app
+	db.policy_app = nil
+	return func() { db.policy_app = app }
+}
+
+func (db *Db) checkPolicy(op int, arg1, arg2, trigger string) bool {
+	if db.policy_app == nil {
+		return true
+	}
+
+	str := db.policy_app.policy.Check(op, arg1, arg2, trigger)
+	if str != "" {
+		db.policy_denied = append(db.policy_denied, str)
+		return false
+	}
+	return true
+}
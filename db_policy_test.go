@@ -0,0 +1,144 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestPolicyInternalRefs(t *testing.T) {
+	tests := []struct {
+		query  string
+		refs   int
+		write  bool
+		create bool
+	}{
+		{"SELECT * FROM notes", 0, false, false},
+		{"SELECT '_sa_x', sqlite_version() -- _sa_y\n", 0, false, false},
+		{"SELECT * FROM _history_notes", 1, false, false},
+		{"SELECT * FROM main.\"_sa_sync_log\"", 1, false, false},
+		{"INSERT INTO [_sa_changes] VALUES(1)", 1, true, false},
+		{"UPDATE OR REPLACE _history_notes SET a=1", 1, true, false},
+		{"DELETE FROM main._sa_migrations", 1, true, false},
+		{"DROP TABLE IF EXISTS _sa_search", 1, true, false},
+		{"CREATE VIEW v AS SELECT * FROM _history_notes", 1, false, true},
+	}
+	for _, tt := range tests {
+		refs, create := DbPolicy_internalRefs(tt.query)
+		if len(refs) != tt.refs || create != tt.create || (len(refs) > 0 && refs[0].write != tt.write) {
+			t.Errorf("query(%s): refs %v, create %v", tt.query, refs, create)
+		}
+	}
+}
+
+func TestPolicyAuthorizer(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE notes(title TEXT)")
+	testWrite(t, db, "CREATE TABLE secret(pass TEXT)")
+	testWrite(t, db, "CREATE TABLE _history_notes(title TEXT)")
+	testWrite(t, db, "CREATE TABLE _history_secret(pass TEXT)")
+	testWrite(t, db, "INSERT INTO secret VALUES('1234')")
+	testCommit(t, db)
+
+	//host's query is cached before app reads it
+	hostCache, err := db.AddCache("SELECT pass FROM secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &App{name: "notes", policy: DbPolicy{Tables: []string{"notes"}, Statements: []string{"SELECT", "INSERT"}}}
+	db.SetPolicy(app, "main")
+	defer db.SetPolicy(nil, "")
+
+	allowed := []string{
+		"SELECT * FROM notes",
+		"SELECT * FROM _history_notes",
+		"SELECT name FROM sqlite_master",
+	}
+	for _, q := range allowed {
+		_, err := db.AddCache(q, nil)
+		if err != nil {
+			t.Errorf("query(%s) was denied: %v", q, err)
+		}
+	}
+
+	denied := []string{
+		"SELECT * FROM secret",
+		"SELECT * FROM _history_secret",
+		"SELECT * FROM _sa_changes",
+	}
+	for _, q := range denied {
+		_, err := db.AddCache(q, nil)
+		if err == nil {
+			t.Errorf("query(%s) was allowed", q)
+		}
+	}
+
+	deniedWrites := []string{
+		"INSERT INTO secret VALUES('x')",
+		"DELETE FROM notes",
+		"INSERT INTO _history_notes VALUES('x')",
+		"CREATE TRIGGER t AFTER INSERT ON notes BEGIN DELETE FROM _history_notes; END",
+	}
+	for _, q := range deniedWrites {
+		_, err := db.Write(q)
+		if err == nil {
+			t.Errorf("query(%s) was allowed", q)
+		}
+	}
+
+	_, err = db.GetCache(hostCache.query, hostCache.query_hash)
+	if err == nil {
+		t.Fatal("app read cache of host's query")
+	}
+}
+
+func TestPolicyHostTriggers(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE notes(title TEXT)")
+	testWrite(t, db, "CREATE TABLE tags(name TEXT)")
+	testWrite(t, db, "CREATE TABLE _history_notes(title TEXT)")
+	testWrite(t, db, "CREATE TRIGGER _sa_test_ai AFTER INSERT ON notes BEGIN INSERT INTO _history_notes VALUES(new.title); END")
+	testWrite(t, db, "CREATE TRIGGER tags_ai AFTER INSERT ON tags BEGIN INSERT INTO _history_notes VALUES(new.name); END")
+	testCommit(t, db)
+
+	db.SetPolicy(&App{name: "notes"}, "main")
+	defer db.SetPolicy(nil, "")
+
+	//host's trigger writes internal table
+	_, err := db.Write("INSERT INTO notes VALUES('a')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testCount(t, db, "SELECT COUNT(*) FROM _history_notes") != 1 {
+		t.Fatal("host's trigger didn't write")
+	}
+
+	denied := []string{
+		"INSERT INTO tags VALUES('b')", //other trigger
+		"DROP TRIGGER _sa_test_ai",
+		"CREATE TRIGGER _sa_x AFTER INSERT ON notes BEGIN DELETE FROM _history_notes; END",
+	}
+	for _, q := range denied {
+		_, err := db.Write(q)
+		if err == nil {
+			t.Errorf("query(%s) was allowed", q)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const DbQueryLog_MAX = 1000            //items in ring buffer
+const DbQueryLog_SLOW = 50             //ms, slower queries get EXPLAIN QUERY PLAN
+const DbQueryLog_SHOW = 20             //items in overlay
+const DbQueryLog_SHOW_QUERY_CHARS = 80 //longer queries are cut in overlay
+
+type DbQueryLogItem struct {
+	id int64 //0 = empty slot
+
+	db    string
+	asset string //empty = host
+	query string
+
+	dt    time.Duration //prepare + all reads of cached query
+	rows  int64         //read or affected rows(-1 = unknown)
+	hit   bool          //AddCache() found query in cache
+	write bool
+
+	plan string //filled for slow queries
+}
+
+// Ring buffer of executed queries
+type DbQueryLog struct {
+	items   [DbQueryLog_MAX]DbQueryLogItem
+	last_id int64
+}
+
+func (log *DbQueryLog) Add(item DbQueryLogItem) int64 {
+	log.last_id++
+	item.id = log.last_id
+	log.items[item.id%DbQueryLog_MAX] = item
+	return item.id
+}
+
+// returns nil when item was overwritten by newer items
+func (log *DbQueryLog) Find(id int64) *DbQueryLogItem {
+	if id <= 0 {
+		return nil
+	}
+	item := &log.items[id%DbQueryLog_MAX]
+	if item.id != id {
+		return nil
+	}
+	return item
+}
+
+// returns newest items first
+func (log *DbQueryLog) GetLast(max int) []*DbQueryLogItem {
+	var items []*DbQueryLogItem
+	for id := log.last_id; id > 0 && id > log.last_id-DbQueryLog_MAX && len(items) < max; id-- {
+		items = append(items, &log.items[id%DbQueryLog_MAX])
+	}
+	return items
+}
+
+func (log *DbQueryLog) GetText() string {
+	var text strings.Builder
+
+	var sum time.Duration
+	nHits := 0
+	nItems := 0
+	for i := range log.items {
+		if log.items[i].id > 0 {
+			sum += log.items[i].dt
+			nHits += OsTrn(log.items[i].hit, 1, 0)
+			nItems++
+		}
+	}
+	text.WriteString(fmt.Sprintf("Queries(last: %d, time: %.1fms, cache hits: %d)\n", nItems, float64(sum.Microseconds())/1000, nHits))
+
+	for _, it := range log.GetLast(DbQueryLog_SHOW) {
+		query := strings.Join(strings.Fields(it.query), " ")
+		if len(query) > DbQueryLog_SHOW_QUERY_CHARS {
+			query = query[:DbQueryLog_SHOW_QUERY_CHARS] + "..."
+		}
+
+		tp := "miss"
+		if it.write {
+			tp = "write"
+		} else if it.hit {
+			tp = "hit"
+		}
+		rows := "?"
+		if it.rows >= 0 {
+			rows = fmt.Sprintf("%d", it.rows)
+		}
+		asset := it.asset
+		if asset == "" {
+			asset = "host"
+		}
+
+		text.WriteString(fmt.Sprintf("%.2fms %s rows: %s %s/%s: %s\n", float64(it.dt.Microseconds())/1000, tp, rows, it.db, asset, query))
+		for _, p := range strings.Split(it.plan, "\n") {
+			if p != "" {
+				text.WriteString("    " + p + "\n")
+			}
+		}
+	}
+	return strings.TrimSuffix(text.String(), "\n")
+}
+
+// returns EXPLAIN QUERY PLAN as lines with indentation
+func (db *Db) explainQuery(query string, params []interface{}) string {
+	//EXPLAIN is host's statement
+	app := db.policy_app
+	db.policy_app = nil
+	defer func() { db.policy_app = app }()
+
+	query = "EXPLAIN QUERY PLAN " + DbCache_trimQuery(query)
+	var rows *sql.Rows
+	var err error
+	if db.tx != nil {
+		rows, err = db.tx.Query(query, params...) //sees uncommitted schema
+	} else {
+		rows, err = db.db.Query(query, params...)
+	}
+	if err != nil {
+		return fmt.Sprintf("EXPLAIN failed: %v", err)
+	}
+	defer rows.Close()
+
+	depth := make(map[int64]int)
+	var plan strings.Builder
+	for rows.Next() {
+		var id, parent, notused int64
+		var detail string
+		err := rows.Scan(&id, &parent, &notused, &detail)
+		if err != nil {
+			return fmt.Sprintf("Scan() failed: %v", err)
+		}
+		depth[id] = depth[parent] + 1
+		plan.WriteString(strings.Repeat("  ", depth[id]-1) + detail + "\n")
+	}
+	return plan.String()
+}
+
+func (db *Db) addQueryLog(query string, params []interface{}, dt time.Duration, rows int64, hit bool, write bool) int64 {
+	item := DbQueryLogItem{db: db.name, asset: db.policy_asset, query: query, dt: dt, rows: rows, hit: hit, write: write}
+	if dt >= DbQueryLog_SLOW*time.Millisecond {
+		item.plan = db.explainQuery(query, params)
+	}
+	return db.root.querylog.Add(item)
+}
+
+// adds reading time of cached query into its item
+func (db *Db) updateQueryLog(cache *DbCache, dt time.Duration, rows int64) {
+	item := db.root.querylog.Find(cache.log_id)
+	if item == nil {
+		return
+	}
+
+	slow := item.dt >= DbQueryLog_SLOW*time.Millisecond
+	item.dt += dt
+	if rows > item.rows {
+		item.rows = rows
+	}
+	if !slow && item.dt >= DbQueryLog_SLOW*time.Millisecond {
+		item.plan = db.explainQuery(cache.query, cache.params)
+	}
+}
+
+func (db *Db) GetRow(cache *DbCache, row_i int) ([]byte, error) {
+	st := time.Now()
+	fill := cache.findWindow(row_i) == nil
+	if fill && cache.async && row_i >= 0 && (cache.row_count < 0 || int64(row_i) < cache.row_count) {
+		db.readWindowAsync(cache, DbCache_windowStart(row_i))
+		return nil, nil
+	}
+	row, err := cache.GetRow(row_i)
+	if fill {
+		db.checkCacheBudget(cache)
+	}
+
+	rows := cache.row_count
+	if rows < 0 && row != nil {
+		rows = int64(row_i + 1) //at least
+	}
+	db.updateQueryLog(cache, time.Since(st), rows)
+	return row, err
+}
+
+func (db *Db) GetRowCount(cache *DbCache) (int64, error) {
+	if cache.async && cache.row_count < 0 && db.findAsync(cache.query_hash) != nil {
+		return -1, nil //worker is counting it
+	}
+
+	st := time.Now()
+	n, err := cache.GetRowCount(db.db)
+	db.updateQueryLog(cache, time.Since(st), n)
+	return n, err
+}
+
+func (db *Db) GetColumns(cache *DbCache) ([]byte, error) {
+	st := time.Now()
+	cols, err := cache.GetColumns()
+	db.updateQueryLog(cache, time.Since(st), cache.row_count)
+	return cols, err
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueryLogItems(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE t(a INTEGER)")
+	testWrite(t, db, "INSERT INTO t VALUES(1), (2)")
+	testCommit(t, db)
+
+	for i := 0; i < 2; i++ {
+		_, err := db.AddCache("SELECT a FROM t", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items := db.root.querylog.GetLast(3)
+	if len(items) != 3 {
+		t.Fatalf("%d items", len(items))
+	}
+	if !items[0].hit || items[1].hit || items[1].write {
+		t.Fatal("second read isn't cache hit")
+	}
+	if !items[2].write || items[2].rows != 2 || items[2].db != "test" {
+		t.Fatalf("wrong write item: %+v", *items[2])
+	}
+
+	//slow query gets plan
+	plan := db.explainQuery("SELECT a FROM t WHERE a=?", []interface{}{1})
+	if !strings.Contains(plan, "SCAN") {
+		t.Fatalf("wrong plan: %s", plan)
+	}
+}
+
+func TestQueryLogRing(t *testing.T) {
+	var log DbQueryLog
+
+	first := log.Add(DbQueryLogItem{query: "first"})
+	for i := 0; i < DbQueryLog_MAX; i++ {
+		log.Add(DbQueryLogItem{query: "next"})
+	}
+
+	if log.Find(first) != nil {
+		t.Fatal("overwritten item is found")
+	}
+	if it := log.Find(log.last_id); it == nil || it.query != "next" {
+		t.Fatal("last item isn't found")
+	}
+	if n := len(log.GetLast(DbQueryLog_MAX + 10)); n != DbQueryLog_MAX {
+		t.Fatalf("%d items", n)
+	}
+}
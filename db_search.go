@@ -0,0 +1,216 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const DbSearch_TABLE = "_sa_search" //searchable tables and their columns
+const DbSearch_PREFIX = "_sa_fts_"  //FTS5 tables and triggers(skipped by sync, undo and policy)
+const DbSearch_MARK_START = "["     //highlight in snippets
+const DbSearch_MARK_END = "]"
+const DbSearch_SNIPPET_TOKENS = 16
+
+// tables, which FTS5 creates for every index
+var DbSearch_SHADOWS = []string{"_data", "_idx", "_content", "_docsize", "_config"}
+
+func DbSearch_isShadow(table string) bool {
+	lower := strings.ToLower(table)
+	if !strings.HasPrefix(lower, DbSearch_PREFIX) {
+		return false
+	}
+	for _, suffix := range DbSearch_SHADOWS {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func DbSearch_ftsName(table string) string {
+	return DbSearch_PREFIX + table
+}
+
+// every word is searched as phrase, last one as prefix, so user's input can't break MATCH syntax
+func DbSearch_matchQuery(query string) string {
+	words := strings.Fields(query)
+	for i, w := range words {
+		words[i] = "\"" + strings.ReplaceAll(w, "\"", "\"\"") + "\""
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// returns columns of searchable table, empty = not searchable
+func (db *Db) getSearchColumns(table string) (string, error) {
+	cols, found := db.search[table]
+	if found {
+		return cols, nil
+	}
+	defer db.hostPolicy()()
+
+	tx, err := db.Begin() //sees uncommitted schema
+	if err != nil {
+		return "", err
+	}
+
+	n := 0
+	err = tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name=? OR name=?", DbSearch_TABLE, DbSearch_ftsName(table)).Scan(&n)
+	if err != nil {
+		return "", fmt.Errorf("Query(sqlite_master) failed: %w", err)
+	}
+	if n == 2 {
+		err = tx.QueryRow("SELECT cols FROM "+DbSearch_TABLE+" WHERE tbl=?", table).Scan(&cols)
+		if err != nil && err != sql.ErrNoRows {
+			return "", fmt.Errorf("Query(%s) failed: %w", DbSearch_TABLE, err)
+		}
+	}
+
+	if db.search == nil {
+		db.search = make(map[string]string)
+	}
+	db.search[table] = cols
+	return cols, nil
+}
+
+func (db *Db) dropSearch(table string) error {
+	fts := DbSearch_ftsName(table)
+	for _, tp := range []string{"ai", "ad", "au"} {
+		_, err := db.Write("DROP TRIGGER IF EXISTS " + DbUndo_quoteName(DbSearch_PREFIX+tp+"_"+table))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := db.Write("DROP TABLE IF EXISTS " + DbUndo_quoteName(fts))
+	return err
+}
+
+// Creates FTS5 table, which indexes 'columns' of 'table' and triggers, which keep it updated
+func (db *Db) SetSearchable(table string, columns []string) error {
+	if DbPolicy_isInternal(table) {
+		return fmt.Errorf("internal table(%s) can't be searchable", table)
+	}
+
+	//apps usually call it every frame
+	var quoted []string
+	for _, c := range columns {
+		quoted = append(quoted, DbUndo_quoteName(c))
+	}
+	if cols, found := db.search[table]; found && cols == strings.Join(quoted, ", ") {
+		return nil
+	}
+
+	_, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	exist, err := db.getTableColumns(table)
+	if err != nil {
+		return err
+	}
+	var cols []string
+	for _, c := range quoted {
+		found := ""
+		for _, e := range exist {
+			if strings.EqualFold(e, c) {
+				found = e
+			}
+		}
+		if found == "" {
+			return fmt.Errorf("table(%s) doesn't have column(%s)", table, c)
+		}
+		cols = append(cols, found)
+	}
+
+	list := strings.Join(cols, ", ")
+	old, err := db.getSearchColumns(table)
+	if err != nil {
+		return err
+	}
+	if old == list {
+		return nil //already indexed
+	}
+
+	_, err = db.Write("CREATE TABLE IF NOT EXISTS " + DbSearch_TABLE + "(tbl TEXT PRIMARY KEY, cols TEXT);")
+	if err != nil {
+		return err
+	}
+
+	err = db.dropSearch(table)
+	if err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		_, err = db.Write("DELETE FROM "+DbSearch_TABLE+" WHERE tbl=?", table)
+		db.search[table] = ""
+		return err
+	}
+
+	fts := DbUndo_quoteName(DbSearch_ftsName(table))
+	tbl := DbUndo_quoteName(table)
+	newCols := "new." + strings.Join(cols, ", new.")
+	oldCols := "old." + strings.Join(cols, ", old.")
+
+	queries := []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, content=%s)", fts, list, DbSync_quoteLiteral(table)),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT ON %s BEGIN INSERT INTO %s(rowid, %s) VALUES(new.rowid, %s); END",
+			DbUndo_quoteName(DbSearch_PREFIX+"ai_"+table), tbl, fts, list, newCols),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER DELETE ON %s BEGIN INSERT INTO %s(%s, rowid, %s) VALUES('delete', old.rowid, %s); END",
+			DbUndo_quoteName(DbSearch_PREFIX+"ad_"+table), tbl, fts, fts, list, oldCols),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER UPDATE ON %s BEGIN INSERT INTO %s(%s, rowid, %s) VALUES('delete', old.rowid, %s); INSERT INTO %s(rowid, %s) VALUES(new.rowid, %s); END",
+			DbUndo_quoteName(DbSearch_PREFIX+"au_"+table), tbl, fts, fts, list, oldCols, fts, list, newCols),
+		fmt.Sprintf("INSERT INTO %s(%s) VALUES('rebuild')", fts, fts), //index existing rows
+	}
+	for _, q := range queries {
+		_, err := db.Write(q)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Write("INSERT OR REPLACE INTO "+DbSearch_TABLE+"(tbl, cols) VALUES(?, ?)", table, list)
+	if err != nil {
+		return err
+	}
+	db.search[table] = list
+	return nil
+}
+
+// returns cached query with rowid, rank(lower is better) and snippet per row
+func (db *Db) Search(table string, query string, max int) (*DbCache, error) {
+	cols, err := db.getSearchColumns(table)
+	if err != nil {
+		return nil, err
+	}
+	if cols == "" {
+		return nil, fmt.Errorf("table(%s) isn't searchable", table)
+	}
+
+	fts := DbUndo_quoteName(DbSearch_ftsName(table))
+	q := fmt.Sprintf("SELECT rowid, rank, snippet(%s, -1, %s, %s, '...', %d) FROM %s WHERE %s MATCH ? ORDER BY rank LIMIT ?",
+		fts, DbSync_quoteLiteral(DbSearch_MARK_START), DbSync_quoteLiteral(DbSearch_MARK_END), DbSearch_SNIPPET_TOKENS, fts, fts)
+
+	params := _stringToParams(nil, DbSearch_matchQuery(query))
+	params = _argsToArray(params, max)
+	return db.AddCache(q, params)
+}
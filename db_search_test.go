@@ -0,0 +1,99 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// returns rowids and snippets of search results
+func testSearch(t *testing.T, db *Db, query string) ([]int64, []string) {
+	t.Helper()
+
+	cache, err := db.Search("notes", query, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := db.GetRowCount(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rowids []int64
+	var snippets []string
+	for i := 0; i < int(n); i++ {
+		row, err := db.GetRow(cache, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals, err := _arrayToParams(row)
+		if err != nil || len(vals) != 3 {
+			t.Fatalf("row %d: %v %v", i, vals, err)
+		}
+		rowids = append(rowids, vals[0].(int64))
+		snippets = append(snippets, string(vals[2].([]byte)))
+	}
+	return rowids, snippets
+}
+
+func TestSearch(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE notes(title TEXT, body TEXT)")
+	testWrite(t, db, "INSERT INTO notes(rowid, title, body) VALUES(1, 'Shopping', 'apples and pears'), (2, 'Work', 'finish application'), (3, 'Trip', 'pack bags')")
+	err := db.SetSearchable("notes", []string{"title", "body"})
+	if err != nil && strings.Contains(err.Error(), "no such module") {
+		t.Skip("build without 'sqlite_fts5' tag")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCommit(t, db)
+
+	//existing rows are indexed, last word is prefix
+	rowids, snippets := testSearch(t, db, "app")
+	if len(rowids) != 2 {
+		t.Fatalf("wrong results: %v", rowids)
+	}
+	for _, s := range snippets {
+		if !strings.Contains(s, DbSearch_MARK_START) {
+			t.Fatalf("snippet(%s) isn't highlighted", s)
+		}
+	}
+
+	//triggers keep index updated
+	testWrite(t, db, "INSERT INTO notes(rowid, title, body) VALUES(4, 'Garden', 'apple tree')")
+	testWrite(t, db, "DELETE FROM notes WHERE rowid=2")
+	testWrite(t, db, "UPDATE notes SET body='pack apps' WHERE rowid=3")
+	testCommit(t, db)
+
+	rowids, _ = testSearch(t, db, "app")
+	found := make(map[int64]bool)
+	for _, id := range rowids {
+		found[id] = true
+	}
+	if len(rowids) != 3 || !found[1] || !found[3] || !found[4] {
+		t.Fatalf("wrong results after writes: %v", rowids)
+	}
+
+	//user's input doesn't break MATCH syntax
+	rowids, _ = testSearch(t, db, "\"pears OR")
+	if len(rowids) != 0 {
+		t.Fatalf("wrong results: %v", rowids)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"database/sql"
 	"fmt"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -39,10 +40,14 @@ func NewDbSettings(root *Root) (*DbSettings, error) {
 		return nil, fmt.Errorf("AddDb() failed: %w", err)
 	}
 
-	_, err = sts.db.Write("CREATE TABLE IF NOT EXISTS settings(id INT, asset TEXT, content BLOB);")
+	_, err = sts.db.Write("CREATE TABLE IF NOT EXISTS settings(id INT, asset TEXT, content BLOB, db TEXT);")
 	if err != nil {
 		return nil, fmt.Errorf("Write() failed: %w", err)
 	}
+	err = sts.addDbColumn()
+	if err != nil {
+		return nil, err
+	}
 	sts.db.Commit()
 
 	{
@@ -65,6 +70,24 @@ func NewDbSettings(root *Root) (*DbSettings, error) {
 	return &sts, nil
 }
 
+// older files don't know which db was opened with app
+func (sts *DbSettings) addDbColumn() error {
+	n := 0
+	err := sts.db.tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info('settings') WHERE name='db'").Scan(&n)
+	if err != nil {
+		return fmt.Errorf("table_info(settings) failed: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	_, err = sts.db.Write("ALTER TABLE settings ADD COLUMN db TEXT;")
+	if err != nil {
+		return fmt.Errorf("Write() failed: %w", err)
+	}
+	return nil
+}
+
 func (sts *DbSettings) Destroy() error {
 	return nil
 }
@@ -78,10 +101,10 @@ func (sts *DbSettings) AddSts_uid() int {
 	return sts.max_sts_uid
 }
 
-func (sts *DbSettings) Add(id int, asset string) (int, error) {
+func (sts *DbSettings) Add(id int, asset string, dbName string) (int, error) {
 	sts.max_sts_uid = OsMax(sts.max_sts_uid, id)
 
-	res, err := sts.db.Write("INSERT INTO settings(id, asset) VALUES(?, ?);", id, asset)
+	res, err := sts.db.Write("INSERT INTO settings(id, asset, db) VALUES(?, ?, ?);", id, asset, dbName)
 	if err != nil {
 		return -1, fmt.Errorf("Write() failed: %w", err)
 	}
@@ -111,17 +134,26 @@ func (sts *DbSettings) Find(id int, asset string) (int, error) {
 	return rowid, nil
 }
 
-func (sts *DbSettings) FindOrAdd(id int, asset string) (int, error) {
+func (sts *DbSettings) FindOrAdd(id int, asset string, dbName string) (int, error) {
 	rowid, err := sts.Find(id, asset)
 	if err != nil {
 		return -1, fmt.Errorf("Find() failed: %w", err)
 	}
 
 	if rowid < 0 {
-		rowid, err = sts.Add(id, asset)
+		rowid, err = sts.Add(id, asset, dbName)
 		if err != nil {
 			return -1, fmt.Errorf("Add() failed: %w", err)
 		}
+	} else {
+		//rows from older files
+		res, err := sts.db.Write("UPDATE settings SET db=? WHERE rowid=? AND db IS NULL;", dbName, rowid)
+		if err != nil {
+			return -1, fmt.Errorf("Write() failed: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			sts.db.Commit()
+		}
 	}
 
 	return rowid, nil
@@ -174,7 +206,7 @@ func (sts *DbSettings) Duplicate(srcid int) (int, error) {
 
 	dstId := sts.AddSts_uid()
 
-	rows, err := sts.db.db.Query("SELECT asset, content FROM settings WHERE id=?", srcid)
+	rows, err := sts.db.db.Query("SELECT asset, content, db FROM settings WHERE id=?", srcid)
 	if err != nil {
 		return -1, fmt.Errorf("query SELECT failed: %w", err)
 	}
@@ -184,13 +216,14 @@ func (sts *DbSettings) Duplicate(srcid int) (int, error) {
 		//get
 		var asset string
 		var content []byte
-		err := rows.Scan(&asset, &content)
+		var dbName sql.NullString
+		err := rows.Scan(&asset, &content, &dbName)
 		if err != nil {
 			return -1, fmt.Errorf("Scan() failed: %w", err)
 		}
 
 		//insert
-		_, err = sts.db.Write("INSERT INTO settings(id, asset, content) VALUES(?, ?, ?);", dstId, asset, content)
+		_, err = sts.db.Write("INSERT INTO settings(id, asset, content, db) VALUES(?, ?, ?, ?);", dstId, asset, content, dbName)
 		if err != nil {
 			return -1, fmt.Errorf("Write() failed: %w", err)
 		}
@@ -200,3 +233,89 @@ func (sts *DbSettings) Duplicate(srcid int) (int, error) {
 	return dstId, nil
 
 }
+
+type DbSettingsRow struct {
+	Id      int
+	Asset   string
+	Content []byte
+	Db      string
+}
+
+// returns rows of all apps, which were opened with db(also apps, which aren't running)
+func (sts *DbSettings) GetRowsDb(dbName string) ([]DbSettingsRow, error) {
+	rows, err := sts.db.db.Query("SELECT id, asset, content, db FROM settings WHERE db=?", dbName)
+	if err != nil {
+		return nil, fmt.Errorf("query SELECT failed: %w", err)
+	}
+	defer rows.Close()
+
+	var ret []DbSettingsRow
+	for rows.Next() {
+		var r DbSettingsRow
+		err := rows.Scan(&r.Id, &r.Asset, &r.Content, &r.Db)
+		if err != nil {
+			return nil, fmt.Errorf("Scan() failed: %w", err)
+		}
+		ret = append(ret, r)
+	}
+	return ret, rows.Err()
+}
+
+func (sts *DbSettings) RemoveRowsDb(dbName string) error {
+	_, err := sts.db.Write("DELETE FROM settings WHERE db=?;", dbName)
+	if err != nil {
+		sts.db.Rollback()
+		return fmt.Errorf("Write() failed: %w", err)
+	}
+	return sts.db.Commit()
+}
+
+// adds rows from other device. Ids are device-local, so rows get new ones(rows with same id keep sharing it)
+func (sts *DbSettings) ImportRows(rows []DbSettingsRow) error {
+	ids := make(map[int]int)
+	for _, r := range rows {
+		id, found := ids[r.Id]
+		if !found {
+			id = sts.AddSts_uid()
+			ids[r.Id] = id
+		}
+
+		_, err := sts.db.Write("INSERT INTO settings(id, asset, content, db) VALUES(?, ?, ?, ?);", id, r.Asset, r.Content, r.Db)
+		if err != nil {
+			sts.db.Rollback()
+			return fmt.Errorf("Write() failed: %w", err)
+		}
+	}
+
+	return sts.db.Commit()
+}
+
+// adds rows, which don't exist. 'replace' overwrites content of existing rows
+func (sts *DbSettings) AddRows(rows []DbSettingsRow, replace bool) error {
+	for _, r := range rows {
+		n := 0
+		err := sts.db.db.QueryRow("SELECT COUNT(*) FROM settings WHERE id=? AND asset=?", r.Id, r.Asset).Scan(&n)
+		if err != nil {
+			return fmt.Errorf("Scan() failed: %w", err)
+		}
+		if n > 0 {
+			if replace {
+				_, err = sts.db.Write("UPDATE settings SET content=?, db=? WHERE id=? AND asset=?;", r.Content, r.Db, r.Id, r.Asset)
+				if err != nil {
+					sts.db.Rollback()
+					return fmt.Errorf("Write() failed: %w", err)
+				}
+			}
+			continue //already exist
+		}
+
+		sts.max_sts_uid = OsMax(sts.max_sts_uid, r.Id)
+		_, err = sts.db.Write("INSERT INTO settings(id, asset, content, db) VALUES(?, ?, ?, ?);", r.Id, r.Asset, r.Content, r.Db)
+		if err != nil {
+			sts.db.Rollback()
+			return fmt.Errorf("Write() failed: %w", err)
+		}
+	}
+
+	return sts.db.Commit()
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func newTestSettings(t *testing.T) *DbSettings {
+	t.Helper()
+
+	root := &Root{folderDatabases: t.TempDir(), ctx: context.Background(), dbs: make(map[string]*Db)}
+	sts, err := NewDbSettings(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sts.db.Destroy() })
+	return sts
+}
+
+func TestAddRowsWithoutWrites(t *testing.T) {
+	sts := newTestSettings(t)
+
+	err := sts.AddRows(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := []DbSettingsRow{{Id: 1, Asset: "main", Content: []byte("{}"), Db: "notes"}}
+	err = sts.AddRows(rows, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sts.AddRows(rows, false) //row exists, nothing is written
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sts.db.tx != nil {
+		t.Fatal("transaction stays open")
+	}
+
+	got, err := sts.GetRowsDb("notes")
+	if err != nil || len(got) != 1 {
+		t.Fatalf("wrong rows: %v, %v", got, err)
+	}
+}
+
+func TestImportRowsNewIds(t *testing.T) {
+	sts := newTestSettings(t)
+
+	local := []DbSettingsRow{{Id: 1, Asset: "main", Content: []byte("local"), Db: "other"}}
+	err := sts.AddRows(local, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//same id on other device
+	err = sts.ImportRows([]DbSettingsRow{
+		{Id: 1, Asset: "main", Content: []byte("imported"), Db: "notes"},
+		{Id: 1, Asset: "side", Content: []byte("imported"), Db: "notes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := sts.GetRowsDb("other")
+	if err != nil || len(rows) != 1 || string(rows[0].Content) != "local" {
+		t.Fatalf("local row was changed: %v, %v", rows, err)
+	}
+	rows, err = sts.GetRowsDb("notes")
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("wrong imported rows: %v, %v", rows, err)
+	}
+	if rows[0].Id == 1 || rows[0].Id != rows[1].Id {
+		t.Fatalf("imported rows have wrong ids: %d, %d", rows[0].Id, rows[1].Id)
+	}
+}
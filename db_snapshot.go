@@ -0,0 +1,436 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DbSnapshot_FOLDER = "snapshots"      //inside folderDatabases
+const DbSnapshot_KEEP = 10                 //newest snapshots per db
+const DbSnapshot_INTERVAL = 60 * 60 * 1000 //ms between automatic snapshots
+const DbSnapshot_FORMAT = "2006-01-02_15-04-05.000"
+
+// Snapshots are written by goroutines, so UI doesn't freeze
+type DbSnapshots struct {
+	mu      sync.Mutex
+	running map[string]chan struct{} //closed when snapshot of db is finished
+}
+
+// db and snapshot names come from apps
+func DbSnapshot_checkName(name string) error {
+	if len(name) == 0 || name == "." || strings.Contains(name, "..") || strings.ContainsRune(name, '/') || strings.ContainsRune(name, '\\') {
+		return fmt.Errorf("name(%s) has invalid character", name)
+	}
+	return nil
+}
+
+// returns false when snapshot of db is already running
+func (s *DbSnapshots) start(name string) (chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.running[name]; found {
+		return nil, false
+	}
+	if s.running == nil {
+		s.running = make(map[string]chan struct{})
+	}
+	done := make(chan struct{})
+	s.running[name] = done
+	return done, true
+}
+
+func (s *DbSnapshots) finish(name string, done chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.running, name)
+	close(done)
+}
+
+func (s *DbSnapshots) IsRunning(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, found := s.running[name]
+	return found
+}
+
+// blocks until running snapshot of db is finished, so file can be replaced
+func (s *DbSnapshots) Wait(name string) {
+	s.mu.Lock()
+	done := s.running[name]
+	s.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+func (root *Root) getSnapshotsFolder(name string) string {
+	return root.folderDatabases + "/" + DbSnapshot_FOLDER + "/" + name
+}
+
+// returns snapshots names sorted from oldest
+func (root *Root) GetSnapshots(name string) []string {
+	dir, err := os.ReadDir(root.getSnapshotsFolder(name))
+	if err != nil {
+		return nil
+	}
+
+	var list []string
+	for _, file := range dir {
+		if !file.IsDir() && strings.EqualFold(filepath.Ext(file.Name()), ".sqlite") {
+			list = append(list, OsFileGetNameWithoutExt(file.Name()))
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// returns ms of last change in main or WAL file
+func DbSnapshot_modTime(path string) int64 {
+	tm := int64(-1)
+	info, err := os.Stat(path)
+	if err == nil {
+		tm = info.ModTime().UnixMilli()
+	}
+	info, err = os.Stat(path + "-wal")
+	if err == nil && info.ModTime().UnixMilli() > tm {
+		tm = info.ModTime().UnixMilli()
+	}
+	return tm
+}
+
+// commits pending writes of open Db, so copy has them
+func (root *Root) commitBeforeCopy(name string) error {
+	path := root.folderDatabases + "/" + name + ".sqlite"
+	if !OsFileExists(path) {
+		return fmt.Errorf("db(%s) not exist", name)
+	}
+
+	db, found := root.dbs[name]
+	if found {
+		return db.commitPending()
+	}
+	return nil
+}
+
+// Writes consistent copy of db(including WAL) into 'dstPath'.
+func (root *Root) vacuumInto(name string, dstPath string) error {
+	err := root.commitBeforeCopy(name)
+	if err != nil {
+		return err
+	}
+	return DbSnapshot_vacuumInto(root.folderDatabases+"/"+name+".sqlite", dstPath)
+}
+
+// Copies committed data through own read-only connection, so it can run on goroutine.
+func DbSnapshot_vacuumInto(path string, dstPath string) error {
+	if OsFileExists(dstPath) {
+		return fmt.Errorf("file(%s) already exist", dstPath)
+	}
+
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("Open(%s) failed: %w", path, err)
+	}
+	defer conn.Close()
+
+	//write into temporary file, so 'dstPath' is never half-written
+	tmpPath := dstPath + ".tmp"
+	os.Remove(tmpPath)
+
+	_, err = conn.Exec("VACUUM INTO ?", tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("VACUUM INTO(%s) failed: %w", dstPath, err)
+	}
+
+	err = OsFileRename(tmpPath, dstPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Rename(%s) failed: %w", dstPath, err)
+	}
+	return nil
+}
+
+// returns name of new snapshot. It's written on background, errors are printed.
+func (root *Root) SnapshotDb(name string) (string, error) {
+	snap, folder, err := root.prepareSnapshot(name)
+	if err != nil {
+		return "", err
+	}
+
+	done, ok := root.snapshots.start(name)
+	if !ok {
+		return "", fmt.Errorf("snapshot of db(%s) is already running", name)
+	}
+
+	path := root.folderDatabases + "/" + name + ".sqlite"
+	go func() {
+		defer root.snapshots.finish(name, done)
+
+		err := DbSnapshot_vacuumInto(path, folder+"/"+snap+".sqlite")
+		if err != nil {
+			fmt.Printf("SnapshotDb(%s) failed: %v\n", name, err)
+			return
+		}
+		root.removeOldSnapshots(name)
+	}()
+
+	return snap, nil
+}
+
+// same as SnapshotDb(), but returns after snapshot is written
+func (root *Root) snapshotDbWait(name string) (string, error) {
+	snap, folder, err := root.prepareSnapshot(name)
+	if err != nil {
+		return "", err
+	}
+	root.snapshots.Wait(name)
+
+	err = DbSnapshot_vacuumInto(root.folderDatabases+"/"+name+".sqlite", folder+"/"+snap+".sqlite")
+	if err != nil {
+		return "", err
+	}
+
+	root.removeOldSnapshots(name)
+	return snap, nil
+}
+
+// returns name and folder of new snapshot
+func (root *Root) prepareSnapshot(name string) (string, string, error) {
+	err := DbSnapshot_checkName(name)
+	if err != nil {
+		return "", "", err
+	}
+	err = root.commitBeforeCopy(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	folder := root.getSnapshotsFolder(name)
+	err = os.MkdirAll(folder, 0700)
+	if err != nil {
+		return "", "", fmt.Errorf("MkdirAll(%s) failed: %w", folder, err)
+	}
+
+	return time.Now().Format(DbSnapshot_FORMAT), folder, nil
+}
+
+func (root *Root) removeOldSnapshots(name string) {
+	list := root.GetSnapshots(name)
+	for i := 0; i < len(list)-DbSnapshot_KEEP; i++ {
+		path := root.getSnapshotsFolder(name) + "/" + list[i] + ".sqlite"
+		err := OsFileRemove(path)
+		if err != nil {
+			fmt.Printf("OsFileRemove(%s) failed: %v\n", path, err)
+		}
+	}
+}
+
+// Replaces db file with snapshot. Current state is snapshotted first, so restore can be reverted.
+func (root *Root) RestoreDb(name string, snap string) error {
+	err := DbSnapshot_checkName(name)
+	if err != nil {
+		return err
+	}
+	err = DbSnapshot_checkName(snap)
+	if err != nil {
+		return err
+	}
+	snapPath := root.getSnapshotsFolder(name) + "/" + snap + ".sqlite"
+	if !OsFileExists(snapPath) {
+		return fmt.Errorf("snapshot(%s) of db(%s) not exist", snap, name)
+	}
+
+	db, found := root.dbs[name]
+	if found && db.explicit != nil {
+		return fmt.Errorf("db(%s) is locked by transaction of asset(%s)", db.name, db.explicit.asset.name)
+	}
+
+	path := root.folderDatabases + "/" + name + ".sqlite"
+	if OsFileExists(path) {
+		_, err := root.snapshotDbWait(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	tmpPath := path + ".restore"
+	err = OsFileCopy(snapPath, tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("OsFileCopy(%s) failed: %w", snapPath, err)
+	}
+
+	return root.replaceDbFile(name, tmpPath, false)
+}
+
+// Replaces db file with 'tmpPath'. Old file is moved into trash or overwritten. Open Db is reopened
+// with same assets, subscriptions and functions.
+func (root *Root) replaceDbFile(name string, tmpPath string, trash bool) error {
+	path := root.folderDatabases + "/" + name + ".sqlite"
+
+	root.snapshots.Wait(name) //reads old file
+
+	//close, so WAL is checkpointed and nobody writes into old file
+	state := root.closeDb(name)
+
+	if trash && OsFileExists(path) {
+		_, err := root.TrashDb(name)
+		if err != nil {
+			os.Remove(tmpPath)
+			root.reopenDb(name, state) //old file stays
+			return err
+		}
+	}
+
+	//old WAL must not be applied on new file
+	for _, ext := range []string{"-wal", "-shm"} {
+		if OsFileExists(path + ext) {
+			err := OsFileRemove(path + ext)
+			if err != nil {
+				os.Remove(tmpPath)
+				root.reopenDb(name, state)
+				return fmt.Errorf("OsFileRemove(%s) failed: %w", path+ext, err)
+			}
+		}
+	}
+
+	err := OsFileRename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		root.reopenDb(name, state)
+		return fmt.Errorf("Rename(%s) failed: %w", path, err)
+	}
+
+	err = root.reopenDb(name, state)
+	if err != nil {
+		return err
+	}
+
+	root.updateDbsList()
+	return nil
+}
+
+// What apps registered in closed Db
+type DbOpenState struct {
+	assets map[*Asset]bool
+	subs   []*DbSubscription
+	funcs  map[string]*DbFunc
+	asyncs bool
+}
+
+// returns nil if db wasn't open
+func (root *Root) closeDb(name string) *DbOpenState {
+	db, found := root.dbs[name]
+	if !found {
+		return nil
+	}
+
+	state := &DbOpenState{assets: db.assets, subs: db.subs, funcs: db.funcs, asyncs: len(db.asyncs) > 0}
+	err := db.Destroy()
+	if err != nil {
+		fmt.Printf("db(%s).Destroy() failed: %v\n", name, err)
+	}
+	delete(root.dbs, name)
+	return state
+}
+
+// opens db closed by closeDb() with same assets, subscriptions and functions
+func (root *Root) reopenDb(name string, state *DbOpenState) error {
+	if state == nil {
+		return nil
+	}
+
+	db, err := root.AddDb(name)
+	if err != nil {
+		return err
+	}
+	for asset := range state.assets {
+		db.AddAsset(asset)
+		asset.migrations = nil
+	}
+	db.subs = state.subs
+	if len(state.funcs) > 0 {
+		db.funcs = state.funcs
+		err = db.reconnect() //AddDb() may already opened connection without them
+		if err != nil {
+			return err
+		}
+	}
+	db.NotifyChange(nil)
+
+	//workers read old file, apps start them again
+	if state.asyncs {
+		root.ui.SetRedraw()
+	}
+	return nil
+}
+
+// Takes automatic snapshot of one db, which was changed since its last snapshot
+func (root *Root) MaintenanceSnapshots() {
+	dir, err := os.ReadDir(root.folderDatabases)
+	if err != nil {
+		fmt.Printf("ReadDir() failed: %v\n", err)
+		return
+	}
+
+	for _, file := range dir {
+		if file.IsDir() || !strings.EqualFold(filepath.Ext(file.Name()), ".sqlite") {
+			continue
+		}
+		name := OsFileGetNameWithoutExt(file.Name())
+
+		db, found := root.dbs[name]
+		if found && (db.explicit != nil || db.tx != nil) {
+			continue //wait for commit
+		}
+		if root.snapshots.IsRunning(name) {
+			continue
+		}
+
+		last := int64(-1)
+		list := root.GetSnapshots(name)
+		if len(list) > 0 {
+			tm, err := time.ParseInLocation(DbSnapshot_FORMAT, list[len(list)-1], time.Local)
+			if err == nil {
+				last = tm.UnixMilli()
+			}
+		}
+
+		if last >= 0 && (time.Now().UnixMilli()-last < DbSnapshot_INTERVAL || DbSnapshot_modTime(root.folderDatabases+"/"+file.Name()) <= last) {
+			continue //fresh or unchanged
+		}
+
+		_, err := root.SnapshotDb(name)
+		if err != nil {
+			fmt.Printf("SnapshotDb(%s) failed: %v\n", name, err)
+		}
+		return //one per call, it can be slow
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReplaceDbFileReopens(t *testing.T) {
+	root := &Root{folderDatabases: t.TempDir(), ctx: context.Background(), dbs: make(map[string]*Db)}
+	db, err := root.AddDb("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWrite(t, db, "CREATE TABLE t(a INT)")
+	testCommit(t, db)
+	asset := &Asset{name: "main"}
+	db.AddAsset(asset)
+
+	//rename fails, old file stays open
+	err = root.replaceDbFile("notes", root.folderDatabases+"/missing.sqlite", false)
+	if err == nil {
+		t.Fatal("missing file replaced db")
+	}
+	db = root.dbs["notes"]
+	if db == nil || !db.assets[asset] {
+		t.Fatal("db wasn't reopened with its assets")
+	}
+	t.Cleanup(func() { db.Destroy() })
+	if testCount(t, db, "SELECT COUNT(*) FROM sqlite_master WHERE name='t'") != 1 {
+		t.Fatal("old file was lost")
+	}
+}
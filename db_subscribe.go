@@ -0,0 +1,178 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+const DbSubscribe_UNUSED = 5000     //ms, subscription is removed when asset stops asking for it
+const DbSubscribe_MAX_ROWS = 100000 //rows above it are only counted
+
+// Query, which is re-run after commits into tables it reads. Asset is redrawn only when result was changed.
+type DbSubscription struct {
+	asset      *Asset
+	query      string
+	params     []byte
+	query_hash int64
+
+	tables map[string]bool //read by query(nil = unknown)
+	hash   [sha256.Size]byte
+	stale  bool
+
+	version int64 //increased when result changes
+	used    int   //ticks of last access
+}
+
+func (db *Db) findSubscription(asset *Asset, query_hash int64) *DbSubscription {
+	for _, it := range db.subs {
+		if it.asset == asset && it.query_hash == query_hash {
+			return it
+		}
+	}
+	return nil
+}
+
+// returns version of result
+func (db *Db) Subscribe(asset *Asset, query string, params []byte) (int64, error) {
+	query_hash := DbCache_hash(query, params)
+
+	sub := db.findSubscription(asset, query_hash)
+	if sub == nil {
+		sub = &DbSubscription{asset: asset, query: query, params: bytes.Clone(params), query_hash: query_hash, stale: true}
+
+		err := db.updateSubscription(sub)
+		if err != nil {
+			return -1, err
+		}
+		db.subs = append(db.subs, sub)
+	}
+	sub.used = OsTicks()
+
+	return sub.version, nil
+}
+
+// re-runs query and compares hash of result. Returns true if result was changed.
+func (db *Db) updateSubscription(sub *DbSubscription) error {
+	db.SetPolicy(sub.asset.app, sub.asset.name)
+	defer db.SetPolicy(nil, "")
+
+	cache, err := db.AddCache(sub.query, sub.params)
+	if err != nil {
+		return err
+	}
+
+	//one pass without filling windows
+	h := sha256.New()
+	n, err := cache.HashRows(h, DbSubscribe_MAX_ROWS)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "%d", n)
+
+	var hash [sha256.Size]byte
+	copy(hash[:], h.Sum(nil))
+
+	sub.tables = cache.tables
+	sub.stale = false
+	if sub.version == 0 || hash != sub.hash {
+		sub.hash = hash
+		sub.version++
+	}
+	return nil
+}
+
+func (db *Db) markAllSubscriptions() {
+	for _, it := range db.subs {
+		it.stale = true
+	}
+}
+
+// marks subscriptions, which read changed tables
+func (db *Db) markSubscriptions(tables map[string]bool) {
+	if len(tables) == 0 {
+		return
+	}
+	for _, it := range db.subs {
+		if it.tables == nil {
+			it.stale = true
+			continue
+		}
+		for t := range tables {
+			if it.tables[t] {
+				it.stale = true
+				break
+			}
+		}
+	}
+}
+
+func (db *Db) removeSubscriptions(asset *Asset) {
+	n := 0
+	for _, it := range db.subs {
+		if it.asset != asset {
+			db.subs[n] = it
+			n++
+		}
+	}
+	db.subs = db.subs[:n]
+}
+
+// re-runs stale subscriptions and schedules redraw when some result was changed
+func (root *Root) UpdateSubscriptions() {
+	for _, db := range root.dbs {
+		if db.tx != nil {
+			continue //uncommitted
+		}
+
+		n := 0
+		for _, it := range db.subs {
+			if !OsIsTicksIn(it.used, DbSubscribe_UNUSED) {
+				continue
+			}
+			db.subs[n] = it
+			n++
+
+			if !it.stale {
+				continue
+			}
+
+			version := it.version
+			err := db.updateSubscription(it)
+			if err != nil {
+				it.stale = false //don't repeat it every tick
+				it.asset.AddLogErr(fmt.Errorf("subscription(%s) failed: %w", it.query, err))
+				continue
+			}
+			if it.version != version {
+				it.asset.dirty = true
+			}
+		}
+		db.subs = db.subs[:n]
+	}
+
+	for _, app := range root.apps {
+		for _, asset := range app.assets {
+			if asset.dirty {
+				asset.dirty = false
+				root.ui.SetRedraw()
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSubscriptionVersion(t *testing.T) {
+	root := &Root{folderDatabases: t.TempDir(), ctx: context.Background(), dbs: make(map[string]*Db)}
+	db, err := root.AddDb("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Destroy() })
+
+	testWrite(t, db, "CREATE TABLE a(x INTEGER)")
+	testWrite(t, db, "CREATE TABLE b(y INTEGER)")
+	testCommit(t, db)
+
+	asset := &Asset{name: "main"}
+	version, err := db.Subscribe(asset, "SELECT x FROM a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//other table
+	testWrite(t, db, "INSERT INTO b VALUES(1)")
+	testCommit(t, db)
+	if db.subs[0].stale {
+		t.Fatal("write into other table marked subscription")
+	}
+
+	//same result
+	testWrite(t, db, "UPDATE a SET x=x")
+	testCommit(t, db)
+	root.UpdateSubscriptions()
+	if v, _ := db.Subscribe(asset, "SELECT x FROM a", nil); v != version || asset.dirty {
+		t.Fatal("same result changed version")
+	}
+
+	//new result
+	testWrite(t, db, "INSERT INTO a VALUES(1)")
+	testCommit(t, db)
+	root.UpdateSubscriptions()
+	if v, _ := db.Subscribe(asset, "SELECT x FROM a", nil); v == version || !asset.dirty {
+		t.Fatal("changed result didn't change version")
+	}
+
+	//unused subscription is removed
+	db.subs[0].used = 0
+	root.UpdateSubscriptions()
+	if len(db.subs) != 0 {
+		t.Fatal("unused subscription wasn't removed")
+	}
+}
@@ -0,0 +1,513 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Row changes are recorded by triggers into _sa_sync_log. Every row has uid(device:clock), because
+// rowids are different on every device. Log keeps only last version of every column, newer version
+// (clock, device) wins. Deleted row has tombstone with empty 'col' and it wins over everything.
+const DbSync_STATE = "_sa_sync_state"
+const DbSync_LOG = "_sa_sync_log"
+const DbSync_ROWS = "_sa_sync_rows"
+const DbSync_PEERS = "_sa_sync_peers"
+
+type DbSyncEntry struct {
+	Seq    int64 //order in sender's log
+	Clock  int64
+	Device string
+	Tbl    string
+	Uid    string
+	Col    string //empty = row was deleted
+	Value  interface{}
+}
+
+// version a is newer than b
+func DbSync_isNewer(aClock int64, aDevice string, bClock int64, bDevice string) bool {
+	return aClock > bClock || (aClock == bClock && aDevice > bDevice)
+}
+
+func DbSync_quoteLiteral(str string) string {
+	return "'" + strings.ReplaceAll(str, "'", "''") + "'"
+}
+
+// Connection, which is used only by sync. Main thread finds changed tables(Db.CheckExternalChange), but keeps undo(DbSync.TakeChanged).
+type DbSyncFile struct {
+	name   string
+	device string
+	db     *sql.DB
+
+	changed func() //called before file is written
+}
+
+func NewDbSyncFile(path string, name string, device string, changed func()) (*DbSyncFile, error) {
+	var f DbSyncFile
+	f.name = name
+	f.device = device
+	f.changed = changed
+
+	var err error
+	f.db, err = sql.Open("sqlite3", "file:"+path+"?&_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("Open(%s) failed: %w", path, err)
+	}
+	f.db.SetMaxOpenConns(1)
+
+	err = f.install()
+	if err != nil {
+		f.Destroy()
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (f *DbSyncFile) Destroy() {
+	f.db.Close()
+}
+
+// returns true if file was installed for this device and schema wasn't changed since
+func (f *DbSyncFile) isInstalled() bool {
+	var device string
+	var applying, schema, version int64
+	err := f.db.QueryRow("SELECT device, applying, schema FROM "+DbSync_STATE+" WHERE id=0").Scan(&device, &applying, &schema)
+	if err != nil {
+		return false //not installed yet
+	}
+	err = f.db.QueryRow("PRAGMA schema_version").Scan(&version)
+	if err != nil {
+		return false
+	}
+	return device == f.device && applying == 0 && schema == version
+}
+
+// creates sync tables and triggers for all tables, so new columns are recorded too. File isn't
+// written when it was already installed for current schema.
+func (f *DbSyncFile) install() error {
+	if f.isInstalled() {
+		return nil
+	}
+	f.changed()
+
+	tx, err := f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Begin(%s) failed: %w", f.name, err)
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		"CREATE TABLE IF NOT EXISTS " + DbSync_STATE + "(id INTEGER PRIMARY KEY CHECK(id=0), device TEXT, clock INTEGER, applying INTEGER, schema INTEGER)",
+		"CREATE TABLE IF NOT EXISTS " + DbSync_LOG + "(seq INTEGER PRIMARY KEY AUTOINCREMENT, clock INTEGER, device TEXT, tbl TEXT, uid TEXT, col TEXT, value, UNIQUE(tbl, uid, col))",
+		"CREATE TABLE IF NOT EXISTS " + DbSync_ROWS + "(tbl TEXT, rid INTEGER, uid TEXT, PRIMARY KEY(tbl, rid))",
+		"CREATE UNIQUE INDEX IF NOT EXISTS " + DbSync_ROWS + "_uid ON " + DbSync_ROWS + "(tbl, uid)",
+		"CREATE TABLE IF NOT EXISTS " + DbSync_PEERS + "(device TEXT PRIMARY KEY, seq INTEGER)",
+		"INSERT OR IGNORE INTO " + DbSync_STATE + "(id, device, clock, applying) VALUES(0, '', 0, 0)",
+		"UPDATE " + DbSync_STATE + " SET device=" + DbSync_quoteLiteral(f.device) + ", applying=0 WHERE device IS NOT " + DbSync_quoteLiteral(f.device) + " OR applying<>0", //file could be copied from other device
+	}
+	for _, q := range queries {
+		_, err = tx.Exec(q)
+		if err != nil {
+			return fmt.Errorf("query(%s) failed: %w", q, err)
+		}
+	}
+
+	//table from older version
+	n := 0
+	err = tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name='schema'", DbSync_STATE).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("table_info(%s) failed: %w", DbSync_STATE, err)
+	}
+	if n == 0 {
+		_, err = tx.Exec("ALTER TABLE " + DbSync_STATE + " ADD COLUMN schema INTEGER")
+		if err != nil {
+			return fmt.Errorf("ALTER TABLE(%s) failed: %w", DbSync_STATE, err)
+		}
+	}
+
+	tables, err := f.getTables(tx)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		cols, err := f.getColumns(tx, t)
+		if err != nil {
+			return err
+		}
+		if len(cols) == 0 {
+			continue
+		}
+		if _, err := tx.Exec("SELECT rowid FROM " + DbUndo_quoteName(t) + " LIMIT 0"); err != nil {
+			continue //WITHOUT ROWID table
+		}
+
+		err = f.installTriggers(tx, t, cols)
+		if err != nil {
+			fmt.Printf("table(%s) can't be synced: %v\n", t, err)
+			continue
+		}
+		err = f.addOldRows(tx, t, cols)
+		if err != nil {
+			return err
+		}
+	}
+
+	//triggers changed schema, so version is read at the end
+	_, err = tx.Exec("UPDATE " + DbSync_STATE + " SET schema=(SELECT schema_version FROM pragma_schema_version)")
+	if err != nil {
+		return fmt.Errorf("query UPDATE(schema) failed: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Commit(%s) failed: %w", f.name, err)
+	}
+	return nil
+}
+
+func (f *DbSyncFile) getTables(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query("SELECT name FROM sqlite_master WHERE type='table' AND sql NOT LIKE 'CREATE VIRTUAL%' AND name NOT LIKE '\\_sa\\_%' ESCAPE '\\' AND name NOT LIKE '\\_history\\_%' ESCAPE '\\' AND name NOT LIKE 'sqlite\\_%' ESCAPE '\\'")
+	if err != nil {
+		return nil, fmt.Errorf("query SELECT(tables) failed: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, fmt.Errorf("Scan() failed: %w", err)
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+func (f *DbSyncFile) getColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, fmt.Errorf("table_info(%s) failed: %w", table, err)
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, fmt.Errorf("Scan() failed: %w", err)
+		}
+		cols = append(cols, name)
+	}
+	return cols, rows.Err()
+}
+
+func (f *DbSyncFile) installTriggers(tx *sql.Tx, table string, cols []string) error {
+	qt := DbUndo_quoteName(table)
+	lt := DbSync_quoteLiteral(table)
+	when := " WHEN (SELECT applying FROM " + DbSync_STATE + ")=0 BEGIN UPDATE " + DbSync_STATE + " SET clock=clock+1;"
+	logCol := func(col string, cond string) string {
+		return "INSERT OR REPLACE INTO " + DbSync_LOG + "(clock, device, tbl, uid, col, value) SELECT s.clock, s.device, " + lt + ", r.uid, " + DbSync_quoteLiteral(col) + ", NEW." + DbUndo_quoteName(col) +
+			" FROM " + DbSync_STATE + " s, " + DbSync_ROWS + " r WHERE r.tbl=" + lt + " AND r.rid=NEW.rowid" + cond + ";"
+	}
+
+	ins := "CREATE TRIGGER " + DbUndo_quoteName("_sa_sync_ins_"+table) + " AFTER INSERT ON " + qt + when +
+		"INSERT OR REPLACE INTO " + DbSync_ROWS + "(tbl, rid, uid) SELECT " + lt + ", NEW.rowid, device||':'||clock FROM " + DbSync_STATE + ";"
+	for _, c := range cols {
+		ins += logCol(c, "")
+	}
+	ins += "END"
+
+	upd := "CREATE TRIGGER " + DbUndo_quoteName("_sa_sync_upd_"+table) + " AFTER UPDATE ON " + qt + when +
+		"UPDATE " + DbSync_ROWS + " SET rid=NEW.rowid WHERE tbl=" + lt + " AND rid=OLD.rowid;"
+	for _, c := range cols {
+		upd += logCol(c, " AND NEW."+DbUndo_quoteName(c)+" IS NOT OLD."+DbUndo_quoteName(c))
+	}
+	upd += "END"
+
+	del := "CREATE TRIGGER " + DbUndo_quoteName("_sa_sync_del_"+table) + " AFTER DELETE ON " + qt + when +
+		"DELETE FROM " + DbSync_LOG + " WHERE tbl=" + lt + " AND uid=(SELECT uid FROM " + DbSync_ROWS + " WHERE tbl=" + lt + " AND rid=OLD.rowid);" +
+		"INSERT OR REPLACE INTO " + DbSync_LOG + "(clock, device, tbl, uid, col, value) SELECT s.clock, s.device, " + lt + ", r.uid, '', NULL FROM " + DbSync_STATE + " s, " + DbSync_ROWS + " r WHERE r.tbl=" + lt + " AND r.rid=OLD.rowid;" +
+		"DELETE FROM " + DbSync_ROWS + " WHERE tbl=" + lt + " AND rid=OLD.rowid;" +
+		"END"
+
+	for i, tp := range []string{"ins", "upd", "del"} {
+		name := "_sa_sync_" + tp + "_" + table
+		q := []string{ins, upd, del}[i]
+
+		//same columns
+		var old string
+		err := tx.QueryRow("SELECT sql FROM sqlite_master WHERE type='trigger' AND name=?", name).Scan(&old)
+		if err == nil && old == q {
+			continue
+		}
+
+		_, err = tx.Exec("DROP TRIGGER IF EXISTS " + DbUndo_quoteName(name))
+		if err != nil {
+			return fmt.Errorf("DROP TRIGGER(%s) failed: %w", table, err)
+		}
+		_, err = tx.Exec(q)
+		if err != nil {
+			return fmt.Errorf("CREATE TRIGGER(%s) failed: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// rows, which were added before triggers existed, get uid and all their columns are logged
+func (f *DbSyncFile) addOldRows(tx *sql.Tx, table string, cols []string) error {
+	rows, err := tx.Query("SELECT rowid FROM "+DbUndo_quoteName(table)+" WHERE rowid NOT IN (SELECT rid FROM "+DbSync_ROWS+" WHERE tbl=?)", table)
+	if err != nil {
+		return fmt.Errorf("query SELECT(%s) failed: %w", table, err)
+	}
+	var rids []int64
+	for rows.Next() {
+		var rid int64
+		err := rows.Scan(&rid)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan() failed: %w", err)
+		}
+		rids = append(rids, rid)
+	}
+	rows.Close()
+
+	for _, rid := range rids {
+		_, err := tx.Exec("UPDATE " + DbSync_STATE + " SET clock=clock+1")
+		if err != nil {
+			return fmt.Errorf("query UPDATE(clock) failed: %w", err)
+		}
+		_, err = tx.Exec("INSERT INTO "+DbSync_ROWS+"(tbl, rid, uid) SELECT ?, ?, device||':'||clock FROM "+DbSync_STATE, table, rid)
+		if err != nil {
+			return fmt.Errorf("query INSERT(rows) failed: %w", err)
+		}
+		for _, c := range cols {
+			_, err = tx.Exec("INSERT OR REPLACE INTO "+DbSync_LOG+"(clock, device, tbl, uid, col, value) SELECT s.clock, s.device, ?, r.uid, ?, t."+DbUndo_quoteName(c)+
+				" FROM "+DbSync_STATE+" s, "+DbSync_ROWS+" r, "+DbUndo_quoteName(table)+" t WHERE r.tbl=? AND r.rid=? AND t.rowid=r.rid", table, c, table, rid)
+			if err != nil {
+				return fmt.Errorf("query INSERT(log) failed: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// returns last seq, which was received from device
+func (f *DbSyncFile) GetPeerSeq(device string) (int64, error) {
+	var seq int64
+	err := f.db.QueryRow("SELECT seq FROM "+DbSync_PEERS+" WHERE device=?", device).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return -1, fmt.Errorf("Scan() failed: %w", err)
+	}
+	return seq, nil
+}
+
+// returns log entries after 'since'
+func (f *DbSyncFile) GetChanges(since int64, max int) ([]DbSyncEntry, error) {
+	rows, err := f.db.Query("SELECT seq, clock, device, tbl, uid, col, value FROM "+DbSync_LOG+" WHERE seq>? ORDER BY seq LIMIT ?", since, max)
+	if err != nil {
+		return nil, fmt.Errorf("query SELECT(log) failed: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []DbSyncEntry
+	for rows.Next() {
+		var e DbSyncEntry
+		err := rows.Scan(&e.Seq, &e.Clock, &e.Device, &e.Tbl, &e.Uid, &e.Col, &e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Scan() failed: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Merges entries from device. 'seq' is remembered, so next sync asks only for newer entries.
+func (f *DbSyncFile) Apply(device string, entries []DbSyncEntry, seq int64) error {
+	f.changed()
+
+	tx, err := f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Begin(%s) failed: %w", f.name, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE " + DbSync_STATE + " SET applying=1")
+	if err != nil {
+		return fmt.Errorf("query UPDATE(state) failed: %w", err)
+	}
+
+	type Row struct {
+		tbl  string
+		uid  string
+		cols []string
+		vals []interface{}
+	}
+	var order []string
+	pending := make(map[string]*Row) //tbl + uid
+
+	for _, e := range entries {
+		//local version
+		var lClock int64
+		var lDevice string
+		err := tx.QueryRow("SELECT clock, device FROM "+DbSync_LOG+" WHERE tbl=? AND uid=? AND col=?", e.Tbl, e.Uid, e.Col).Scan(&lClock, &lDevice)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("Scan() failed: %w", err)
+		}
+		if err == nil && !DbSync_isNewer(e.Clock, e.Device, lClock, lDevice) {
+			continue //local is same or newer
+		}
+
+		if e.Col != "" {
+			var n int
+			err = tx.QueryRow("SELECT COUNT(*) FROM "+DbSync_LOG+" WHERE tbl=? AND uid=? AND col=''", e.Tbl, e.Uid).Scan(&n)
+			if err != nil {
+				return fmt.Errorf("Scan() failed: %w", err)
+			}
+			if n > 0 {
+				continue //row was deleted
+			}
+		}
+
+		_, err = tx.Exec("UPDATE "+DbSync_STATE+" SET clock=MAX(clock, ?)", e.Clock)
+		if err != nil {
+			return fmt.Errorf("query UPDATE(clock) failed: %w", err)
+		}
+
+		key := e.Tbl + "\x00" + e.Uid
+		if e.Col == "" {
+			delete(pending, key)
+			err = f.deleteRow(tx, e)
+			if err != nil {
+				return err
+			}
+		} else {
+			r, found := pending[key]
+			if !found {
+				r = &Row{tbl: e.Tbl, uid: e.Uid}
+				pending[key] = r
+				order = append(order, key)
+			}
+			r.cols = append(r.cols, e.Col)
+			r.vals = append(r.vals, e.Value)
+		}
+
+		_, err = tx.Exec("INSERT OR REPLACE INTO "+DbSync_LOG+"(clock, device, tbl, uid, col, value) VALUES(?, ?, ?, ?, ?, ?)", e.Clock, e.Device, e.Tbl, e.Uid, e.Col, e.Value)
+		if err != nil {
+			return fmt.Errorf("query INSERT(log) failed: %w", err)
+		}
+	}
+
+	for _, key := range order {
+		r, found := pending[key]
+		if !found {
+			continue //deleted
+		}
+		err = f.writeRow(tx, r.tbl, r.uid, r.cols, r.vals)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO "+DbSync_PEERS+"(device, seq) VALUES(?, ?)", device, seq)
+	if err != nil {
+		return fmt.Errorf("query INSERT(peers) failed: %w", err)
+	}
+	_, err = tx.Exec("UPDATE " + DbSync_STATE + " SET applying=0")
+	if err != nil {
+		return fmt.Errorf("query UPDATE(state) failed: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Commit(%s) failed: %w", f.name, err)
+	}
+	return nil
+}
+
+func (f *DbSyncFile) findRow(tx *sql.Tx, tbl string, uid string) (int64, bool, error) {
+	var rid int64
+	err := tx.QueryRow("SELECT rid FROM "+DbSync_ROWS+" WHERE tbl=? AND uid=?", tbl, uid).Scan(&rid)
+	if err == sql.ErrNoRows {
+		return -1, false, nil
+	}
+	if err != nil {
+		return -1, false, fmt.Errorf("Scan() failed: %w", err)
+	}
+	return rid, true, nil
+}
+
+func (f *DbSyncFile) deleteRow(tx *sql.Tx, e DbSyncEntry) error {
+	rid, found, err := f.findRow(tx, e.Tbl, e.Uid)
+	if err != nil || !found {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM "+DbUndo_quoteName(e.Tbl)+" WHERE rowid=?", rid)
+	if err != nil {
+		return fmt.Errorf("query DELETE(%s) failed: %w", e.Tbl, err)
+	}
+	_, err = tx.Exec("DELETE FROM "+DbSync_ROWS+" WHERE tbl=? AND rid=?", e.Tbl, rid)
+	if err != nil {
+		return fmt.Errorf("query DELETE(rows) failed: %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM "+DbSync_LOG+" WHERE tbl=? AND uid=? AND col<>''", e.Tbl, e.Uid)
+	if err != nil {
+		return fmt.Errorf("query DELETE(log) failed: %w", err)
+	}
+	return nil
+}
+
+func (f *DbSyncFile) writeRow(tx *sql.Tx, tbl string, uid string, cols []string, vals []interface{}) error {
+	rid, found, err := f.findRow(tx, tbl, uid)
+	if err != nil {
+		return err
+	}
+
+	qcols := make([]string, len(cols))
+	for i, c := range cols {
+		qcols[i] = DbUndo_quoteName(c)
+	}
+
+	if found {
+		_, err = tx.Exec("UPDATE "+DbUndo_quoteName(tbl)+" SET "+strings.Join(qcols, "=?, ")+"=? WHERE rowid=?", append(vals, rid)...)
+		if err != nil {
+			return fmt.Errorf("query UPDATE(%s) failed: %w", tbl, err)
+		}
+		return nil
+	}
+
+	res, err := tx.Exec("INSERT INTO "+DbUndo_quoteName(tbl)+"("+strings.Join(qcols, ", ")+") VALUES(?"+strings.Repeat(", ?", len(cols)-1)+")", vals...)
+	if err != nil {
+		return fmt.Errorf("query INSERT(%s) failed: %w", tbl, err)
+	}
+	rid, err = res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("LastInsertId() failed: %w", err)
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO "+DbSync_ROWS+"(tbl, rid, uid) VALUES(?, ?, ?)", tbl, rid, uid)
+	if err != nil {
+		return fmt.Errorf("query INSERT(rows) failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const DbSyncConn_NONCE = 32                   //random bytes sent by both sides
+const DbSyncConn_MAX_FRAME = 64 * 1024 * 1024 //bytes
+
+// Encrypted and authenticated channel between paired devices. Both sides know secret, keys are derived
+// from it and random nonces of both sides, so recorded session can't be replayed. Peer with different
+// secret fails on first frame.
+type DbSyncConn struct {
+	conn net.Conn
+
+	enc     cipher.AEAD
+	enc_seq uint64
+	dec     cipher.AEAD
+	dec_seq uint64
+
+	buff []byte //decrypted, not read yet
+}
+
+func DbSyncConn_key(secret string, label string, clientNonce []byte, serverNonce []byte) []byte {
+	key := sha256.Sum256([]byte(secret))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(label))
+	mac.Write(clientNonce)
+	mac.Write(serverNonce)
+	return mac.Sum(nil)
+}
+
+func DbSyncConn_aead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("NewCipher() failed: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("NewGCM() failed: %w", err)
+	}
+	return aead, nil
+}
+
+func NewDbSyncConn(conn net.Conn, secret string, client bool) (*DbSyncConn, error) {
+	if len(secret) < 16 {
+		return nil, errors.New("sync secret is too short, devices must be paired first")
+	}
+
+	//exchange nonces
+	own := make([]byte, DbSyncConn_NONCE)
+	_, err := rand.Read(own)
+	if err != nil {
+		return nil, fmt.Errorf("rand.Read() failed: %w", err)
+	}
+	_, err = conn.Write(own)
+	if err != nil {
+		return nil, fmt.Errorf("Write() failed: %w", err)
+	}
+	peer := make([]byte, DbSyncConn_NONCE)
+	_, err = io.ReadFull(conn, peer)
+	if err != nil {
+		return nil, fmt.Errorf("Read() failed: %w", err)
+	}
+
+	clientNonce, serverNonce := own, peer
+	if !client {
+		clientNonce, serverNonce = peer, own
+	}
+	toServer := DbSyncConn_key(secret, "client", clientNonce, serverNonce)
+	toClient := DbSyncConn_key(secret, "server", clientNonce, serverNonce)
+	if !client {
+		toServer, toClient = toClient, toServer
+	}
+
+	var c DbSyncConn
+	c.conn = conn
+	c.enc, err = DbSyncConn_aead(toServer)
+	if err != nil {
+		return nil, err
+	}
+	c.dec, err = DbSyncConn_aead(toClient)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *DbSyncConn) nonce(seq uint64, size int) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[size-8:], seq)
+	return nonce
+}
+
+// every Write() is one frame: length + sealed data
+func (c *DbSyncConn) Write(p []byte) (int, error) {
+	sealed := c.enc.Seal(nil, c.nonce(c.enc_seq, c.enc.NonceSize()), p, nil)
+	c.enc_seq++
+
+	frame := make([]byte, 4+len(sealed))
+	binary.LittleEndian.PutUint32(frame, uint32(len(sealed)))
+	copy(frame[4:], sealed)
+
+	_, err := c.conn.Write(frame)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *DbSyncConn) Read(p []byte) (int, error) {
+	if len(c.buff) == 0 {
+		var size [4]byte
+		_, err := io.ReadFull(c.conn, size[:])
+		if err != nil {
+			return 0, err
+		}
+		n := binary.LittleEndian.Uint32(size[:])
+		if n > DbSyncConn_MAX_FRAME {
+			return 0, fmt.Errorf("frame is too big(%d bytes)", n)
+		}
+
+		sealed := make([]byte, n)
+		_, err = io.ReadFull(c.conn, sealed)
+		if err != nil {
+			return 0, err
+		}
+		c.buff, err = c.dec.Open(nil, c.nonce(c.dec_seq, c.dec.NonceSize()), sealed, nil)
+		if err != nil {
+			return 0, errors.New("frame can't be decrypted, peer isn't paired with same secret")
+		}
+		c.dec_seq++
+	}
+
+	n := copy(p, c.buff)
+	c.buff = c.buff[n:]
+	return n, nil
+}
@@ -0,0 +1,335 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DbSync_BATCH = 1000     //entries per message
+const DbSync_INTERVAL = 10000 //ms between syncs with peers
+const DbSync_TIMEOUT = 60     //seconds per session
+
+type DbSyncMsg struct {
+	Tp string //hello, pull, changes, end, error
+
+	Device string
+	Dbs    []string
+
+	Db      string
+	Since   int64
+	Entries []DbSyncEntry
+	Seq     int64
+
+	Err string
+}
+
+// Syncs databases with same name between devices. Both sides pull changes from each other in one session.
+type DbSync struct {
+	folder string
+	device string
+	skip   string //db, which is never synced(settings)
+	secret string //same on paired devices
+
+	mu     sync.Mutex //one session at the time
+	listen net.Listener
+
+	ticks   int
+	running sync.Mutex
+
+	//dbs written by sync, so main thread doesn't take them as external change
+	changed_mu sync.Mutex
+	changed    map[string]bool
+}
+
+func NewDbSync(folder string, device string, skip string, secret string) *DbSync {
+	var s DbSync
+	s.folder = folder
+	s.device = device
+	s.skip = skip
+	s.secret = secret
+	return &s
+}
+
+func (s *DbSync) markChanged(name string) {
+	s.changed_mu.Lock()
+	defer s.changed_mu.Unlock()
+
+	if s.changed == nil {
+		s.changed = make(map[string]bool)
+	}
+	s.changed[name] = true
+}
+
+// returns true if db was written by sync since last call
+func (s *DbSync) TakeChanged(name string) bool {
+	s.changed_mu.Lock()
+	defer s.changed_mu.Unlock()
+
+	found := s.changed[name]
+	delete(s.changed, name)
+	return found
+}
+
+func (s *DbSync) Destroy() {
+	if s.listen != nil {
+		s.listen.Close()
+		s.listen = nil
+	}
+}
+
+func (s *DbSync) Listen(addr string) error {
+	var err error
+	s.listen, err = net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Listen(%s) failed: %w", addr, err)
+	}
+
+	listen := s.listen
+	go func() {
+		for {
+			conn, err := listen.Accept()
+			if err != nil {
+				return //closed
+			}
+			go func() {
+				err := s.session(conn, false)
+				if err != nil {
+					fmt.Printf("sync session(%s) failed: %v\n", conn.RemoteAddr(), err)
+				}
+				conn.Close()
+			}()
+		}
+	}()
+	return nil
+}
+
+func (s *DbSync) SyncPeer(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("Dial(%s) failed: %w", addr, err)
+	}
+	defer conn.Close()
+
+	return s.session(conn, true)
+}
+
+// syncs with peers in background, returns immediately when previous sync is still running
+func (s *DbSync) Maintenance(peers []string) {
+	if len(peers) == 0 || OsIsTicksIn(s.ticks, DbSync_INTERVAL) || !s.running.TryLock() {
+		return
+	}
+	s.ticks = OsTicks()
+
+	go func() {
+		defer s.running.Unlock()
+		for _, addr := range peers {
+			err := s.SyncPeer(addr)
+			if err != nil {
+				fmt.Printf("SyncPeer(%s) failed: %v\n", addr, err)
+			}
+		}
+	}()
+}
+
+func (s *DbSync) getDbs() ([]string, error) {
+	dir, err := os.ReadDir(s.folder)
+	if err != nil {
+		return nil, fmt.Errorf("ReadDir(%s) failed: %w", s.folder, err)
+	}
+
+	var dbs []string
+	for _, file := range dir {
+		if !file.IsDir() && strings.EqualFold(filepath.Ext(file.Name()), ".sqlite") {
+			name := OsFileGetNameWithoutExt(file.Name())
+			if name != s.skip {
+				dbs = append(dbs, name)
+			}
+		}
+	}
+	sort.Strings(dbs)
+	return dbs, nil
+}
+
+func (s *DbSync) session(conn net.Conn, client bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn.SetDeadline(time.Now().Add(DbSync_TIMEOUT * time.Second))
+	sc, err := NewDbSyncConn(conn, s.secret, client)
+	if err != nil {
+		return err
+	}
+	enc := gob.NewEncoder(sc)
+	dec := gob.NewDecoder(sc)
+
+	dbs, err := s.getDbs()
+	if err != nil {
+		return err
+	}
+
+	//hello
+	err = enc.Encode(&DbSyncMsg{Tp: "hello", Device: s.device, Dbs: dbs})
+	if err != nil {
+		return fmt.Errorf("Encode() failed: %w", err)
+	}
+	var hello DbSyncMsg
+	err = dec.Decode(&hello)
+	if err != nil {
+		return fmt.Errorf("Decode() failed: %w", err)
+	}
+	if hello.Tp != "hello" {
+		return fmt.Errorf("expected 'hello', received '%s'", hello.Tp)
+	}
+	if hello.Device == s.device {
+		return errors.New("peer has same device id")
+	}
+
+	//only dbs, which exist on both sides
+	files := make(map[string]*DbSyncFile)
+	defer func() {
+		for _, f := range files {
+			f.Destroy()
+		}
+	}()
+	var common []string
+	for _, name := range dbs {
+		found := false
+		for _, it := range hello.Dbs {
+			found = found || (it == name)
+		}
+		if !found {
+			continue
+		}
+		name := name
+		f, err := NewDbSyncFile(s.folder+"/"+name+".sqlite", name, s.device, func() { s.markChanged(name) })
+		if err != nil {
+			return err
+		}
+		files[name] = f
+		common = append(common, name)
+	}
+
+	if client {
+		err = s.pull(enc, dec, hello.Device, common, files)
+		if err == nil {
+			err = s.serve(enc, dec, files)
+		}
+	} else {
+		err = s.serve(enc, dec, files)
+		if err == nil {
+			err = s.pull(enc, dec, hello.Device, common, files)
+		}
+	}
+	return err
+}
+
+func (s *DbSync) pull(enc *gob.Encoder, dec *gob.Decoder, peer string, common []string, files map[string]*DbSyncFile) error {
+	for _, name := range common {
+		f := files[name]
+		since, err := f.GetPeerSeq(peer)
+		if err != nil {
+			return err
+		}
+
+		for {
+			err = enc.Encode(&DbSyncMsg{Tp: "pull", Db: name, Since: since})
+			if err != nil {
+				return fmt.Errorf("Encode() failed: %w", err)
+			}
+
+			var msg DbSyncMsg
+			err = dec.Decode(&msg)
+			if err != nil {
+				return fmt.Errorf("Decode() failed: %w", err)
+			}
+			if msg.Tp == "error" {
+				return fmt.Errorf("peer failed: %s", msg.Err)
+			}
+			if msg.Tp != "changes" {
+				return fmt.Errorf("expected 'changes', received '%s'", msg.Tp)
+			}
+
+			if len(msg.Entries) > 0 {
+				err = f.Apply(peer, msg.Entries, msg.Seq)
+				if err != nil {
+					return err
+				}
+			}
+			since = msg.Seq
+
+			if len(msg.Entries) < DbSync_BATCH {
+				break
+			}
+		}
+	}
+
+	err := enc.Encode(&DbSyncMsg{Tp: "end"})
+	if err != nil {
+		return fmt.Errorf("Encode() failed: %w", err)
+	}
+	return nil
+}
+
+func (s *DbSync) serve(enc *gob.Encoder, dec *gob.Decoder, files map[string]*DbSyncFile) error {
+	for {
+		var msg DbSyncMsg
+		err := dec.Decode(&msg)
+		if err != nil {
+			return fmt.Errorf("Decode() failed: %w", err)
+		}
+
+		switch msg.Tp {
+		case "end":
+			return nil
+
+		case "pull":
+			f, found := files[msg.Db]
+			var entries []DbSyncEntry
+			if found {
+				entries, err = f.GetChanges(msg.Since, DbSync_BATCH)
+			} else {
+				err = fmt.Errorf("db(%s) not found", msg.Db)
+			}
+			if err != nil {
+				enc.Encode(&DbSyncMsg{Tp: "error", Err: err.Error()})
+				return err
+			}
+
+			seq := msg.Since
+			if len(entries) > 0 {
+				seq = entries[len(entries)-1].Seq
+			}
+			err = enc.Encode(&DbSyncMsg{Tp: "changes", Db: msg.Db, Entries: entries, Seq: seq})
+			if err != nil {
+				return fmt.Errorf("Encode() failed: %w", err)
+			}
+
+		default:
+			return fmt.Errorf("unexpected message '%s'", msg.Tp)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+const testSyncSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestSyncRoot(t *testing.T, device string, secret string) *Root {
+	t.Helper()
+
+	root := &Root{folderDatabases: t.TempDir(), ctx: context.Background(), dbs: make(map[string]*Db)}
+	root.sync = NewDbSync(root.folderDatabases, device, "settings", secret)
+	t.Cleanup(func() {
+		root.sync.Destroy()
+		for _, db := range root.dbs {
+			db.Destroy()
+		}
+	})
+
+	db, err := root.AddDb("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWrite(t, db, "CREATE TABLE notes(title TEXT)")
+	testCommit(t, db)
+	return root
+}
+
+func TestSyncTwoRoots(t *testing.T) {
+	a := newTestSyncRoot(t, "a", testSyncSecret)
+	b := newTestSyncRoot(t, "b", testSyncSecret)
+
+	testWrite(t, a.dbs["notes"], "INSERT INTO notes VALUES('from a')")
+	testCommit(t, a.dbs["notes"])
+	testWrite(t, b.dbs["notes"], "INSERT INTO notes VALUES('from b')")
+	testCommit(t, b.dbs["notes"])
+
+	err := a.sync.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.sync.listen.Addr().String()
+
+	err = b.sync.SyncPeer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range []*Root{a, b} {
+		if n := testCount(t, root.dbs["notes"], "SELECT COUNT(*) FROM notes"); n != 2 {
+			t.Fatalf("device has %d rows", n)
+		}
+	}
+	if !b.sync.TakeChanged("notes") {
+		t.Fatal("sync write isn't marked as own change")
+	}
+
+	//second session receives echo of own entries, third one has nothing to install or apply
+	for i := 0; i < 2; i++ {
+		b.sync.TakeChanged("notes")
+		err = b.sync.SyncPeer(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.sync.TakeChanged("notes") {
+		t.Fatal("unchanged file was written")
+	}
+
+	//not paired
+	c := newTestSyncRoot(t, "c", "fedcba9876543210fedcba9876543210")
+	err = c.sync.SyncPeer(addr)
+	if err == nil {
+		t.Fatal("device with other secret was synced")
+	}
+}
+
+func testSyncStr(t *testing.T, db *Db, query string) string {
+	t.Helper()
+
+	var str string
+	err := db.db.QueryRow(query).Scan(&str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return str
+}
+
+func TestSyncConflicts(t *testing.T) {
+	a := newTestSyncRoot(t, "a", testSyncSecret)
+	b := newTestSyncRoot(t, "b", testSyncSecret)
+
+	err := a.sync.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := a.sync.listen.Addr().String()
+
+	testWrite(t, a.dbs["notes"], "INSERT INTO notes VALUES('first')")
+	testCommit(t, a.dbs["notes"])
+	err = b.sync.SyncPeer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//both devices edit same row
+	testWrite(t, a.dbs["notes"], "UPDATE notes SET title='from a'")
+	testCommit(t, a.dbs["notes"])
+	testWrite(t, b.dbs["notes"], "UPDATE notes SET title='from b'")
+	testCommit(t, b.dbs["notes"])
+	err = b.sync.SyncPeer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//same winner on both devices
+	titleA := testSyncStr(t, a.dbs["notes"], "SELECT title FROM notes")
+	titleB := testSyncStr(t, b.dbs["notes"], "SELECT title FROM notes")
+	if titleA != titleB || (titleA != "from a" && titleA != "from b") {
+		t.Fatalf("devices have '%s' and '%s'", titleA, titleB)
+	}
+	for _, root := range []*Root{a, b} {
+		if n := testCount(t, root.dbs["notes"], "SELECT COUNT(*) FROM notes"); n != 1 {
+			t.Fatalf("device has %d rows", n)
+		}
+	}
+
+	//deleted row stays deleted after other device edits it
+	testWrite(t, a.dbs["notes"], "DELETE FROM notes")
+	testCommit(t, a.dbs["notes"])
+	testWrite(t, b.dbs["notes"], "UPDATE notes SET title='edited'")
+	testCommit(t, b.dbs["notes"])
+	err = b.sync.SyncPeer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range []*Root{a, b} {
+		if n := testCount(t, root.dbs["notes"], "SELECT COUNT(*) FROM notes"); n != 0 {
+			t.Fatalf("device has %d rows", n)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func newTestDb(t *testing.T) *Db {
+	t.Helper()
+
+	root := &Root{folderDatabases: t.TempDir(), ctx: context.Background()}
+	db, err := NewDb(root, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Destroy() })
+	return db
+}
+
+func testWrite(t *testing.T, db *Db, query string, params ...any) {
+	t.Helper()
+
+	_, err := db.Write(query, params...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testCommit(t *testing.T, db *Db) {
+	t.Helper()
+
+	err := db.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArgsToArray(t *testing.T) {
+	data := _stringToParams(nil, "text")
+	data = _argsToArray(data, []byte{1, 2})
+	data = _argsToArray(data, int64(-3))
+	data = _argsToArray(data, 1.5)
+
+	if data[0] != TpString {
+		t.Fatalf("string param is encoded as %x", data[0])
+	}
+
+	//apps compiled with older SDK read strings as bytes
+	if str := _argsToArray(nil, "text"); str[0] != TpBytes {
+		t.Fatalf("string is encoded as %x", str[0])
+	}
+
+	params, err := _arrayToParams(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params[0] != "text" || string(params[1].([]byte)) != "\x01\x02" || params[2] != int64(-3) || params[3] != 1.5 {
+		t.Fatalf("wrong params: %v", params)
+	}
+}
+
+func TestCacheKeepsParams(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE t(a INTEGER, b TEXT)")
+	testWrite(t, db, "INSERT INTO t VALUES(1, 'one'), (2, 'two')")
+	testCommit(t, db)
+
+	query := "SELECT b FROM t WHERE a=?"
+	cache, err := db.AddCache(query, _argsToArray(nil, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := cache.query_hash
+
+	//commit resets cache, app reads it by hash again
+	testWrite(t, db, "INSERT INTO t VALUES(3, 'three')")
+	testCommit(t, db)
+	if db.FindCache(hash) != nil {
+		t.Fatal("cache wasn't reset")
+	}
+
+	cache, err = db.GetCache(query, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.query_hash != hash {
+		t.Fatal("cache was added with different params")
+	}
+
+	row, err := db.GetRow(cache, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := _arrayToParams(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 1 || vals[0] != "two" {
+		t.Fatalf("wrong row: %v", vals)
+	}
+}
+
+func TestCacheResetTables(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE a(x INTEGER)")
+	testWrite(t, db, "CREATE TABLE b(y INTEGER)")
+	testCommit(t, db)
+
+	cacheA, err := db.AddCache("SELECT x FROM a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheB, err := db.AddCache("SELECT y FROM b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//write into 'a' keeps query which reads only 'b'
+	testWrite(t, db, "INSERT INTO a VALUES(1)")
+	testCommit(t, db)
+	if db.FindCache(cacheA.query_hash) != nil {
+		t.Fatal("query on changed table wasn't reset")
+	}
+	if db.FindCache(cacheB.query_hash) != cacheB {
+		t.Fatal("query on other table was reset")
+	}
+
+	//schema change resets everything
+	testWrite(t, db, "CREATE TABLE c(z INTEGER)")
+	testCommit(t, db)
+	if db.FindCache(cacheB.query_hash) != nil {
+		t.Fatal("query wasn't reset after schema change")
+	}
+}
+
+func testRowInt(t *testing.T, db *Db, cache *DbCache, row_i int) int64 {
+	t.Helper()
+
+	row, err := db.GetRow(cache, row_i)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := _arrayToParams(row)
+	if err != nil || len(vals) == 0 {
+		t.Fatalf("row %d: %v %v", row_i, vals, err)
+	}
+	return vals[0].(int64)
+}
+
+func TestCacheWindows(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE t(a INTEGER)")
+	testWrite(t, db, "WITH RECURSIVE c(x) AS (SELECT 0 UNION ALL SELECT x+1 FROM c WHERE x<999) INSERT INTO t SELECT x FROM c")
+	testCommit(t, db)
+
+	cache, err := db.AddCache("SELECT a FROM t ORDER BY a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range []int{700, 701, 10, 999, 300} {
+		if v := testRowInt(t, db, cache, i); v != int64(i) {
+			t.Fatalf("row %d has value %d", i, v)
+		}
+	}
+
+	n, err := db.GetRowCount(cache)
+	if err != nil || n != 1000 {
+		t.Fatalf("row count %d %v", n, err)
+	}
+	row, err := db.GetRow(cache, 1000)
+	if err != nil || row != nil {
+		t.Fatalf("row after end: %v %v", row, err)
+	}
+
+	//windows don't keep rows open between reads
+	if n := db.db.Stats().InUse; n != 0 {
+		t.Fatalf("%d connections are held", n)
+	}
+}
+
+func TestCacheWindowsNotPageable(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE t(a INTEGER, b TEXT)")
+	testCommit(t, db)
+
+	cache, err := db.AddCache("PRAGMA table_info(t)", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := db.GetRow(cache, 1)
+	if err != nil || row == nil {
+		t.Fatalf("second row: %v %v", row, err)
+	}
+	n, err := db.GetRowCount(cache)
+	if err != nil || n != 2 {
+		t.Fatalf("row count %d %v", n, err)
+	}
+}
+
+func TestCacheColumns(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE t(id INTEGER, name TEXT, price REAL)")
+	testCommit(t, db)
+
+	//table is empty, columns are known anyway
+	cache, err := db.AddCache("SELECT name, price FROM t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := cache.GetColumns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := _arrayToParams(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 6 {
+		t.Fatalf("wrong columns: %v", vals)
+	}
+	if testStr(vals[0]) != "name" || testStr(vals[1]) != "TEXT" || testStr(vals[3]) != "price" || testStr(vals[4]) != "REAL" {
+		t.Fatalf("wrong columns: %v", vals)
+	}
+}
+
+func testCount(t *testing.T, db *Db, query string) int {
+	t.Helper()
+
+	var n int
+	err := db.db.QueryRow(query).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestUndoReplay(t *testing.T) {
+	if !DbUndo_ENABLED {
+		t.Skip("build without 'sqlite_preupdate_hook' tag")
+	}
+	db := newTestDb(t)
+	appA := &App{name: "a"}
+	appB := &App{name: "b"}
+
+	testWrite(t, db, "CREATE TABLE t(a INTEGER PRIMARY KEY, b TEXT)")
+	testWrite(t, db, "CREATE TABLE u(a INTEGER PRIMARY KEY)")
+	testWrite(t, db, "CREATE TABLE log(a INTEGER)")
+	testWrite(t, db, "CREATE TRIGGER t_log AFTER INSERT ON t BEGIN INSERT INTO log VALUES(new.a); END")
+	testCommit(t, db)
+
+	db.policy_app = appA
+	testWrite(t, db, "INSERT INTO t VALUES(1, 'one'), (2, 'two')")
+	_, err := db.Write("INSERT INTO t VALUES(3, 'three'), (1, 'dup')")
+	if err == nil {
+		t.Fatal("duplicate key was inserted")
+	}
+	db.policy_app = appB
+	testWrite(t, db, "INSERT INTO u VALUES(1)")
+	db.policy_app = nil
+	testCommit(t, db)
+
+	//failed statement and trigger's changes aren't recorded
+	if len(db.undo.undo) != 2 || len(db.undo.undo[0].changes) != 2 {
+		t.Fatalf("wrong undo steps: %d", len(db.undo.undo))
+	}
+
+	ok, err := db.Undo(appA)
+	if err != nil || !ok {
+		t.Fatalf("Undo() failed: %v", err)
+	}
+	if testCount(t, db, "SELECT COUNT(*) FROM t") != 0 || testCount(t, db, "SELECT COUNT(*) FROM u") != 1 {
+		t.Fatal("undo changed wrong rows")
+	}
+
+	ok, err = db.Redo(appA)
+	if err != nil || !ok {
+		t.Fatalf("Redo() failed: %v", err)
+	}
+	if testCount(t, db, "SELECT COUNT(*) FROM t") != 2 || testCount(t, db, "SELECT COUNT(*) FROM log") != 4 {
+		t.Fatal("redo didn't replay rows")
+	}
+
+	ok, _ = db.Undo(&App{name: "c"})
+	if ok {
+		t.Fatal("other app's step was undone")
+	}
+}
+
+func TestUndoPrimaryKey(t *testing.T) {
+	if !DbUndo_ENABLED {
+		t.Skip("build without 'sqlite_preupdate_hook' tag")
+	}
+	db := newTestDb(t)
+	app := &App{name: "a"}
+
+	testWrite(t, db, "CREATE TABLE k(name TEXT PRIMARY KEY, v INTEGER)")
+	testWrite(t, db, "CREATE TABLE w(name TEXT PRIMARY KEY, v INTEGER) WITHOUT ROWID")
+	testWrite(t, db, "INSERT INTO k VALUES('x', 1)")
+	testCommit(t, db)
+
+	db.policy_app = app
+	testWrite(t, db, "UPDATE k SET v=2 WHERE name='x'")
+	db.policy_app = nil
+	testCommit(t, db)
+
+	//rowid is changed by something, which isn't recorded(VACUUM)
+	db.undo.off = true
+	testWrite(t, db, "UPDATE k SET rowid=rowid+100")
+	testCommit(t, db)
+	db.undo.off = false
+
+	ok, err := db.Undo(app)
+	if err != nil || !ok {
+		t.Fatalf("Undo() failed: %v", err)
+	}
+	if testCount(t, db, "SELECT v FROM k WHERE name='x'") != 1 {
+		t.Fatal("row wasn't found by primary key")
+	}
+
+	//WITHOUT ROWID change can't be undone
+	db.policy_app = app
+	testWrite(t, db, "INSERT INTO w VALUES('y', 1)")
+	db.policy_app = nil
+	testCommit(t, db)
+	if len(db.undo.undo) != 0 || len(db.undo.redo) != 0 {
+		t.Fatal("history wasn't cleared")
+	}
+}
+
+func TestHistoryRebuild(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE t(a INTEGER PRIMARY KEY, b TEXT)")
+	err := db.SetHistory("t", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCommit(t, db)
+
+	//new column is tracked after commit
+	testWrite(t, db, "ALTER TABLE t ADD COLUMN c TEXT")
+	testCommit(t, db)
+	testWrite(t, db, "INSERT INTO t VALUES(1, 'one', 'x')")
+	testWrite(t, db, "UPDATE t SET c='y' WHERE a=1")
+	testCommit(t, db)
+
+	if testCount(t, db, "SELECT COUNT(*) FROM _history_t WHERE c='x'") != 1 {
+		t.Fatal("history doesn't track new column")
+	}
+	cols, enabled, err := db.getHistoryColumns("t")
+	if err != nil || !enabled || cols != `"a", "b", "c"` {
+		t.Fatalf("wrong history columns: %s, %v", cols, err)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DbTrash_FOLDER = "trash"                   //inside folderDatabases
+const DbTrash_MAX_AGE = 30 * 24 * 60 * 60 * 1000 //ms, older items are purged
+const DbTrash_FORMAT = "2006-01-02_15-04-05.000"
+
+// Saved as meta.json next to removed db files
+type DbTrashMeta struct {
+	Name     string
+	Time     int64 //ms
+	Settings []DbSettingsRow
+}
+
+func (root *Root) getTrashFolder() string {
+	return root.folderDatabases + "/" + DbTrash_FOLDER
+}
+
+func (root *Root) getTrashItemFolder(id string) (string, error) {
+	if len(id) == 0 || strings.ContainsRune(id, '/') || strings.ContainsRune(id, '\\') || strings.Contains(id, "..") {
+		return "", fmt.Errorf("trash item(%s) is invalid", id)
+	}
+	return root.getTrashFolder() + "/" + id, nil
+}
+
+// returns ids sorted from oldest
+func (root *Root) GetTrash() []string {
+	dir, err := os.ReadDir(root.getTrashFolder())
+	if err != nil {
+		return nil
+	}
+
+	var list []string
+	for _, file := range dir {
+		if file.IsDir() {
+			list = append(list, file.Name())
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (root *Root) GetTrashMeta(id string) (*DbTrashMeta, error) {
+	folder, err := root.getTrashItemFolder(id)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := os.ReadFile(folder + "/meta.json")
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile(%s) failed: %w", folder, err)
+	}
+
+	var meta DbTrashMeta
+	err = json.Unmarshal(js, &meta)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshal(%s) failed: %w", folder, err)
+	}
+	return &meta, nil
+}
+
+// settings rows of apps, which were opened with db
+func (root *Root) getDbSettingsRows(name string) []DbSettingsRow {
+	rows, err := root.settings.GetRowsDb(name)
+	if err != nil {
+		fmt.Printf("GetRowsDb(%s) failed: %v\n", name, err)
+	}
+	return rows
+}
+
+// Moves db files into trash. Db must be closed.
+func (root *Root) TrashDb(name string) (string, error) {
+	path := root.folderDatabases + "/" + name + ".sqlite"
+	if !OsFileExists(path) {
+		return "", fmt.Errorf("db(%s) not exist", name)
+	}
+
+	tm := time.Now()
+	id := tm.Format(DbTrash_FORMAT) + "_" + name
+	folder := root.getTrashFolder() + "/" + id
+	err := os.MkdirAll(folder, 0700)
+	if err != nil {
+		return "", fmt.Errorf("MkdirAll(%s) failed: %w", folder, err)
+	}
+
+	meta := DbTrashMeta{Name: name, Time: tm.UnixMilli(), Settings: root.getDbSettingsRows(name)}
+	js, err := json.MarshalIndent(&meta, "", "")
+	if err != nil {
+		return "", fmt.Errorf("MarshalIndent() failed: %w", err)
+	}
+	err = os.WriteFile(folder+"/meta.json", js, 0644)
+	if err != nil {
+		return "", fmt.Errorf("WriteFile(%s) failed: %w", folder, err)
+	}
+
+	//main file last, so db stays complete when moving fails
+	for _, ext := range []string{"-wal", "-shm", ""} {
+		if OsFileExists(path + ext) {
+			err = OsFileRename(path+ext, folder+"/"+name+".sqlite"+ext)
+			if err != nil {
+				return "", fmt.Errorf("OsFileRename(%s) failed: %w", path+ext, err)
+			}
+		}
+	}
+
+	return id, nil
+}
+
+// Moves db back from trash and adds its settings rows
+func (root *Root) RestoreTrash(id string) error {
+	meta, err := root.GetTrashMeta(id)
+	if err != nil {
+		return err
+	}
+	folder, _ := root.getTrashItemFolder(id)
+
+	path := root.folderDatabases + "/" + meta.Name + ".sqlite"
+	if OsFileExists(path) {
+		return fmt.Errorf("db(%s) already exist", meta.Name)
+	}
+
+	//main file first, WAL without it is useless
+	for _, ext := range []string{"", "-wal", "-shm"} {
+		src := folder + "/" + meta.Name + ".sqlite" + ext
+		if OsFileExists(src) {
+			err = OsFileRename(src, path+ext)
+			if err != nil {
+				return fmt.Errorf("OsFileRename(%s) failed: %w", src, err)
+			}
+		}
+	}
+
+	err = root.settings.AddRows(meta.Settings, false)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(folder)
+	if err != nil {
+		fmt.Printf("RemoveAll(%s) failed: %v\n", folder, err)
+	}
+
+	root.updateDbsList()
+	return nil
+}
+
+// Removes item permanently. Empty 'id' purges whole trash.
+func (root *Root) PurgeTrash(id string) error {
+	if len(id) == 0 {
+		for _, it := range root.GetTrash() {
+			err := root.PurgeTrash(it)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	folder, err := root.getTrashItemFolder(id)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(folder)
+	if err != nil {
+		return fmt.Errorf("RemoveAll(%s) failed: %w", folder, err)
+	}
+	return nil
+}
+
+// purges items older than DbTrash_MAX_AGE
+func (root *Root) MaintenanceTrash() {
+	now := time.Now().UnixMilli()
+	for _, id := range root.GetTrash() {
+		meta, err := root.GetTrashMeta(id)
+		if err != nil {
+			continue //keep it, user can purge it manually
+		}
+		if now-meta.Time > DbTrash_MAX_AGE {
+			err := root.PurgeTrash(id)
+			if err != nil {
+				fmt.Printf("PurgeTrash(%s) failed: %v\n", id, err)
+			}
+		}
+	}
+}
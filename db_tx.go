@@ -0,0 +1,208 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const DbTx_MAX_FRAMES = 1 //explicit transaction holds write lock, so it's rolled back when it isn't committed in next frame
+
+// Explicit transaction opened by app. It's a savepoint inside Db.tx, so Root.CommitDbs()
+// doesn't commit Db until asset calls commit or rollback. Only one asset can own it.
+// Other assets read committed data through other connections, but they can't write until it's finished.
+type DbTx struct {
+	asset *Asset
+	frame int64 //Root.frame at begin
+
+	savepoints []string
+
+	undo_start      int   //DbUndo.mark() at begin
+	undo_savepoints []int //DbUndo.mark() for every savepoint
+}
+
+const DbTx_SAVEPOINT = "_sa_tx"
+
+func DbTx_quoteName(name string) string {
+	return "\"_sa_" + strings.ReplaceAll(name, "\"", "\"\"") + "\""
+}
+
+func (db *Db) exec(query string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(query)
+	if err != nil {
+		return fmt.Errorf("query(%s) failed: %w", query, err)
+	}
+	return nil
+}
+
+func (db *Db) checkExplicit(asset *Asset) error {
+	if db.explicit == nil {
+		return fmt.Errorf("db(%s) has no transaction", db.name)
+	}
+	if db.explicit.asset != asset {
+		return fmt.Errorf("db(%s) transaction is owned by asset(%s)", db.name, db.explicit.asset.name)
+	}
+	return nil
+}
+
+// returns error when other asset owns transaction
+func (db *Db) CanWrite(asset *Asset) error {
+	if db.explicit != nil && db.explicit.asset != asset {
+		return fmt.Errorf("db(%s) is locked by transaction of asset(%s)", db.name, db.explicit.asset.name)
+	}
+	return nil
+}
+
+func (db *Db) BeginExplicit(asset *Asset) error {
+	if db == db.root.settings.db {
+		return errors.New("transactions are not allowed in settings db")
+	}
+	if db.explicit != nil {
+		if db.explicit.asset == asset {
+			return fmt.Errorf("db(%s) transaction already started", db.name)
+		}
+		return db.CanWrite(asset)
+	}
+
+	err := db.exec("SAVEPOINT " + DbTx_SAVEPOINT)
+	if err != nil {
+		return err
+	}
+
+	db.explicit = &DbTx{asset: asset, frame: db.root.frame, undo_start: db.undo.mark()}
+	return nil
+}
+
+func (db *Db) CommitExplicit(asset *Asset) error {
+	err := db.checkExplicit(asset)
+	if err != nil {
+		return err
+	}
+
+	err = db.exec("RELEASE " + DbTx_SAVEPOINT)
+	db.explicit = nil
+	return err //Db.tx is committed at the end of tick
+}
+
+func (db *Db) RollbackExplicit(asset *Asset) error {
+	err := db.checkExplicit(asset)
+	if err != nil {
+		return err
+	}
+
+	err = db.exec("ROLLBACK TO " + DbTx_SAVEPOINT)
+	if err == nil {
+		err = db.exec("RELEASE " + DbTx_SAVEPOINT)
+		db.undo.truncate(db.explicit.undo_start)
+	}
+	db.writer_set = false //could be rolled back
+	db.explicit = nil
+	return err
+}
+
+func (db *Db) Savepoint(asset *Asset, name string) error {
+	err := db.checkExplicit(asset)
+	if err != nil {
+		return err
+	}
+	if len(name) == 0 {
+		return errors.New("savepoint name is empty")
+	}
+
+	err = db.exec("SAVEPOINT " + DbTx_quoteName(name))
+	if err != nil {
+		return err
+	}
+	db.explicit.savepoints = append(db.explicit.savepoints, name)
+	db.explicit.undo_savepoints = append(db.explicit.undo_savepoints, db.undo.mark())
+	return nil
+}
+
+func (db *Db) findSavepoint(name string) int {
+	for i := len(db.explicit.savepoints) - 1; i >= 0; i-- {
+		if db.explicit.savepoints[i] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (db *Db) Release(asset *Asset, name string) error {
+	err := db.checkExplicit(asset)
+	if err != nil {
+		return err
+	}
+	i := db.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("savepoint(%s) not found", name)
+	}
+
+	err = db.exec("RELEASE " + DbTx_quoteName(name))
+	if err != nil {
+		return err
+	}
+	db.explicit.savepoints = db.explicit.savepoints[:i] //release also inner savepoints
+	db.explicit.undo_savepoints = db.explicit.undo_savepoints[:i]
+	return nil
+}
+
+func (db *Db) RollbackTo(asset *Asset, name string) error {
+	err := db.checkExplicit(asset)
+	if err != nil {
+		return err
+	}
+	i := db.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("savepoint(%s) not found", name)
+	}
+
+	err = db.exec("ROLLBACK TO " + DbTx_quoteName(name))
+	if err != nil {
+		return err
+	}
+	db.explicit.savepoints = db.explicit.savepoints[:i+1] //savepoint stays active
+	db.explicit.undo_savepoints = db.explicit.undo_savepoints[:i+1]
+	db.undo.truncate(db.explicit.undo_savepoints[i])
+	db.writer_set = false //could be rolled back
+	return nil
+}
+
+// Rolls back transaction, which wasn't committed in next frame. Returns true if Db.tx can be committed.
+func (db *Db) MaintenanceExplicit() bool {
+	if db.explicit == nil {
+		return true
+	}
+
+	if db.root.frame-db.explicit.frame >= DbTx_MAX_FRAMES {
+		asset := db.explicit.asset
+		err := db.RollbackExplicit(asset)
+		if err != nil {
+			fmt.Printf("RollbackExplicit(%s) failed: %v\n", db.name, err)
+		}
+		asset.AddLogErr(fmt.Errorf("db(%s) transaction was rolled back, because it wasn't committed in next frame", db.name))
+		return true
+	}
+
+	return false
+}
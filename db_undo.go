@@ -0,0 +1,490 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const Db_UNDO_MAX_STEPS = 100      //committed ticks per db
+const Db_UNDO_MAX_CHANGES = 100000 //rows per step, bigger step clears history
+
+// One row change recorded by preupdate hook
+type DbChange struct {
+	op    int //sqlite3.SQLITE_INSERT/UPDATE/DELETE
+	table string
+	app   *App //nil = host
+
+	old_rowid int64
+	new_rowid int64
+	old       []interface{}
+	new       []interface{}
+}
+
+// Changeset of one app in one committed tick
+type DbUndoStep struct {
+	app     *App
+	changes []DbChange
+	tables  map[string]bool
+	ticks   int
+}
+
+// returns true if steps changed same table
+func (step *DbUndoStep) overlaps(other *DbUndoStep) bool {
+	for t := range step.tables {
+		if other.tables[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// Columns of changed table. Rows are found by primary key, rowid is used only by tables without it.
+type DbUndoTable struct {
+	cols          []string //quoted names
+	pk            []int    //indexes of primary key columns
+	without_rowid bool
+}
+
+type DbUndo struct {
+	changes  []DbChange //current transaction
+	overflow bool       //too many changes in current transaction
+	off      bool       //undo/redo or migration is running
+
+	undo []*DbUndoStep
+	redo []*DbUndoStep
+
+	tables map[string]*DbUndoTable //reset with history, when schema changes
+}
+
+func DbUndo_skipTable(table string) bool {
+	table = strings.ToLower(table)
+	return strings.HasPrefix(table, "_sa_") || strings.HasPrefix(table, "sqlite_") || strings.HasPrefix(table, DbHistory_PREFIX)
+}
+
+func DbUndo_quoteName(name string) string {
+	return "\"" + strings.ReplaceAll(name, "\"", "\"\"") + "\""
+}
+
+func (u *DbUndo) Clear() {
+	u.changes = nil
+	u.overflow = false
+	u.undo = nil
+	u.redo = nil
+	u.tables = nil
+}
+
+func (u *DbUndo) add(ch DbChange) {
+	if u.off || u.overflow || DbUndo_skipTable(ch.table) {
+		return
+	}
+	if len(u.changes) >= Db_UNDO_MAX_CHANGES {
+		u.overflow = true
+		u.changes = nil
+		return
+	}
+	u.changes = append(u.changes, ch)
+}
+
+// returns length, which can be used by truncate() when savepoint is rolled back
+// current transaction was rolled back
+func (u *DbUndo) rollback() {
+	u.changes = nil
+	u.overflow = false
+}
+
+func (u *DbUndo) mark() int {
+	return len(u.changes)
+}
+
+func (u *DbUndo) truncate(pos int) {
+	if pos < len(u.changes) {
+		u.changes = u.changes[:pos]
+	}
+}
+
+// moves current transaction into undo stack. Every app gets own step.
+func (u *DbUndo) commit(schemaChanged bool) {
+	if schemaChanged || u.overflow {
+		u.Clear() //old changes can't be applied on new schema
+		return
+	}
+	if len(u.changes) == 0 {
+		return
+	}
+
+	var steps []*DbUndoStep
+	for _, ch := range u.changes {
+		var step *DbUndoStep
+		for _, it := range steps {
+			if it.app == ch.app {
+				step = it
+				break
+			}
+		}
+		if step == nil {
+			step = &DbUndoStep{app: ch.app, tables: make(map[string]bool), ticks: OsTicks()}
+			steps = append(steps, step)
+		}
+		step.changes = append(step.changes, ch)
+		step.tables[strings.ToLower(ch.table)] = true
+	}
+
+	u.undo = append(u.undo, steps...)
+	if len(u.undo) > Db_UNDO_MAX_STEPS {
+		u.undo = u.undo[len(u.undo)-Db_UNDO_MAX_STEPS:]
+	}
+	u.redo = nil
+	u.changes = nil
+}
+
+// returns position of app's last step in stack or -1. Step can't be used when later step of other app changed same table.
+func DbUndo_findStep(stack []*DbUndoStep, app *App) int {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].app != app {
+			continue
+		}
+		for _, it := range stack[i+1:] {
+			if it.overlaps(stack[i]) {
+				return -1
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+func (db *Db) getTableColumns(table string) ([]string, error) {
+	rows, err := db.tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, fmt.Errorf("table_info(%s) failed: %w", table, err)
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, fmt.Errorf("Scan() failed: %w", err)
+		}
+		cols = append(cols, DbUndo_quoteName(name))
+	}
+	return cols, rows.Err()
+}
+
+func (db *Db) getUndoTable(table string) (*DbUndoTable, error) {
+	if t := db.undo.tables[table]; t != nil {
+		return t, nil
+	}
+	defer db.hostPolicy()()
+
+	rows, err := db.tx.Query("SELECT name, pk FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, fmt.Errorf("table_info(%s) failed: %w", table, err)
+	}
+	defer rows.Close()
+
+	var t DbUndoTable
+	var pks [][2]int //position in key, column
+	for rows.Next() {
+		var name string
+		var pk int
+		err := rows.Scan(&name, &pk)
+		if err != nil {
+			return nil, fmt.Errorf("Scan() failed: %w", err)
+		}
+		if pk > 0 {
+			pks = append(pks, [2]int{pk, len(t.cols)})
+		}
+		t.cols = append(t.cols, DbUndo_quoteName(name))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(pks, func(i, j int) bool { return pks[i][0] < pks[j][0] })
+	for _, it := range pks {
+		t.pk = append(t.pk, it[1])
+	}
+
+	err = db.tx.QueryRow("SELECT wr FROM pragma_table_list WHERE schema='main' AND name=?", table).Scan(&t.without_rowid)
+	if err != nil {
+		return nil, fmt.Errorf("table_list(%s) failed: %w", table, err)
+	}
+
+	if db.undo.tables == nil {
+		db.undo.tables = make(map[string]*DbUndoTable)
+	}
+	db.undo.tables[table] = &t
+	return &t, nil
+}
+
+// WITHOUT ROWID tables have no rowid in preupdate hook, so transaction, which changed them, can't be undone. It clears history like too big one.
+func (db *Db) checkUndoTables() {
+	if db.undo.overflow || db.tx_all {
+		return //history is cleared anyway
+	}
+	for _, ch := range db.undo.changes {
+		t, err := db.getUndoTable(ch.table)
+		if err != nil || t.without_rowid {
+			db.undo.overflow = true
+			db.undo.changes = nil
+			return
+		}
+	}
+}
+
+// returns WHERE and its params, which find row by primary key or rowid
+func (t *DbUndoTable) where(rowid int64, values []interface{}) (string, []interface{}) {
+	if len(t.pk) == 0 {
+		return " WHERE rowid=?", []interface{}{rowid}
+	}
+
+	var conds []string
+	var params []interface{}
+	for _, i := range t.pk {
+		conds = append(conds, t.cols[i]+"=?")
+		params = append(params, values[i])
+	}
+	return " WHERE " + strings.Join(conds, " AND "), params
+}
+
+func (db *Db) insertRow(table string, t *DbUndoTable, rowid int64, values []interface{}) error {
+	if len(values) != len(t.cols) {
+		return fmt.Errorf("table(%s) has different columns", table)
+	}
+
+	cols := t.cols
+	params := values
+	if len(t.pk) == 0 {
+		cols = append([]string{"rowid"}, cols...) //row can be found only by it
+		params = append([]interface{}{rowid}, params...)
+	}
+
+	query := "INSERT INTO " + DbUndo_quoteName(table) + "(" + strings.Join(cols, ", ") + ") VALUES(?" + strings.Repeat(", ?", len(cols)-1) + ")"
+	_, err := db.Write(query, params...)
+	return err
+}
+
+func (db *Db) updateRow(table string, t *DbUndoTable, rowid int64, values []interface{}, new_rowid int64, new_values []interface{}) error {
+	if len(values) != len(t.cols) || len(new_values) != len(t.cols) {
+		return fmt.Errorf("table(%s) has different columns", table)
+	}
+
+	var sets []string
+	var params []interface{}
+	if len(t.pk) == 0 {
+		sets = append(sets, "rowid=?")
+		params = append(params, new_rowid)
+	}
+	for i, c := range t.cols {
+		sets = append(sets, c+"=?")
+		params = append(params, new_values[i])
+	}
+
+	where, whereParams := t.where(rowid, values)
+	_, err := db.Write("UPDATE "+DbUndo_quoteName(table)+" SET "+strings.Join(sets, ", ")+where, append(params, whereParams...)...)
+	return err
+}
+
+func (db *Db) deleteRow(table string, t *DbUndoTable, rowid int64, values []interface{}) error {
+	if len(values) != len(t.cols) {
+		return fmt.Errorf("table(%s) has different columns", table)
+	}
+
+	where, params := t.where(rowid, values)
+	_, err := db.Write("DELETE FROM "+DbUndo_quoteName(table)+where, params...)
+	return err
+}
+
+// applies inverse(undo=true) or original(undo=false) changes
+func (db *Db) applyChanges(changes []DbChange, undo bool) error {
+	for i := range changes {
+		ch := &changes[i]
+		if undo {
+			ch = &changes[len(changes)-1-i]
+		}
+
+		t, err := db.getUndoTable(ch.table)
+		if err != nil {
+			return err
+		}
+		if t.without_rowid {
+			return fmt.Errorf("table(%s) is WITHOUT ROWID, its changes can't be replayed", ch.table)
+		}
+
+		switch ch.op {
+		case sqlite3.SQLITE_INSERT:
+			if undo {
+				err = db.deleteRow(ch.table, t, ch.new_rowid, ch.new)
+			} else {
+				err = db.insertRow(ch.table, t, ch.new_rowid, ch.new)
+			}
+		case sqlite3.SQLITE_DELETE:
+			if undo {
+				err = db.insertRow(ch.table, t, ch.old_rowid, ch.old)
+			} else {
+				err = db.deleteRow(ch.table, t, ch.old_rowid, ch.old)
+			}
+		case sqlite3.SQLITE_UPDATE:
+			if undo {
+				err = db.updateRow(ch.table, t, ch.new_rowid, ch.new, ch.old_rowid, ch.old)
+			} else {
+				err = db.updateRow(ch.table, t, ch.old_rowid, ch.old, ch.new_rowid, ch.new)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pending writes become own step
+func (db *Db) commitPending() error {
+	if db.tx == nil || db.explicit != nil {
+		return nil
+	}
+	return db.Commit()
+}
+
+func (db *Db) applyStep(step *DbUndoStep, undo bool) error {
+	if db.explicit != nil {
+		return fmt.Errorf("db(%s) is locked by transaction of asset(%s)", db.name, db.explicit.asset.name)
+	}
+
+	db.undo.off = true
+	defer func() { db.undo.off = false }()
+
+	err := db.exec("SAVEPOINT _sa_undo")
+	if err != nil {
+		return err
+	}
+
+	err = db.applyChanges(step.changes, undo)
+	if err != nil {
+		db.exec("ROLLBACK TO _sa_undo")
+		db.exec("RELEASE _sa_undo")
+		db.Commit()
+		db.undo.Clear() //rows were changed by something, which wasn't recorded
+		return err
+	}
+
+	err = db.exec("RELEASE _sa_undo")
+	if err != nil {
+		return err
+	}
+	tables := db.tx_tables
+
+	err = db.Commit()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for t := range tables {
+		names = append(names, t)
+	}
+	db.NotifyChange(names)
+	return nil
+}
+
+// moves app's last step from one stack to other. Returns false when there is nothing to move.
+func (db *Db) moveStep(app *App, redo bool) (bool, error) {
+	if !DbUndo_ENABLED {
+		return false, errors.New("undo requires build with 'sqlite_preupdate_hook' tag")
+	}
+
+	err := db.commitPending()
+	if err != nil {
+		return false, err
+	}
+
+	from, to := &db.undo.undo, &db.undo.redo
+	if redo {
+		from, to = to, from
+	}
+	i := DbUndo_findStep(*from, app)
+	if i < 0 {
+		return false, nil
+	}
+
+	step := (*from)[i]
+	err = db.applyStep(step, !redo)
+	if err != nil {
+		return false, err
+	}
+
+	*from = append((*from)[:i], (*from)[i+1:]...)
+	step.ticks = OsTicks()
+	*to = append(*to, step)
+	return true, nil
+}
+
+// returns false when app has nothing to undo
+func (db *Db) Undo(app *App) (bool, error) {
+	return db.moveStep(app, false)
+}
+
+// returns false when app has nothing to redo
+func (db *Db) Redo(app *App) (bool, error) {
+	return db.moveStep(app, true)
+}
+
+// Ctrl+Z/Ctrl+Y outside of editbox. Undo goes to db with most recent step of focused app.
+func (root *Root) UndoDbs(redo bool) {
+	app := root.focus_app
+	if app == nil {
+		return
+	}
+
+	var last *Db
+	lastTicks := 0
+	for _, db := range root.dbs {
+		if db == root.settings.db {
+			continue
+		}
+
+		stack := db.undo.undo
+		if redo {
+			stack = db.undo.redo
+		}
+		i := DbUndo_findStep(stack, app)
+		if i >= 0 && (last == nil || stack[i].ticks > lastTicks) {
+			last = db
+			lastTicks = stack[i].ticks
+		}
+	}
+	if last == nil {
+		return
+	}
+
+	var err error
+	if redo {
+		_, err = last.Redo(app)
+	} else {
+		_, err = last.Undo(app)
+	}
+	if err != nil {
+		fmt.Printf("Undo(%s) failed: %v\n", last.name, err)
+	}
+}
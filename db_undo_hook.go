@@ -0,0 +1,55 @@
+//go:build sqlite_preupdate_hook
+
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const DbUndo_ENABLED = true
+
+func (db *Db) registerUndoHook(conn *sqlite3.SQLiteConn) {
+	conn.RegisterPreUpdateHook(func(data sqlite3.SQLitePreUpdateData) {
+		if data.DatabaseName != "main" {
+			return
+		}
+		if data.Depth() > 0 {
+			return //made by trigger, which runs again when change is replayed
+		}
+
+		ch := DbChange{op: data.Op, table: data.TableName, old_rowid: data.OldRowID, new_rowid: data.NewRowID, app: db.policy_app}
+		if data.Op == sqlite3.SQLITE_UPDATE || data.Op == sqlite3.SQLITE_DELETE {
+			ch.old = make([]interface{}, data.Count())
+			err := data.Old(ch.old...)
+			if err != nil {
+				fmt.Printf("Old() failed: %v\n", err)
+			}
+		}
+		if data.Op == sqlite3.SQLITE_UPDATE || data.Op == sqlite3.SQLITE_INSERT {
+			ch.new = make([]interface{}, data.Count())
+			err := data.New(ch.new...)
+			if err != nil {
+				fmt.Printf("New() failed: %v\n", err)
+			}
+		}
+		db.undo.add(ch)
+	})
+}
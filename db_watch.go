@@ -0,0 +1,267 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const Db_WATCH_DELAY = 500 //ms between checks of file
+
+// Triggers increase version of table, which was written. When other process commits, changed
+// tables are found by comparing versions.
+const DbWatch_CHANGES = "_sa_changes"
+
+func DbWatch_triggerName(tp string, table string) string {
+	return "_sa_changes_" + tp + "_" + table
+}
+
+// creates triggers for tables, which don't have them yet. Nothing is written when all exist.
+func (db *Db) installChanges(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT m.name FROM sqlite_master m WHERE m.type='table' AND m.sql NOT LIKE 'CREATE VIRTUAL%' AND m.name NOT LIKE '\\_sa\\_%' ESCAPE '\\' AND m.name NOT LIKE '\\_history\\_%' ESCAPE '\\' AND m.name NOT LIKE 'sqlite\\_%' ESCAPE '\\'" +
+		" AND NOT EXISTS(SELECT 1 FROM sqlite_master t WHERE t.type='trigger' AND t.name='_sa_changes_del_'||m.name)")
+	if err != nil {
+		return fmt.Errorf("query SELECT(tables) failed: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("Scan() failed: %w", err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if len(tables) == 0 {
+		return rows.Err()
+	}
+
+	_, err = tx.Exec("CREATE TABLE IF NOT EXISTS " + DbWatch_CHANGES + "(tbl TEXT PRIMARY KEY, version INTEGER NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("CREATE TABLE(%s) failed: %w", DbWatch_CHANGES, err)
+	}
+
+	for _, t := range tables {
+		bump := " BEGIN INSERT INTO " + DbWatch_CHANGES + "(tbl, version) VALUES(" + DbSync_quoteLiteral(t) + ", 1) ON CONFLICT(tbl) DO UPDATE SET version=version+1; END"
+		for _, tp := range []string{"INSERT", "UPDATE", "DELETE"} {
+			name := DbWatch_triggerName(strings.ToLower(tp[:3]), t)
+			_, err := tx.Exec("CREATE TRIGGER IF NOT EXISTS " + DbUndo_quoteName(name) + " AFTER " + tp + " ON " + DbUndo_quoteName(t) + bump)
+			if err != nil {
+				return fmt.Errorf("CREATE TRIGGER(%s) failed: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// called after schema was changed by own connection
+func (db *Db) InstallChanges() error {
+	//host's statements
+	app := db.policy_app
+	db.policy_app = nil
+	defer func() { db.policy_app = app }()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	return db.installChanges(tx)
+}
+
+// returns versions of tables and schema. Versions are nil when table doesn't exist yet.
+func (db *Db) readChanges() (map[string]int64, int64, error) {
+	var schema int64
+	err := db.watch.QueryRowContext(db.root.ctx, "PRAGMA schema_version").Scan(&schema)
+	if err != nil {
+		return nil, -1, fmt.Errorf("schema_version(%s) failed: %w", db.GetPath(), err)
+	}
+
+	rows, err := db.watch.QueryContext(db.root.ctx, "SELECT tbl, version FROM "+DbWatch_CHANGES)
+	if err != nil {
+		return nil, schema, nil //no triggers yet
+	}
+	defer rows.Close()
+
+	versions := make(map[string]int64)
+	for rows.Next() {
+		var tbl string
+		var v int64
+		err := rows.Scan(&tbl, &v)
+		if err != nil {
+			return nil, -1, fmt.Errorf("Scan() failed: %w", err)
+		}
+		versions[strings.ToLower(tbl)] = v
+	}
+	return versions, schema, rows.Err()
+}
+
+// returns tables, which were changed since last read(nil = unknown)
+func (db *Db) diffChanges() []string {
+	versions, schema, err := db.readChanges()
+	if err != nil {
+		fmt.Printf("readChanges() failed: %v\n", err)
+		return nil
+	}
+	old, oldSchema := db.changes, db.schema_version
+	db.changes, db.schema_version = versions, schema
+
+	if versions == nil || old == nil || schema != oldSchema {
+		return nil
+	}
+
+	var tables []string
+	for t, v := range versions {
+		if old[t] != v {
+			tables = append(tables, t)
+		}
+	}
+	if len(tables) == 0 {
+		return nil //table without triggers was changed
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// 'data_version' changes when other connection(process) commits into file. Own commits are
+// done through other connection too, so Commit() must call updateDataVersion().
+func (db *Db) getDataVersion() (int64, error) {
+	if db.watch == nil {
+		var err error
+		db.watch, err = db.db.Conn(db.root.ctx)
+		if err != nil {
+			return -1, fmt.Errorf("Conn(%s) failed: %w", db.GetPath(), err)
+		}
+	}
+
+	var v int64
+	err := db.watch.QueryRowContext(db.root.ctx, "PRAGMA data_version").Scan(&v)
+	if err != nil {
+		return -1, fmt.Errorf("data_version(%s) failed: %w", db.GetPath(), err)
+	}
+	return v, nil
+}
+
+func (db *Db) updateDataVersion() {
+	v, err := db.getDataVersion()
+	if err != nil {
+		fmt.Printf("getDataVersion() failed: %v\n", err)
+		return
+	}
+	db.data_version = v
+	db.UpdateTime()
+
+	db.diffChanges() //own changes aren't reported later
+}
+
+func (db *Db) closeWatch() {
+	if db.watch != nil {
+		db.watch.Close()
+		db.watch = nil
+	}
+}
+
+// returns true if file was changed by other connection and tables, which were changed(nil = unknown)
+func (db *Db) CheckExternalChange() (bool, []string) {
+	if db.tx != nil || OsIsTicksIn(db.watch_ticks, Db_WATCH_DELAY) {
+		return false, nil
+	}
+	db.watch_ticks = OsTicks()
+
+	//cheap check first
+	if !db.UpdateTime() && db.data_version >= 0 {
+		return false, nil
+	}
+
+	v, err := db.getDataVersion()
+	if err != nil {
+		fmt.Printf("getDataVersion() failed: %v\n", err)
+		return false, nil
+	}
+
+	first := (db.data_version < 0)
+	changed := (!first && v != db.data_version)
+	db.data_version = v
+
+	var tables []string
+	if first || changed {
+		tables = db.diffChanges()
+	}
+	return changed, tables
+}
+
+func (db *Db) AddAsset(asset *Asset) {
+	if db.assets == nil {
+		db.assets = make(map[*Asset]bool)
+	}
+	db.assets[asset] = true
+}
+
+func (db *Db) RemoveAsset(asset *Asset) {
+	delete(db.assets, asset)
+	delete(db.migrate_pending, asset)
+	db.removeSubscriptions(asset)
+}
+
+// tables is empty when they are unknown
+func (db *Db) NotifyChange(tables []string) {
+	if len(tables) == 0 {
+		db.resetCache()
+		db.markAllSubscriptions()
+
+		//other process could change columns
+		err := db.rebuildHistory()
+		if err != nil {
+			fmt.Printf("rebuildHistory(%s) failed: %v\n", db.name, err)
+		}
+	} else {
+		changed := make(map[string]bool)
+		for _, t := range tables {
+			changed[t] = true
+		}
+		db.resetCacheTables(changed)
+		db.markSubscriptions(changed)
+	}
+	db.root.ui.ResetImagesFromDb(db.name)
+
+	for asset := range db.assets {
+		asset.CallSet2([]byte(db.name), []byte(strings.Join(tables, "/")), "_sa_db_changed")
+	}
+
+	db.root.ui.SetRedraw()
+}
+
+func (root *Root) CheckDbsChanges() {
+	for _, db := range root.dbs {
+		db.updateQuickCheck()
+
+		changed, tables := db.CheckExternalChange()
+		if !changed {
+			continue
+		}
+
+		//host's sync connection isn't other process
+		if root.sync == nil || !root.sync.TakeChanged(db.name) {
+			db.undo.Clear() //recorded rows may not exist anymore
+		}
+		db.NotifyChange(tables)
+	}
+}
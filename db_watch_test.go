@@ -0,0 +1,59 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestWatchExternalChange(t *testing.T) {
+	db := newTestDb(t)
+
+	testWrite(t, db, "CREATE TABLE a(x INTEGER)")
+	testWrite(t, db, "CREATE TABLE b(y INTEGER)")
+	testCommit(t, db)
+
+	//own commit isn't external change
+	testWrite(t, db, "INSERT INTO b VALUES(1)")
+	testCommit(t, db)
+	db.watch_ticks = 0
+	if changed, _ := db.CheckExternalChange(); changed {
+		t.Fatal("own commit is reported as external")
+	}
+
+	//other process
+	other, err := sql.Open("sqlite3", db.getDsn())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	_, err = other.Exec("INSERT INTO a VALUES(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.watch_ticks = 0
+	db.lastChange = 0 //file time could be in same millisecond
+	changed, tables := db.CheckExternalChange()
+	if !changed {
+		t.Fatal("external commit wasn't detected")
+	}
+	if len(tables) != 1 || tables[0] != "a" {
+		t.Fatalf("wrong changed tables: %v", tables)
+	}
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -135,6 +137,7 @@ type Ini struct {
 
 	Fullscreen bool
 	Stats      bool
+	Queries    bool
 	Grid       bool
 
 	Languages              []string
@@ -142,6 +145,13 @@ type Ini struct {
 
 	Hosting_enable bool
 	Hosting_addr   string
+
+	Sync_enable bool
+	Sync_addr   string   //listen
+	Sync_peers  []string //addresses of other devices
+	Sync_secret string   //same on paired devices, generated on first run
+
+	Approved []string //apps allowed by user("name:capabilities")
 }
 
 type IO struct {
@@ -263,6 +273,18 @@ func (io *IO) _IO_setDefault() error {
 		io.ini.Hosting_enable = true
 	}
 
+	if len(io.ini.Sync_addr) == 0 {
+		io.ini.Sync_addr = "localhost:8092"
+	}
+	if len(io.ini.Sync_secret) == 0 {
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return fmt.Errorf("rand.Read() failed: %w", err)
+		}
+		io.ini.Sync_secret = hex.EncodeToString(secret)
+	}
+
 	return nil
 }
 
@@ -23,8 +23,13 @@ type LayoutLevels struct {
 	calls   []*LayoutLevel
 
 	infoLayout RS_LScroll
+
+	keep bool           //unused dialogs and divs are not removed in next Maintenance()
+	kept map[string]int //dialogs, which were open when module was swapped. They stay open until new module draws them(or ticks are reached)
 }
 
+const LayoutLevels_KEEP_MS = 5000
+
 func NewLayoutLevels(scrollPath string, ui *Ui) (*LayoutLevels, error) {
 
 	var levels LayoutLevels
@@ -99,6 +104,26 @@ func (levels *LayoutLevels) isSomeClose() bool {
 	return false
 }
 
+// Called when asset's module was swapped
+func (levels *LayoutLevels) Keep() {
+	levels.keep = true
+
+	if levels.kept == nil {
+		levels.kept = make(map[string]int)
+	}
+	for _, l := range levels.dialogs[1:] {
+		levels.kept[l.name] = OsTicks() + LayoutLevels_KEEP_MS
+	}
+}
+
+func (levels *LayoutLevels) isKept(l *LayoutLevel) bool {
+	if levels.keep {
+		return true
+	}
+	ticks, found := levels.kept[l.name]
+	return found && OsTicks() < ticks
+}
+
 func (levels *LayoutLevels) Maintenance() {
 
 	levels.GetBaseDialog().use = 1 //base level is always use
@@ -107,7 +132,7 @@ func (levels *LayoutLevels) Maintenance() {
 	if levels.isSomeClose() {
 		var lvls []*LayoutLevel
 		for _, l := range levels.dialogs {
-			if l.use != 0 && !l.close {
+			if (l.use != 0 || levels.isKept(l)) && !l.close {
 				lvls = append(lvls, l)
 			}
 		}
@@ -115,11 +140,23 @@ func (levels *LayoutLevels) Maintenance() {
 
 	}
 
+	//drawn by new module, closed or expired
+	for name, ticks := range levels.kept {
+		l := levels.Find(name)
+		if l == nil || l.use != 0 || OsTicks() >= ticks {
+			delete(levels.kept, name)
+		}
+	}
+
 	//layout
 	for _, l := range levels.dialogs {
-		l.rootDiv.Maintenance(&levels.infoLayout)
+		kept := levels.keep || (l.use == 0 && levels.isKept(l))
+		if !kept {
+			l.rootDiv.Maintenance(&levels.infoLayout)
+		}
 		l.use = 0
 	}
+	levels.keep = false
 }
 
 func (levels *LayoutLevels) DrawDialogs() {
@@ -0,0 +1,75 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+// base level and one dialog with one div, which were drawn in last frame
+func newTestLevels() (*LayoutLevels, *LayoutDiv) {
+	levels := &LayoutLevels{}
+	levels.AddDialog("", OsV4{}, nil)
+	levels.AddDialog("picker", OsV4{}, nil)
+	div := levels.Find("picker").rootDiv.FindOrCreate("list", OsV4{}, &levels.infoLayout)
+	levels.Maintenance()
+	return levels, div
+}
+
+func testDrawDialog(levels *LayoutLevels, div *LayoutDiv) {
+	levels.Find("picker").use = 1
+	div.Use()
+}
+
+func TestLevelsKeepAcrossSwap(t *testing.T) {
+	levels, div := newTestLevels()
+	testDrawDialog(levels, div)
+	levels.Maintenance()
+
+	//new module doesn't draw dialog in first frames
+	levels.Keep()
+	for i := 0; i < 3; i++ {
+		levels.Maintenance()
+		dialog := levels.Find("picker")
+		if dialog == nil || len(dialog.rootDiv.childs) != 1 {
+			t.Fatalf("dialog or its layout was lost in frame %d", i)
+		}
+	}
+
+	//drawn by new module, so it's normal dialog again
+	testDrawDialog(levels, div)
+	levels.Maintenance()
+	if len(levels.kept) != 0 {
+		t.Fatal("drawn dialog is still kept")
+	}
+	levels.Maintenance()
+	if levels.Find("picker") != nil {
+		t.Fatal("unused dialog wasn't closed")
+	}
+}
+
+func TestLevelsKeepExpires(t *testing.T) {
+	levels, div := newTestLevels()
+	testDrawDialog(levels, div)
+	levels.Maintenance()
+
+	levels.Keep()
+	levels.Maintenance()
+	levels.kept["picker"] = OsTicks() - 1 //new module never drew it
+	levels.Maintenance()
+	if levels.Find("picker") != nil || len(levels.kept) != 0 {
+		t.Fatal("expired dialog wasn't closed")
+	}
+}
@@ -18,10 +18,13 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tetratelabs/wazero"
@@ -36,6 +39,11 @@ type Root struct {
 	cacheDir      string
 	cache         wazero.CompilationCache
 	runtimeConfig wazero.RuntimeConfig
+	rt            wazero.Runtime           //shared by all assets
+	compiled      map[string]*WasmCompiled //key is path to wasm file
+	cacheIndex    *WasmCacheIndex
+	warm          map[string]chan struct{} //closed when warm goroutine compiled path
+	wasm_mods     int                      //counter for unique names of modules
 
 	apps []*App
 	dbs  map[string]*Db
@@ -44,6 +52,7 @@ type Root struct {
 	appsList string
 
 	last_ticks int64
+	frame      int64 //Tick() counter
 
 	levels *LayoutLevels
 
@@ -60,9 +69,18 @@ type Root struct {
 	ui_info Info
 	vm_info Info
 
+	querylog DbQueryLog
+
 	editbox_history VmTextHistoryArray
 
 	server *DebugServer
+	sync   *DbSync
+
+	snapshots DbSnapshots
+
+	wasm_mu sync.Mutex //held by frame, workers' SQL functions call assets between frames
+
+	focus_app *App //last touched, Ctrl+Z/Ctrl+Y undo its changes
 
 	settings *DbSettings
 
@@ -104,15 +122,22 @@ func NewRoot(debugPORT int, folderApps string, folderDbs string, folderDevice st
 	}
 
 	// init wasm
-	root.cacheDir, err = os.MkdirTemp("", "wasm_cache")
+	root.cacheDir = folderDevice + "/" + WasmCache_FOLDER
+	err = WasmCache_evict(root.cacheDir, WasmCache_MAX_BYTES) //before cache is opened
 	if err != nil {
-		return nil, fmt.Errorf("MkdirTemp() failed: %w", err)
+		fmt.Printf("WasmCache_evict() failed: %v\n", err)
 	}
+	root.cacheIndex = NewWasmCacheIndex(root.cacheDir)
 	root.cache, err = wazero.NewCompilationCacheWithDir(root.cacheDir)
 	if err != nil {
 		return nil, fmt.Errorf("NewCompilationCacheWithDir() failed: %w", err)
 	}
-	root.runtimeConfig = wazero.NewRuntimeConfig().WithCompilationCache(root.cache)
+	root.runtimeConfig = wazero.NewRuntimeConfig().WithCompilationCache(root.cache).WithCloseOnContextDone(true).WithMemoryLimitPages(AssetWasm_MAX_PAGES)
+	root.compiled = make(map[string]*WasmCompiled)
+	err = root.InstantiateEnv()
+	if err != nil {
+		return nil, fmt.Errorf("InstantiateEnv() failed: %w", err)
+	}
 
 	iniPath, scrollPath, err := root.GetSettingsPaths()
 	if err != nil {
@@ -132,6 +157,8 @@ func NewRoot(debugPORT int, folderApps string, folderDbs string, folderDevice st
 	root.baseApp = "base"
 	root.baseDb = "settings"
 
+	root.startWarmWasmCache(root.getWasmPaths())
+
 	root.updateDbsList()
 	root.updateAppsList()
 
@@ -140,6 +167,18 @@ func NewRoot(debugPORT int, folderApps string, folderDbs string, folderDevice st
 		return nil, fmt.Errorf("NewDebugServer() failed: %w", err)
 	}
 
+	device, err := root.GetDeviceId()
+	if err != nil {
+		return nil, fmt.Errorf("GetDeviceId() failed: %w", err)
+	}
+	root.sync = NewDbSync(root.folderDatabases, device, root.baseDb, root.ui.io.ini.Sync_secret)
+	if root.ui.io.ini.Sync_enable {
+		err = root.sync.Listen(root.ui.io.ini.Sync_addr)
+		if err != nil {
+			fmt.Printf("sync Listen() failed: %v\n", err)
+		}
+	}
+
 	return &root, nil
 }
 func (root *Root) Destroy() {
@@ -151,6 +190,7 @@ func (root *Root) Destroy() {
 	if root.server != nil {
 		root.server.Destroy()
 	}
+	root.sync.Destroy()
 
 	for nm, db := range root.dbs {
 		err := db.Destroy()
@@ -161,8 +201,8 @@ func (root *Root) Destroy() {
 
 	root.fonts.Destroy()
 
+	root.rt.Close(root.ctx)
 	root.cache.Close(root.ctx)
-	os.RemoveAll(root.cacheDir)
 
 	//save settings
 	{
@@ -199,6 +239,33 @@ func (root *Root) GetSettingsPaths() (string, string, error) {
 	return root.folderDevice + "/" + dev + "_ini.json", "device/" + dev + "_scroll.json", nil
 }
 
+// random id, which is created on first run
+func (root *Root) GetDeviceId() (string, error) {
+	dev, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("Hostname() failed: %w", err)
+	}
+	path := root.folderDevice + "/" + dev + "_id.txt"
+
+	id, err := os.ReadFile(path)
+	if err == nil && len(id) > 0 {
+		return strings.TrimSpace(string(id)), nil
+	}
+
+	buff := make([]byte, 16)
+	_, err = rand.Read(buff)
+	if err != nil {
+		return "", fmt.Errorf("Read() failed: %w", err)
+	}
+	str := hex.EncodeToString(buff)
+
+	err = os.WriteFile(path, []byte(str), 0644)
+	if err != nil {
+		return "", fmt.Errorf("WriteFile(%s) failed: %w", path, err)
+	}
+	return str, nil
+}
+
 func (root *Root) FindAppId(sts_id int) *App {
 	for _, app := range root.apps {
 		if app.sts_id == sts_id {
@@ -254,6 +321,15 @@ func (root *Root) AddDb(name string) (*Db, error) {
 		return nil, err
 	}
 
+	if OsFileExists(db.GetPath()) {
+		db.StartQuickCheck()
+
+		err = db.InstallChanges() //committed at the end of frame
+		if err != nil {
+			fmt.Printf("InstallChanges(%s) failed: %v\n", name, err)
+		}
+	}
+
 	root.dbs[name] = db
 	return db, nil
 }
@@ -343,10 +419,11 @@ func (root *Root) DuplicateDb(name string, newName string) bool {
 		return false
 	}
 
-	//duplicate file
-	err := OsFileCopy(path, newPath)
+	//duplicate file(with WAL)
+	err := root.vacuumInto(name, newPath)
 	if err != nil {
-		fmt.Printf("OsFileCopy(%s) failed: %v\n", path, err)
+		fmt.Printf("vacuumInto(%s) failed: %v\n", path, err)
+		return false
 	}
 
 	root.updateDbsList()
@@ -355,34 +432,45 @@ func (root *Root) DuplicateDb(name string, newName string) bool {
 
 func (root *Root) RemoveDb(name string) bool {
 
-	//finds
+	path := root.folderDatabases + "/" + name + ".sqlite"
+	if !OsFileExists(path) {
+		fmt.Printf("db(%s) not exist\n", name)
+		return false
+	}
 	db, found := root.dbs[name]
+	if found && db.explicit != nil {
+		fmt.Printf("db(%s) is locked by transaction of asset(%s)\n", name, db.explicit.asset.name)
+		return false
+	}
 	if found {
-		//close
-		err := db.Destroy()
+		err := db.commitPending()
 		if err != nil {
-			fmt.Printf("db(%s).Destroy() failed: %v\n", name, err)
+			fmt.Printf("commitPending(%s) failed: %v\n", name, err)
+			return false
 		}
-		delete(root.dbs, name)
 	}
+	root.snapshots.Wait(name) //reads file
 
-	//delete file
-	path := root.folderDatabases + "/" + name + ".sqlite"
-	err := OsFileRemove(path)
+	//close, so WAL is checkpointed
+	state := root.closeDb(name)
+
+	//move file into trash
+	_, err := root.TrashDb(name)
 	if err != nil {
-		fmt.Printf("OsFileRemove(%s) failed: %v\n", path, err)
-	}
-	if OsFileExists(path + "-shm") {
-		err = OsFileRemove(path + "-shm")
+		fmt.Printf("TrashDb(%s) failed: %v\n", name, err)
+
+		//db stays
+		err = root.reopenDb(name, state)
 		if err != nil {
-			fmt.Printf("OsFileRemove(%s-shm) failed: %v\n", path, err)
+			fmt.Printf("reopenDb(%s) failed: %v\n", name, err)
 		}
+		return false
 	}
-	if OsFileExists(path + "-wal") {
-		err = OsFileRemove(path + "-wal")
-		if err != nil {
-			fmt.Printf("OsFileRemove(%s-wal) failed: %v\n", path, err)
-		}
+
+	//rows are saved in trash, RestoreTrash() adds them back
+	err = root.settings.RemoveRowsDb(name)
+	if err != nil {
+		fmt.Printf("RemoveRowsDb(%s) failed: %v\n", name, err)
 	}
 
 	root.updateDbsList()
@@ -391,13 +479,23 @@ func (root *Root) RemoveDb(name string) bool {
 
 func (root *Root) CommitDbs() {
 	for _, db := range root.dbs {
-		if db.tx == nil {
-			continue
+		if db.tx != nil {
+			//app's transaction is still open
+			if !db.MaintenanceExplicit() {
+				continue
+			}
+
+			err := db.Commit()
+			if err != nil {
+				fmt.Printf("Commit() failed: %v\n", err)
+			}
+
+			db.runPendingMigrations() //before next frame's writes
 		}
 
-		err := db.Commit()
+		err := db.applyFuncs()
 		if err != nil {
-			fmt.Printf("Commit() failed: %v\n", err)
+			fmt.Printf("applyFuncs() failed: %v\n", err)
 		}
 	}
 }
@@ -436,6 +534,10 @@ func (root *Root) Render() {
 }
 
 func (root *Root) Tick() (bool, error) {
+	root.wasm_mu.Lock()
+	defer root.wasm_mu.Unlock()
+
+	root.frame++
 
 	if time.Now().UnixMilli() > root.last_ticks+2000 {
 		for _, app := range root.apps {
@@ -445,13 +547,31 @@ func (root *Root) Tick() (bool, error) {
 
 		root.updateDbsList()
 		root.updateAppsList()
+		root.MaintenanceSnapshots()
+		root.MaintenanceTrash()
+		root.MaintenanceCheckpoints()
+
+		if root.ui.io.ini.Sync_enable {
+			root.sync.Maintenance(root.ui.io.ini.Sync_peers)
+		}
 	}
 
+	root.UpdateAsyncs()
+
 	run, err := root.ui.UpdateIO()
 	if err != nil {
 		return false, fmt.Errorf("UpdateIO() failed: %w", err)
 	}
 
+	//db undo/redo(editbox has own history)
+	if root.ui.io.edit.uid == nil {
+		if root.ui.io.keys.backward {
+			root.UndoDbs(false)
+		} else if root.ui.io.keys.forward {
+			root.UndoDbs(true)
+		}
+	}
+
 	//tile
 	{
 		if root.tile.NeedsRedrawFromSleep(root.ui.io.touch.pos) {
@@ -500,6 +620,11 @@ func (root *Root) Tick() (bool, error) {
 			root.ui.RenderInfoStats(&root.ui_info, &root.vm_info, root.fonts.Get(SKYALT_FONT_0))
 		}
 
+		// show queries
+		if root.ui.io.ini.Queries {
+			root.ui.RenderInfoQueries(root.querylog.GetText(), root.fonts.Get(SKYALT_FONT_0))
+		}
+
 		root.vm_info.Update(int(OsTicks() - stVmTicks))
 		root.ui.EndRender()
 		root.ui_info.Update(int(OsTicks() - stUiTicks))
@@ -512,10 +637,14 @@ func (root *Root) Tick() (bool, error) {
 		}
 
 	} else {
+		root.wasm_mu.Unlock()
 		time.Sleep(10 * time.Millisecond)
+		root.wasm_mu.Lock()
 	}
 
 	root.CommitDbs()
+	root.CheckDbsChanges()
+	root.UpdateSubscriptions()
 
 	return (run && !root.exit), err
 }
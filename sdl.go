@@ -614,6 +614,11 @@ func (ui *Ui) UpdateIO() (bool, error) {
 		ui.ResendInput()
 	}
 
+	if io.keys.f4 {
+		io.ini.Queries = !io.ini.Queries // switch
+		ui.ResendInput()
+	}
+
 	if io.keys.f3 {
 		io.ini.Grid = !io.ini.Grid // switch
 		ui.ResendInput()
@@ -771,6 +776,12 @@ func (ui *Ui) SetNoSleep() {
 		ui.redraw_num = 0 // redraw
 	}
 }
+func (ui *Ui) SetRedraw() {
+	if ui == nil {
+		return
+	}
+	ui.redraw_num = 0 // redraw
+}
 func (ui *Ui) SetLayoutChange() {
 	ui.skip_draw_on_screen = true
 	ui.redraw_num = 0 // redraw
@@ -903,3 +914,31 @@ func (ui *Ui) RenderInfoStats(ui_info *Info, vm_info *Info, font *Font) error {
 
 	return nil
 }
+
+func (ui *Ui) RenderInfoQueries(text string, font *Font) error {
+	if ui == nil {
+		return nil
+	}
+
+	textH := ui.io.GetDPI() / 8
+
+	num_lines := strings.Count(text, "\n") + 1
+	lineH := int(float32(textH) * 1.7)
+	sz, _ := font.GetTextSize(text, textH, lineH)
+
+	cq := OsV4{OsV2{lineH, lineH}, sz}
+
+	err := ui.render.SetClipRect(cq.GetSDLRect())
+	if err != nil {
+		fmt.Printf("SetClipRect() failed: %v\n", err)
+	}
+	_Ui_boxSE(ui.render, cq.Start, cq.End(), OsCd_white())
+	_Ui_boxSE_border(ui.render, cq.Start, cq.End(), OsCd_black(), 1)
+	cq.Size.Y /= num_lines
+	err = font.Print(text, textH, cq, OsV2{0, 1}, OsCd_black(), nil, ui.render)
+	if err != nil {
+		fmt.Printf("Print() failed: %v\n", err)
+	}
+
+	return nil
+}
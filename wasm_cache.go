@@ -0,0 +1,257 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// Compiled modules are kept in folderDevice between launches. wazero names files by hash of module
+// inside directory with its version, so new version of module or runtime is compiled again.
+const WasmCache_FOLDER = "wasm_cache"
+const WasmCache_MAX_BYTES = 512 * 1024 * 1024
+const WasmCache_INDEX = "index.json" //hash of wasm -> compiled file
+
+type WasmCacheFile struct {
+	path string
+	size int64
+	tm   int64
+}
+
+// compiled module is shared by all assets, which use the same wasm file
+type WasmCompiled struct {
+	mod wazero.CompiledModule
+	tm  int64 //modification time of file
+}
+
+// wazero doesn't touch files it reads, so index remembers which file belongs to which module and
+// compile() updates its modification time. Eviction then removes least recently used files.
+type WasmCacheIndex struct {
+	lock  sync.Mutex
+	dir   string
+	files map[string]string //key is sha256 of wasm
+}
+
+func NewWasmCacheIndex(dir string) *WasmCacheIndex {
+	idx := &WasmCacheIndex{dir: dir, files: make(map[string]string)}
+
+	js, err := os.ReadFile(filepath.Join(dir, WasmCache_INDEX))
+	if err == nil {
+		err = json.Unmarshal(js, &idx.files)
+		if err != nil {
+			fmt.Printf("Unmarshal(%s) failed: %v\n", WasmCache_INDEX, err)
+		}
+	}
+
+	//evicted files
+	for hash, path := range idx.files {
+		if !OsFileExists(path) {
+			delete(idx.files, hash)
+		}
+	}
+	return idx
+}
+
+func (idx *WasmCacheIndex) save() {
+	js, err := json.Marshal(idx.files)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(idx.dir, WasmCache_INDEX), js, 0644)
+	}
+	if err != nil {
+		fmt.Printf("WriteFile(%s) failed: %v\n", WasmCache_INDEX, err)
+	}
+}
+
+func (idx *WasmCacheIndex) listFiles() map[string]bool {
+	files := make(map[string]bool)
+	filepath.WalkDir(idx.dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files[path] = true
+		}
+		return nil
+	})
+	return files
+}
+
+// compilations are serialized, so new file in cache belongs to compiled module
+func (idx *WasmCacheIndex) compile(ctx context.Context, rt wazero.Runtime, wasm []byte) (wazero.CompiledModule, error) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	h := sha256.Sum256(wasm)
+	hash := hex.EncodeToString(h[:])
+
+	path, found := idx.files[hash]
+	var before map[string]bool
+	if !found {
+		before = idx.listFiles()
+	}
+
+	mod, err := rt.CompileModule(ctx, wasm)
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		tm := time.Now()
+		err = os.Chtimes(path, tm, tm)
+		if err == nil {
+			return mod, nil
+		}
+		delete(idx.files, hash) //evicted by wazero
+	}
+
+	for p := range idx.listFiles() {
+		if !before[p] && filepath.Base(p) != WasmCache_INDEX {
+			idx.files[hash] = p
+			idx.save()
+			break
+		}
+	}
+	return mod, nil
+}
+
+func (root *Root) compileWasm(path string, tm int64) (wazero.CompiledModule, error) {
+	c, found := root.compiled[path]
+	if found && c.tm == tm {
+		return c.mod, nil
+	}
+
+	//warm goroutine writes it into cache
+	if done, found := root.warm[path]; found {
+		<-done
+	}
+
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile(%s) failed: %w", path, err)
+	}
+	mod, err := root.cacheIndex.compile(root.ctx, root.rt, wasm)
+	if err != nil {
+		return nil, fmt.Errorf("CompileModule(%s) failed: %w", path, err)
+	}
+
+	if found {
+		c.mod.Close(root.ctx) //instances of old version keep running
+	}
+	root.compiled[path] = &WasmCompiled{mod: mod, tm: tm}
+	return mod, nil
+}
+
+// removes least recently used files until cache fits into 'maxBytes'. Files of old wazero versions are never touched, so they go first.
+// Must run before runtime opens cache.
+func WasmCache_evict(dir string, maxBytes int64) error {
+	var files []WasmCacheFile
+	total := int64(0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil //first launch
+		}
+		if err != nil || d.IsDir() || d.Name() == WasmCache_INDEX {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, WasmCacheFile{path: path, size: info.Size(), tm: info.ModTime().UnixMilli()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("WalkDir(%s) failed: %w", dir, err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].tm < files[j].tm })
+	for _, f := range files {
+		if total <= maxBytes {
+			break
+		}
+		err := os.Remove(f.path)
+		if err != nil {
+			return fmt.Errorf("Remove(%s) failed: %w", f.path, err)
+		}
+		total -= f.size
+	}
+	return nil
+}
+
+// returns main.wasm of every asset in apps folder, base app first
+func (root *Root) getWasmPaths() []string {
+	var paths []string
+	apps, _ := os.ReadDir(root.folderApps)
+	for _, app := range apps {
+		if !app.IsDir() {
+			continue
+		}
+		assets, _ := os.ReadDir(root.folderApps + "/" + app.Name())
+		for _, asset := range assets {
+			path := root.folderApps + "/" + app.Name() + "/" + asset.Name() + "/main.wasm"
+			if !asset.IsDir() || !OsFileExists(path) {
+				continue
+			}
+			if app.Name() == root.baseApp {
+				paths = append([]string{path}, paths...)
+			} else {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// starts background compilation, so assets don't wait for it. compileWasm() waits until path is written into cache.
+func (root *Root) startWarmWasmCache(paths []string) {
+	dones := make([]chan struct{}, len(paths))
+	root.warm = make(map[string]chan struct{})
+	for i, path := range paths {
+		dones[i] = make(chan struct{})
+		root.warm[path] = dones[i]
+	}
+
+	go root.warmWasmCache(paths, dones)
+}
+
+func (root *Root) warmWasmCache(paths []string, dones []chan struct{}) {
+	rt := wazero.NewRuntimeWithConfig(root.ctx, root.runtimeConfig)
+	defer rt.Close(root.ctx)
+
+	for i, path := range paths {
+		wasm, err := os.ReadFile(path)
+		if err == nil { //asset can be removed meanwhile
+			mod, err := root.cacheIndex.compile(root.ctx, rt, wasm)
+			if err != nil {
+				fmt.Printf("CompileModule(%s) failed: %v\n", path, err)
+			} else {
+				mod.Close(root.ctx)
+			}
+		}
+		close(dones[i])
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this db except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testCacheFile(t *testing.T, path string, size int, age time.Duration) {
+	t.Helper()
+
+	err := os.WriteFile(path, make([]byte, size), 0644)
+	if err == nil {
+		tm := time.Now().Add(-age)
+		err = os.Chtimes(path, tm, tm)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWasmCacheEvict(t *testing.T) {
+	dir := t.TempDir()
+	err := WasmCache_evict(filepath.Join(dir, "missing"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := filepath.Join(dir, "wazero-v1")
+	err = os.Mkdir(sub, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldest := filepath.Join(sub, "a")
+	middle := filepath.Join(sub, "b")
+	newest := filepath.Join(sub, "c")
+	testCacheFile(t, oldest, 100, 3*time.Hour)
+	testCacheFile(t, middle, 100, 2*time.Hour)
+	testCacheFile(t, newest, 100, time.Hour)
+
+	js, _ := json.Marshal(map[string]string{"hashA": oldest, "hashC": newest})
+	err = os.WriteFile(filepath.Join(dir, WasmCache_INDEX), js, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WasmCache_evict(dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if OsFileExists(oldest) || !OsFileExists(middle) || !OsFileExists(newest) {
+		t.Fatal("least recently used file wasn't evicted")
+	}
+	if !OsFileExists(filepath.Join(dir, WasmCache_INDEX)) {
+		t.Fatal("index was evicted")
+	}
+
+	//index forgets evicted files
+	idx := NewWasmCacheIndex(dir)
+	if _, found := idx.files["hashA"]; found || idx.files["hashC"] != newest {
+		t.Fatalf("wrong index: %v", idx.files)
+	}
+}